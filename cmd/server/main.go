@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
@@ -10,22 +9,27 @@ import (
 
 	"github.com/emPeeGee/raffinance/internal/account"
 	"github.com/emPeeGee/raffinance/internal/analytics"
+	"github.com/emPeeGee/raffinance/internal/anomaly"
 	"github.com/emPeeGee/raffinance/internal/auth"
 	"github.com/emPeeGee/raffinance/internal/category"
 	"github.com/emPeeGee/raffinance/internal/config"
 	"github.com/emPeeGee/raffinance/internal/connection"
 	"github.com/emPeeGee/raffinance/internal/contact"
 	"github.com/emPeeGee/raffinance/internal/cors"
+	"github.com/emPeeGee/raffinance/internal/digest"
 	"github.com/emPeeGee/raffinance/internal/entity"
+	"github.com/emPeeGee/raffinance/internal/event"
 	"github.com/emPeeGee/raffinance/internal/hub"
+	"github.com/emPeeGee/raffinance/internal/investment"
 	"github.com/emPeeGee/raffinance/internal/seeder"
 	"github.com/emPeeGee/raffinance/internal/tag"
 	"github.com/emPeeGee/raffinance/internal/transaction"
+	"github.com/emPeeGee/raffinance/internal/webhook"
 	"github.com/emPeeGee/raffinance/pkg/accesslog"
 	"github.com/emPeeGee/raffinance/pkg/errorutil"
 	"github.com/emPeeGee/raffinance/pkg/log"
+	"github.com/emPeeGee/raffinance/pkg/mail"
 	"github.com/emPeeGee/raffinance/pkg/validatorutil"
-	"github.com/gorilla/websocket"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator"
@@ -56,7 +60,7 @@ func main() {
 		logger.Fatalf("failed to initialize db: %s", err.Error())
 	}
 
-	err = db.AutoMigrate(&entity.User{}, &entity.Contact{}, &entity.Account{}, &entity.Transaction{}, &entity.TransactionType{}, &entity.Category{}, &entity.Tag{}, &entity.TransactionTag{})
+	err = db.AutoMigrate(&entity.User{}, &entity.Contact{}, &entity.Account{}, &entity.Transaction{}, &entity.TransactionType{}, &entity.Category{}, &entity.Tag{}, &entity.TransactionTag{}, &entity.Security{}, &entity.SecurityPrice{}, &entity.SecurityEvent{}, &entity.Anomaly{}, &entity.DigestPreference{}, &entity.Webhook{}, &entity.WebhookDelivery{}, &entity.WebsocketTicket{}, &entity.HubMessage{}, &entity.Session{}, &entity.RefreshToken{}, &entity.PasswordResetToken{}, &entity.EmailVerificationToken{}, &entity.TOTP{}, &entity.RecoveryCode{}, &entity.MFAChallenge{}, &entity.ExternalIdentity{}, &entity.ProviderSignIn{}, &entity.LoginCode{}, &entity.PersonalAccessToken{})
 	if err != nil {
 		logger.Fatalf("failed to auto migrate gorm", err.Error())
 	}
@@ -76,15 +80,54 @@ func main() {
 		logger.Fatalf("failed to register transaction type validator: %s", err.Error())
 	}
 
+	if err := valid.RegisterValidation("webhookevent", webhook.ValidateEvent); err != nil {
+		logger.Fatalf("failed to register webhook event validator: %s", err.Error())
+	}
+
+	if err := valid.RegisterValidation("tokenscope", auth.ValidateScope); err != nil {
+		logger.Fatalf("failed to register token scope validator: %s", err.Error())
+	}
+
 	// TODO: Error handling here
 	valid.RegisterStructValidation(transaction.ValidateCreateTransaction, transaction.CreateTransactionDTO{})
 	valid.RegisterStructValidation(transaction.ValidateUpdateTransaction, transaction.UpdateTransactionDTO{})
 	valid.RegisterStructValidation(analytics.ValidateDateRange, analytics.RangeDateParams{})
 
-	hub := hub.NewHub()
+	keys, err := auth.NewKeys(cfg.Auth, logger)
+	if err != nil {
+		logger.Fatalf("failed to load the JWT keys: %s", err.Error())
+	}
+
+	var backend hub.Backend = hub.NewMemoryBackend()
+	if cfg.Websocket.Backend == "postgres" {
+		backend = hub.NewPostgresBackend(db, connection.DSN(cfg.DB), logger)
+	}
+
+	wsHub := hub.NewHub(backend, logger)
+	bus := event.NewBus(logger)
+
+	var sender mail.Sender = mail.NewLogSender(logger)
+	if cfg.Mail.Host != "" {
+		sender = mail.NewSMTPSender(cfg.Mail.Host, cfg.Mail.Port, cfg.Mail.Username, cfg.Mail.Password, cfg.Mail.From)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	digestScheduler := digest.NewScheduler(digest.NewDigestService(digest.NewDigestRepository(db, logger), sender, logger), logger)
+	go digestScheduler.Run(ctx)
+
+	webhooks := webhook.NewWebhookService(webhook.NewWebhookRepository(db, logger), cfg.Webhook, logger)
+	go webhooks.Run(ctx)
+
+	go func() {
+		if err := wsHub.Run(ctx); err != nil {
+			logger.Fatalf("Error occurred while listening to the hub backend: %s", err.Error())
+		}
+	}()
 
 	go func() {
-		if err := server.Run(cfg.Server, buildHandler(db, valid, logger, hub)); err != nil {
+		if err := server.Run(cfg.Server, buildHandler(db, valid, logger, wsHub, bus, sender, webhooks, cfg.Server, cfg.Websocket, keys, cfg.Auth)); err != nil {
 			logger.Fatalf("Error occurred while running http server: %s", err.Error())
 		}
 	}()
@@ -96,118 +139,155 @@ func main() {
 	<-quit
 
 	logger.Info("Raffinance Shutting Down")
+	cancel()
+	// the websockets and event streams are not ended by the shutdown of the server
+	wsHub.Close()
 
 	if err := server.Shutdown(context.Background()); err != nil {
 		logger.Fatalf("error occurred on server shutting down: %s", err.Error())
 	}
+
+	bus.Wait()
 }
 
 // TODO: How the dependencies injection can be done better?
 // TODO: Logger is not passed as ref
 // buildHandler sets up the HTTP routing and builds an HTTP handler.
-func buildHandler(db *gorm.DB, valid *validator.Validate, logger log.Logger, hub *hub.Hub) http.Handler {
+func buildHandler(
+	db *gorm.DB,
+	valid *validator.Validate,
+	logger log.Logger,
+	wsHub *hub.Hub,
+	bus *event.Bus,
+	sender mail.Sender,
+	webhooks webhook.Service,
+	serverCfg config.Server,
+	wsCfg config.Websocket,
+	keys *auth.Keys,
+	authCfg config.Auth,
+) http.Handler {
 	router := gin.New()
+
+	// the rate limits are by client IP, only the configured proxies may set it
+	if err := router.SetTrustedProxies(serverCfg.TrustedProxies); err != nil {
+		logger.Fatalf("failed to set the trusted proxies: %s", err.Error())
+	}
+
 	router.Use(accesslog.Handler(logger), errorutil.Handler(logger), cors.Handler())
 
-	authRg := router.Group("/auth")
-	apiRg := router.Group("/api", auth.HandleUserIdentity(logger))
+	// auth service is used by the middleware which checks the sessions as well
+	authService := auth.NewAuthService(auth.NewAuthRepository(db, logger), keys, authCfg, sender, logger)
+	identity := auth.HandleUserIdentity(authService, logger)
 
-	apiRg.GET("/websocket", func(c *gin.Context) {
-		handleWebSocketConnection(c, hub)
-	})
+	authRg := router.Group("/auth")
+	wellKnownRg := router.Group("/.well-known")
+	// the routes of each domain require its scope from a personal access token
+	apiRg := router.Group("/api", identity)
+	// the websocket authenticates with a ticket, browsers can't set headers on the upgrade
+	wsRg := router.Group("/api")
 
+	anomalyService := anomaly.NewAnomalyService(anomaly.NewAnomalyRepository(db, logger), logger, bus)
 	// transaction service is used in account as well
-	transactionService := transaction.NewTransactionService(transaction.NewTransactionRepository(db, logger), logger, hub)
+	transactionService := transaction.NewTransactionService(transaction.NewTransactionRepository(db, logger), logger, bus)
+	// investment service is used in account as well
+	investmentService := investment.NewInvestmentService(investment.NewInvestmentRepository(db, logger), transactionService, logger)
+
+	// the side effects of the domain events
+	hub.RegisterSubscribers(bus, wsHub)
+	anomaly.RegisterSubscribers(bus, anomalyService)
+	webhook.RegisterSubscribers(bus, webhooks)
+
+	hub.RegisterHandlers(
+		wsRg,
+		apiRg.Group("", auth.RequireSession()),
+		hub.NewTicketService(hub.NewTicketRepository(db, logger), logger),
+		wsHub,
+		identity,
+		wsCfg.AllowedOrigins,
+		logger,
+	)
 
 	auth.RegisterHandlers(
 		authRg,
 		apiRg,
-		auth.NewAuthService(auth.NewAuthRepository(db, logger), logger),
+		wellKnownRg,
+		authService,
+		authCfg.OIDCClientURL,
 		valid,
 		logger,
 	)
 
 	contact.RegisterHandlers(
-		apiRg,
+		apiRg.Group("", auth.RequireScope("contacts")),
 		contact.NewContactService(contact.NewContactRepository(db, logger), logger),
 		valid,
 		logger,
 	)
 
 	account.RegisterHandlers(
-		apiRg,
-		account.NewAccountService(transactionService, account.NewAccountRepository(db, logger), logger),
+		apiRg.Group("", auth.RequireScope("accounts")),
+		account.NewAccountService(transactionService, investmentService, account.NewAccountRepository(db, logger), bus, logger),
+		valid,
+		logger,
+	)
+
+	investment.RegisterHandlers(
+		apiRg.Group("", auth.RequireScope("investments")),
+		investmentService,
 		valid,
 		logger,
 	)
 
 	transaction.RegisterHandlers(
-		apiRg,
+		apiRg.Group("", auth.RequireScope("transactions")),
 		transactionService,
 		valid,
 		logger,
 	)
 
+	anomaly.RegisterHandlers(
+		apiRg.Group("", auth.RequireScope("anomalies")),
+		anomalyService,
+		valid,
+		logger,
+	)
+
 	category.RegisterHandlers(
-		apiRg,
-		category.NewCategoryService(category.NewCategoryRepository(db, logger), logger),
+		apiRg.Group("", auth.RequireScope("categories")),
+		category.NewCategoryService(category.NewCategoryRepository(db, logger), logger, bus),
 		valid,
 		logger,
 	)
 
 	tag.RegisterHandlers(
-		apiRg,
+		apiRg.Group("", auth.RequireScope("tags")),
 		tag.NewTagService(tag.NewTagRepository(db, logger), logger),
 		valid,
 		logger,
 	)
 
-	analytics.RegisterHandlers(
-		apiRg,
-		analytics.NewAnalyticsService(analytics.NewAnalyticsRepository(db, logger), logger),
+	digest.RegisterHandlers(
+		apiRg.Group("", auth.RequireScope("digests")),
+		digest.NewDigestService(digest.NewDigestRepository(db, logger), sender, logger),
 		valid,
 		logger,
 	)
 
-	return router
-}
-
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		// Allow requests from a specific origin
-		fmt.Println(r.Header.Get("Origin"))
-		return r.Header.Get("Origin") == "http://localhost:3000"
-	},
-}
-
-func handleWebSocketConnection(c *gin.Context, huub *hub.Hub) {
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	defer conn.Close()
-
-	// Get the user ID from the URL params
-	userID, err := auth.GetUserId(c)
-	if err != nil || userID == nil {
-		fmt.Println("ERRORR", err.Error())
-		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
-		return
-	}
-
-	fmt.Println("USER ID", userID)
-
-	// Create a new client for this connection
-	client := hub.NewClient(*userID, conn)
+	webhook.RegisterHandlers(
+		apiRg.Group("", auth.RequireScope("webhooks")),
+		webhooks,
+		valid,
+		logger,
+	)
 
-	// Add the client to the repository
-	huub.AddClient(client)
+	analytics.RegisterHandlers(
+		apiRg.Group("", auth.RequireScope("analytics")),
+		analytics.NewAnalyticsService(analytics.NewAnalyticsRepository(db, logger), investmentService, logger),
+		valid,
+		logger,
+	)
 
-	// Start listening for incoming messages
-	go client.Listen(huub)
+	return router
 }
 
 // func (r *Hub) SendToClient(id string, messageType int, p []byte) error {
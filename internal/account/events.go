@@ -0,0 +1,26 @@
+package account
+
+// AccountCreated is published after the initial balance is booked, the account carries it
+type AccountCreated struct {
+	UserID  uint            `json:"-"`
+	Account accountResponse `json:"account"`
+}
+
+func (e AccountCreated) EventName() string { return "account.created" }
+func (e AccountCreated) EventUserID() uint { return e.UserID }
+
+type AccountUpdated struct {
+	UserID  uint            `json:"-"`
+	Account accountResponse `json:"account"`
+}
+
+func (e AccountUpdated) EventName() string { return "account.updated" }
+func (e AccountUpdated) EventUserID() uint { return e.UserID }
+
+type AccountDeleted struct {
+	UserID    uint `json:"-"`
+	AccountID uint `json:"accountId"`
+}
+
+func (e AccountDeleted) EventName() string { return "account.deleted" }
+func (e AccountDeleted) EventUserID() uint { return e.UserID }
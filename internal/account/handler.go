@@ -1,6 +1,8 @@
 package account
 
 import (
+	"bytes"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
@@ -25,6 +27,7 @@ func RegisterHandlers(apiRg *gin.RouterGroup, service Service, validate *validat
 		api.GET("", h.getAccounts)
 		api.GET("/:id", h.getAccount)
 		// api.GET("/:id/transactions", h.getAccountTransactionsByMonth)
+		api.GET("/:id/statements/:year/:month", h.getStatement)
 	}
 }
 
@@ -53,6 +56,11 @@ func (h *handler) createAccount(c *gin.Context) {
 		return
 	}
 
+	if err := checkBalance(input.Balance, input.Liability); err != nil {
+		errorutil.BadRequest(c, "your request did not pass validation", err.Error())
+		return
+	}
+
 	createdAccount, err := h.service.createAccount(*userId, input)
 	if err != nil {
 		errorutil.InternalServer(c, "It looks like name is already used", err.Error())
@@ -87,6 +95,11 @@ func (h *handler) updateAccount(c *gin.Context) {
 		return
 	}
 
+	if err := checkBalance(input.Balance, input.Liability); err != nil {
+		errorutil.BadRequest(c, "your request did not pass validation", err.Error())
+		return
+	}
+
 	updatedAccount, err := h.service.updateAccount(*userId, uint(accountId), input)
 	if err != nil {
 		errorutil.BadRequest(c, "error", err.Error())
@@ -240,3 +253,79 @@ func (h *handler) balance(c *gin.Context) {
 		"userBal":    userBal,
 	})
 }
+
+// getStatement renders the statement as html by default, as pdf with ?format=pdf or as json with ?format=json
+func (h *handler) getStatement(c *gin.Context) {
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	accountId, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		errorutil.BadRequest(c, err.Error(), "the id must be an integer")
+		return
+	}
+
+	year, err := strconv.Atoi(c.Param("year"))
+	if err != nil {
+		errorutil.BadRequest(c, err.Error(), "the year must be an integer")
+		return
+	}
+
+	// time.Date would normalize them, month 13 is january of the next year
+	if year < 1900 || year > time.Now().Year()+10 {
+		errorutil.BadRequest(c, fmt.Sprintf("invalid year provided: %d", year), "the year is out of range")
+		return
+	}
+
+	month, err := strconv.Atoi(c.Param("month"))
+	if err != nil {
+		errorutil.BadRequest(c, err.Error(), "the month must be an integer")
+		return
+	}
+
+	if month < 1 || month > 12 {
+		errorutil.BadRequest(c, fmt.Sprintf("invalid month provided: %d", month), "the month must be between 1 and 12")
+		return
+	}
+
+	format := c.DefaultQuery("format", "html")
+	if format != "html" && format != "pdf" && format != "json" {
+		errorutil.BadRequest(c, "format must be html, pdf or json", "")
+		return
+	}
+
+	statement, err := h.service.getStatement(*userId, uint(accountId), year, time.Month(month))
+	if err != nil {
+		errorutil.NotFound(c, err.Error(), "Not found")
+		return
+	}
+
+	if format == "json" {
+		c.JSON(http.StatusOK, statement)
+		return
+	}
+
+	var buf bytes.Buffer
+	filename := fmt.Sprintf("statement-%d-%d-%02d", statement.Account.ID, statement.Year, statement.Month)
+
+	if format == "pdf" {
+		if err := renderStatementPDF(&buf, statement); err != nil {
+			errorutil.InternalServer(c, "the statement could not be rendered", err.Error())
+			return
+		}
+
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, filename))
+		c.Data(http.StatusOK, "application/pdf", buf.Bytes())
+		return
+	}
+
+	if err := renderStatementHTML(&buf, statement); err != nil {
+		errorutil.InternalServer(c, "the statement could not be rendered", err.Error())
+		return
+	}
+
+	c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
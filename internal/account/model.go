@@ -1,6 +1,7 @@
 package account
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/emPeeGee/raffinance/internal/transaction"
@@ -13,10 +14,14 @@ type accountResponse struct {
 	Balance           float64   `json:"balance" gorm:"-"`
 	Color             string    `json:"color"`
 	Icon              string    `json:"icon"`
+	Investment        bool      `json:"investment"`
+	Liability         bool      `json:"liability"`
 	TransactionCount  *int64    `json:"transactionCount" gorm:"transaction_count"`
 	CreatedAt         time.Time `json:"createdAt"`
 	UpdatedAt         time.Time `json:"updatedAt"`
 	RateWithPrevMonth *float64  `json:"rateWithPrevMonth"`
+	// MarketValue is the value of the securities held, only for investment accounts
+	MarketValue *float64 `json:"marketValue,omitempty" gorm:"-"`
 }
 
 type accountDetailsResponse struct {
@@ -27,6 +32,8 @@ type accountDetailsResponse struct {
 	TransactionCount *int64    `json:"transactionCount" gorm:"transaction_count"`
 	Color            string    `json:"color"`
 	Icon             string    `json:"icon"`
+	Investment       bool      `json:"investment"`
+	Liability        bool      `json:"liability"`
 	CreatedAt        time.Time `json:"createdAt"`
 	UpdatedAt        time.Time `json:"updatedAt"`
 	// Transactions     []transaction.TransactionResponse `json:"transactions" gorm:"foreignkey:to_account_id"`
@@ -34,17 +41,86 @@ type accountDetailsResponse struct {
 }
 
 type createAccountDTO struct {
-	Name     string  `json:"name" validate:"required,min=2,max=256"`
-	Balance  float64 `json:"balance" validate:"numeric,gte=0"`
-	Currency string  `json:"currency" validate:"required,currency,min=2,max=10"`
-	Icon     string  `json:"icon" validate:"required,max=128"`
-	Color    string  `json:"color" validate:"required,hexcolor,min=7,max=7"`
+	Name       string  `json:"name" validate:"required,min=2,max=256"`
+	Balance    float64 `json:"balance" validate:"numeric"`
+	Currency   string  `json:"currency" validate:"required,currency,min=2,max=10"`
+	Icon       string  `json:"icon" validate:"required,max=128"`
+	Color      string  `json:"color" validate:"required,hexcolor,min=7,max=7"`
+	Investment bool    `json:"investment"`
+	Liability  bool    `json:"liability"`
 }
 
 type updateAccountDTO struct {
-	Name     string  `json:"name" validate:"required,min=2,max=256"`
-	Balance  float64 `json:"balance" validate:"numeric,gte=0"`
-	Currency string  `json:"currency" validate:"required,currency,min=2,max=10"`
-	Icon     string  `json:"icon" validate:"required,max=128"`
-	Color    string  `json:"color" validate:"required,hexcolor,min=7,max=7"`
+	Name      string  `json:"name" validate:"required,min=2,max=256"`
+	Balance   float64 `json:"balance" validate:"numeric"`
+	Currency  string  `json:"currency" validate:"required,currency,min=2,max=10"`
+	Icon      string  `json:"icon" validate:"required,max=128"`
+	Color     string  `json:"color" validate:"required,hexcolor,min=7,max=7"`
+	Liability bool    `json:"liability"`
+}
+
+// checkBalance enforces the sign of balances: they are signed, a debt is a negative balance and only
+// a liability account can owe
+func checkBalance(balance float64, liability bool) error {
+	if balance < 0 && !liability {
+		return fmt.Errorf("balance %.2f is negative, only a liability account can owe", balance)
+	}
+
+	return nil
+}
+
+// accountBalanceRow is an account with its balances as computed by the database
+type accountBalanceRow struct {
+	ID               uint
+	Name             string
+	Currency         string
+	Color            string
+	Icon             string
+	Investment       bool
+	Liability        bool
+	TransactionCount int64
+	Balance          float64
+	ThisMonth        float64
+	LastMonth        float64
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+type statementAccount struct {
+	ID        uint   `json:"id"`
+	Name      string `json:"name"`
+	Currency  string `json:"currency"`
+	Liability bool   `json:"liability"`
+}
+
+type statementLine struct {
+	TransactionID uint      `json:"transactionId"`
+	Date          time.Time `json:"date"`
+	Description   string    `json:"description"`
+	Category      string    `json:"category"`
+	Type          string    `json:"type"`
+	// Amount is signed from the account point of view
+	Amount  float64 `json:"amount"`
+	Balance float64 `json:"balance"`
+}
+
+type statementTotals struct {
+	Income      float64 `json:"income"`
+	Expense     float64 `json:"expense"`
+	TransferIn  float64 `json:"transferIn"`
+	TransferOut float64 `json:"transferOut"`
+	Net         float64 `json:"net"`
+}
+
+type statementResponse struct {
+	Account        statementAccount `json:"account"`
+	Year           int              `json:"year"`
+	Month          time.Month       `json:"month"`
+	PeriodStart    time.Time        `json:"periodStart"`
+	PeriodEnd      time.Time        `json:"periodEnd"`
+	OpeningBalance float64          `json:"openingBalance"`
+	Lines          []statementLine  `json:"lines"`
+	Totals         statementTotals  `json:"totals"`
+	ClosingBalance float64          `json:"closingBalance"`
+	GeneratedAt    time.Time        `json:"generatedAt"`
 }
@@ -24,6 +24,7 @@ type Repository interface {
 	accountExistsAndBelongsToUser(userID, id uint, name string) (bool, error)
 	accountIsUsed(accountId uint) error
 	getAccountBalance(id uint, month *time.Time) (float64, error)
+	getAccountBalanceBefore(id uint, before time.Time) (float64, error)
 	getUserBalance(userID uint) (float64, error)
 }
 
@@ -38,11 +39,13 @@ func NewAccountRepository(db *gorm.DB, logger log.Logger) *repository {
 
 func (r *repository) createAccount(userId uint, account createAccountDTO) (*accountResponse, error) {
 	newAccount := entity.Account{
-		Name:     account.Name,
-		Currency: account.Currency,
-		Color:    account.Color,
-		Icon:     account.Icon,
-		UserID:   &userId,
+		Name:       account.Name,
+		Currency:   account.Currency,
+		Color:      account.Color,
+		Icon:       account.Icon,
+		UserID:     &userId,
+		Investment: account.Investment,
+		Liability:  account.Liability,
 	}
 
 	if err := r.db.Create(&newAccount).Error; err != nil {
@@ -51,14 +54,16 @@ func (r *repository) createAccount(userId uint, account createAccountDTO) (*acco
 
 	r.logger.Info("new account, ", util.StringifyAny(newAccount))
 	createdAccount := &accountResponse{
-		ID:        newAccount.ID,
-		Name:      newAccount.Name,
-		Currency:  newAccount.Currency,
-		Color:     newAccount.Color,
-		Icon:      newAccount.Icon,
-		Balance:   account.Balance,
-		CreatedAt: newAccount.CreatedAt,
-		UpdatedAt: newAccount.UpdatedAt,
+		ID:         newAccount.ID,
+		Name:       newAccount.Name,
+		Currency:   newAccount.Currency,
+		Color:      newAccount.Color,
+		Icon:       newAccount.Icon,
+		Investment: newAccount.Investment,
+		Liability:  newAccount.Liability,
+		Balance:    account.Balance,
+		CreatedAt:  newAccount.CreatedAt,
+		UpdatedAt:  newAccount.UpdatedAt,
 	}
 
 	return createdAccount, nil
@@ -68,10 +73,11 @@ func (r *repository) updateAccount(userId, accountId uint, account updateAccount
 	// NOTE: When update with struct, GORM will only update non-zero fields, you might want to use
 	// map to update attributes or use Select to specify fields to update
 	if err := r.db.Model(&entity.Account{}).Where("id = ?", accountId).Updates(map[string]interface{}{
-		"name":     account.Name,
-		"currency": account.Currency,
-		"icon":     account.Icon,
-		"color":    account.Color,
+		"name":      account.Name,
+		"currency":  account.Currency,
+		"icon":      account.Icon,
+		"color":     account.Color,
+		"liability": account.Liability,
 	}).Error; err != nil {
 		return nil, err
 	}
@@ -96,69 +102,74 @@ func (r *repository) deleteAccount(userId, id uint) error {
 	return r.db.Delete(&entity.Account{}, id).Error
 }
 
+// getAccounts computes the balances of all the accounts of the user, together with their
+// current and previous month totals, with a single grouped query over the account legs
 func (r *repository) getAccounts(userId uint) ([]accountResponse, error) {
-	var accounts []accountResponse
-	var accountsR []accountResponse
+	var rows []accountBalanceRow
+
+	now := time.Now()
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	lastMonth := thisMonth.AddDate(0, -1, 0)
+	nextMonth := thisMonth.AddDate(0, 1, 0)
 
 	query := `
-		SELECT ac.id, ac.created_at, ac.updated_at, ac.name, ac.color, ac.currency, ac.icon,
-      (SELECT COUNT(DISTINCT id)
-				FROM transactions AS t
-				WHERE t.deleted_at IS NULL AND (t.from_account_id = ac.id OR t.to_account_id = ac.id)) AS transaction_count
-    FROM accounts as ac
-    WHERE ac.user_id = ? AND ac.deleted_at IS NULL;
+		SELECT ac.id, ac.created_at, ac.updated_at, ac.name, ac.color, ac.currency, ac.icon, ac.investment, ac.liability,
+			COALESCE(b.transaction_count, 0) AS transaction_count,
+			COALESCE(b.balance, 0) AS balance,
+			COALESCE(b.this_month, 0) AS this_month,
+			COALESCE(b.last_month, 0) AS last_month
+		FROM accounts AS ac
+		LEFT JOIN (
+			SELECT legs.account_id,
+				COUNT(DISTINCT legs.id) AS transaction_count,
+				SUM(legs.amount) AS balance,
+				SUM(legs.amount) FILTER (WHERE legs.date >= @thisMonth AND legs.date < @nextMonth) AS this_month,
+				SUM(legs.amount) FILTER (WHERE legs.date >= @lastMonth AND legs.date < @thisMonth) AS last_month
+			FROM (` + transaction.AccountLegsQuery + `) AS legs
+			-- the filter is repeated here, the grouped subquery would aggregate the legs of every user
+			WHERE legs.account_id IN (SELECT id FROM accounts WHERE user_id = @userId AND deleted_at IS NULL)
+			GROUP BY legs.account_id
+		) AS b ON b.account_id = ac.id
+		WHERE ac.user_id = @userId AND ac.deleted_at IS NULL
+		ORDER BY ac.id ASC;
 	`
 
-	if err := r.db.Raw(query, userId).Scan(&accounts).Error; err != nil {
+	if err := r.db.Raw(query, map[string]interface{}{
+		"userId":    userId,
+		"thisMonth": thisMonth,
+		"lastMonth": lastMonth,
+		"nextMonth": nextMonth,
+	}).Scan(&rows).Error; err != nil {
 		return nil, err
 	}
 
-	for _, account := range accounts {
-		// TODO: get the account balance dynamically. Is there a better way for it?
-		accountBalance, err := r.getAccountBalance(account.ID, nil)
-		if err != nil {
-			return nil, err
-		}
-
-		now := new(time.Time)
-		*now = time.Now()
-		lastMonth := now.AddDate(0, -1, 0)
-
-		accountBalanceThisMonth, err := r.getAccountBalance(account.ID, now)
-		if err != nil {
-			return nil, err
-		}
-
-		accountBalanceLastMonth, err := r.getAccountBalance(account.ID, &lastMonth)
-		if err != nil {
-			return nil, err
-		}
-
+	accounts := make([]accountResponse, 0, len(rows))
+	for _, row := range rows {
 		var rate float64
-		if accountBalanceLastMonth == 0 {
+		if row.LastMonth == 0 {
 			rate = 0 // avoid division by zero
 		} else {
-			rate = ((accountBalanceThisMonth - accountBalanceLastMonth) / accountBalanceLastMonth) * 100
+			rate = ((row.ThisMonth - row.LastMonth) / row.LastMonth) * 100
 		}
 
-		diff := accountBalanceLastMonth - accountBalanceThisMonth
-		r.logger.Debugf("%f %f and DIFF %f", accountBalanceThisMonth, accountBalanceLastMonth, diff)
-
-		accountsR = append(accountsR, accountResponse{
-			ID:                account.ID,
-			Name:              account.Name,
-			Currency:          account.Currency,
-			Balance:           accountBalance,
-			Color:             account.Color,
-			Icon:              account.Icon,
-			CreatedAt:         account.CreatedAt,
-			UpdatedAt:         account.UpdatedAt,
-			TransactionCount:  account.TransactionCount,
+		transactionCount := row.TransactionCount
+		accounts = append(accounts, accountResponse{
+			ID:                row.ID,
+			Name:              row.Name,
+			Currency:          row.Currency,
+			Balance:           row.Balance,
+			Color:             row.Color,
+			Icon:              row.Icon,
+			Investment:        row.Investment,
+			Liability:         row.Liability,
+			CreatedAt:         row.CreatedAt,
+			UpdatedAt:         row.UpdatedAt,
+			TransactionCount:  &transactionCount,
 			RateWithPrevMonth: &rate,
 		})
 	}
 
-	return accountsR, nil
+	return accounts, nil
 }
 
 func (r *repository) accountExistsAndBelongsToUser(userID, id uint, name string) (bool, error) {
@@ -204,41 +215,16 @@ func (r *repository) accountIsUsed(accountId uint) error {
 }
 
 func (r *repository) getAccountBalance(id uint, month *time.Time) (float64, error) {
-	// Calculate the total balance of this account for the given month
-	var nonTransferBalance, transferBalance float64
-
-	err := r.db.Transaction(func(tx *gorm.DB) error {
-		// Calculate the non-transfer total balance
-		txQuery := tx.Table("transactions").
-			Where("deleted_at is null and to_account_id = ? and transaction_type_id <> ?", id, transaction.TRANSFER).
-			Select("COALESCE(SUM(CASE WHEN transaction_type_id = ? THEN amount ELSE -amount END), 0)", transaction.INCOME)
-
-		if month != nil {
-			txQuery = txQuery.Where("to_char(date, 'YYYY-MM') = ?", month.Format("2006-01"))
-		}
-
-		err := txQuery.Row().Scan(&nonTransferBalance)
-		if err != nil {
-			return err
-		}
-
-		// Calculate the transfer total balance
-		txQuery = tx.Table("transactions").
-			Where("deleted_at is null and (to_account_id = ? or from_account_id = ?) and transaction_type_id = ?", id, id, transaction.TRANSFER).
-			Select("COALESCE(SUM(CASE WHEN to_account_id = ? THEN amount ELSE -amount END), 0)", id)
-
-		if month != nil {
-			txQuery = txQuery.Where("to_char(date, 'YYYY-MM') = ?", month.Format("2006-01"))
-		}
+	// Calculate the total balance of this account, for the given month if any
+	filter := transaction.BalanceFilter{AccountIDs: []uint{id}}
 
-		err = txQuery.Row().Scan(&transferBalance)
-		if err != nil {
-			return err
-		}
-
-		return nil
-	})
+	if month != nil {
+		start := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+		end := start.AddDate(0, 1, 0)
+		filter.From, filter.Before = &start, &end
+	}
 
+	balance, err := transaction.Balance(r.db, filter)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return 0, ErrAccountBalanceNotFound
@@ -247,30 +233,24 @@ func (r *repository) getAccountBalance(id uint, month *time.Time) (float64, erro
 		return 0, err
 	}
 
-	r.logger.Infof("Account %d balance: balance excluding transfers %f, transfer total %f", id, nonTransferBalance, transferBalance)
+	r.logger.Infof("Account %d balance: %f", id, balance)
 
-	return nonTransferBalance + transferBalance, nil
+	return balance, nil
 }
 
-func (r *repository) getUserBalance(userID uint) (float64, error) {
-	var totalBalance float64
+// getAccountBalanceBefore is the balance of the account right before the date, like an opening balance
+func (r *repository) getAccountBalanceBefore(id uint, before time.Time) (float64, error) {
+	return transaction.Balance(r.db, transaction.BalanceFilter{AccountIDs: []uint{id}, Before: &before})
+}
 
-	// Retrieve all accounts associated with the user
-	var accounts []entity.Account
-	if err := r.db.Where("user_id = ?", userID).Find(&accounts).Error; err != nil {
+// getUserBalance sums the balances of all the accounts of the user in a single query. The balances
+// are signed, the debt of a liability is negative so it is subtracted
+func (r *repository) getUserBalance(userID uint) (float64, error) {
+	totalBalance, err := transaction.Balance(r.db, transaction.BalanceFilter{UserID: &userID})
+	if err != nil {
 		return 0, err
 	}
 
-	// Iterate over each account to calculate the total balance
-	for _, account := range accounts {
-		accountBalance, err := r.getAccountBalance(account.ID, nil)
-		if err != nil {
-			return 0, err
-		}
-
-		totalBalance += accountBalance
-	}
-
 	r.logger.Infof("User %d balance: %f", userID, totalBalance)
 
 	return totalBalance, nil
@@ -280,7 +260,7 @@ func (r *repository) getAccount(accountId uint) (*accountDetailsResponse, error)
 	var account *accountDetailsResponse
 
 	query := `
-		SELECT ac.id, ac.created_at, ac.updated_at, ac.name, ac.color, ac.currency, ac.icon,
+		SELECT ac.id, ac.created_at, ac.updated_at, ac.name, ac.color, ac.currency, ac.icon, ac.investment, ac.liability,
       (SELECT COUNT(DISTINCT t.id)
 				FROM transactions AS t
 				WHERE t.deleted_at IS NULL AND (t.from_account_id = ac.id OR t.to_account_id = ac.id)) AS transaction_count
@@ -0,0 +1,156 @@
+package account
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emPeeGee/raffinance/pkg/log"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// fakeConnector answers every query without a database: the accounts query with n accounts,
+// anything else with a single zero, which is what the balance lookups read
+type fakeConnector struct {
+	accounts int
+}
+
+func (f *fakeConnector) Connect(context.Context) (driver.Conn, error) { return &fakeConn{f}, nil }
+func (f *fakeConnector) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct {
+	connector *fakeConnector
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) { return &fakeStmt{c, query}, nil }
+func (c *fakeConn) Close() error                              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+func (c *fakeConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	if !strings.Contains(strings.ToLower(query), "from accounts as ac") {
+		return &fakeRows{columns: []string{"value"}, values: [][]driver.Value{{float64(0)}}}, nil
+	}
+
+	rows := &fakeRows{columns: []string{
+		"id", "created_at", "updated_at", "name", "color", "currency", "icon", "investment", "liability",
+		"transaction_count", "balance", "this_month", "last_month",
+	}}
+
+	now := time.Now()
+	for i := 1; i <= c.connector.accounts; i++ {
+		rows.values = append(rows.values, []driver.Value{
+			int64(i), now, now, fmt.Sprintf("Account %d", i), "#000000", "EUR", "wallet", false, false,
+			int64(3), float64(100), float64(10), float64(5),
+		})
+	}
+
+	return rows, nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec([]driver.Value) (driver.Result, error) { return driver.ResultNoRows, nil }
+
+func (s *fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, nil)
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// newCountingRepository returns a repository over n fake accounts and the number of queries it ran
+func newCountingRepository(t testing.TB, accounts int) (*repository, *int64) {
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(&fakeConnector{accounts})}), &gorm.Config{
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var queries int64
+	count := func(*gorm.DB) { atomic.AddInt64(&queries, 1) }
+
+	if err := db.Callback().Query().After("gorm:query").Register("test:count_query", count); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Callback().Row().After("gorm:row").Register("test:count_row", count); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Callback().Raw().After("gorm:raw").Register("test:count_raw", count); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewAccountRepository(db, log.New()), &queries
+}
+
+func TestGetAccountsRunsOneQuery(t *testing.T) {
+	for _, accounts := range []int{0, 1, 15, 100} {
+		repo, queries := newCountingRepository(t, accounts)
+
+		result, err := repo.getAccounts(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result) != accounts {
+			t.Fatalf("got %d accounts, expected %d", len(result), accounts)
+		}
+
+		if *queries != 1 {
+			t.Errorf("%d accounts ran %d queries, expected 1", accounts, *queries)
+		}
+	}
+}
+
+func BenchmarkGetAccounts(b *testing.B) {
+	for _, accounts := range []int{1, 15, 100} {
+		b.Run(fmt.Sprintf("accounts=%d", accounts), func(b *testing.B) {
+			repo, queries := newCountingRepository(b, accounts)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := repo.getAccounts(1); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.ReportMetric(float64(*queries)/float64(b.N), "queries/op")
+		})
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"math"
 	"time"
 
+	"github.com/emPeeGee/raffinance/internal/event"
+	"github.com/emPeeGee/raffinance/internal/investment"
 	"github.com/emPeeGee/raffinance/internal/transaction"
 	"github.com/emPeeGee/raffinance/pkg/log"
 )
@@ -20,19 +22,31 @@ type Service interface {
 	getAccountTransactionsByMonth(accountId uint, year int, month time.Month) ([]transaction.TransactionResponse, error)
 	getAccountBalance(userId, id uint) (float64, error)
 	getUserBalance(userId uint) (float64, error)
+	getStatement(userId, accountId uint, year int, month time.Month) (*statementResponse, error)
 }
 
 type service struct {
 	repo Repository
 	// NOTE: I need this service to make transaction on account
 	transactionService transaction.Service
-	logger             log.Logger
+	// NOTE: securities held on investment accounts count towards the balance, their cash is already in it
+	investmentService investment.Service
+	events            event.Publisher
+	logger            log.Logger
 }
 
-func NewAccountService(transactionService transaction.Service, repo Repository, logger log.Logger) *service {
+func NewAccountService(
+	transactionService transaction.Service,
+	investmentService investment.Service,
+	repo Repository,
+	events event.Publisher,
+	logger log.Logger,
+) *service {
 	return &service{
 		transactionService: transactionService,
+		investmentService:  investmentService,
 		repo:               repo,
+		events:             events,
 		logger:             logger,
 	}
 }
@@ -48,18 +62,22 @@ func (s *service) createAccount(userId uint, account createAccountDTO) (*account
 		return nil, fmt.Errorf("account with name %s exists", account.Name)
 	}
 
-	// First, create account and then if needed the first transaction
 	createdAccount, err := s.repo.createAccount(userId, account)
 	if err != nil {
 		return nil, err
 	}
 
-	// In case the account is created with some default balance, create a transaction
-	if account.Balance > 0 {
-		_, err := s.transactionService.CreateInitialTransaction(userId, createdAccount.ID, account.Balance)
-		if err != nil {
+	// The account is published once its initial balance exists
+	if account.Balance != 0 {
+		if _, err := s.transactionService.CreateInitialTransaction(userId, createdAccount.ID, account.Balance); err != nil {
 			return nil, fmt.Errorf("could not create an initial balance of %f for account %d", account.Balance, createdAccount.ID)
 		}
+
+		createdAccount.Balance = account.Balance
+	}
+
+	if err := s.events.Publish(AccountCreated{UserID: userId, Account: *createdAccount}); err != nil {
+		return nil, err
 	}
 
 	return createdAccount, nil
@@ -80,11 +98,31 @@ func (s *service) deleteAccount(userId, id uint) error {
 		return err
 	}
 
-	return s.repo.deleteAccount(userId, id)
+	if err := s.repo.deleteAccount(userId, id); err != nil {
+		return err
+	}
+
+	return s.events.Publish(AccountDeleted{UserID: userId, AccountID: id})
 }
 
 func (s *service) getAccounts(userId uint) ([]accountResponse, error) {
-	return s.repo.getAccounts(userId)
+	accounts, err := s.repo.getAccounts(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	marketValues, err := s.investmentService.GetMarketValues(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range accounts {
+		if value, ok := marketValues[accounts[i].ID]; ok {
+			accounts[i].MarketValue = &value
+		}
+	}
+
+	return accounts, nil
 }
 
 func (s *service) updateAccount(userId, accountId uint, account updateAccountDTO) (*accountResponse, error) {
@@ -113,7 +151,7 @@ func (s *service) updateAccount(userId, accountId uint, account updateAccountDTO
 		return nil, err
 	}
 
-	// calculate the difference
+	// calculate the difference, the balances are signed, a liability that owes has a negative one
 	// current 100, modified 200 => 100 - 200 = -100. If adjustment is negative. It means we should make an income with adjusted amount to adjust balance
 	// current 200, modified 100 => 200 - 100 = 100. If adjustment is positive. It means we should make an expense with adjusted amount to adjust balance
 	adjustedAmount := currentAccountBalance - account.Balance
@@ -130,7 +168,16 @@ func (s *service) updateAccount(userId, accountId uint, account updateAccountDTO
 		}
 	}
 
-	return s.repo.updateAccount(userId, accountId, account)
+	updatedAccount, err := s.repo.updateAccount(userId, accountId, account)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.events.Publish(AccountUpdated{UserID: userId, Account: *updatedAccount}); err != nil {
+		return nil, err
+	}
+
+	return updatedAccount, nil
 }
 
 func (s *service) getAccount(userId, id uint) (*accountDetailsResponse, error) {
@@ -204,5 +251,68 @@ func (s *service) getAccountBalance(userId, id uint) (float64, error) {
 
 // TODO: to be moved in user
 func (s *service) getUserBalance(userId uint) (float64, error) {
-	return s.repo.getUserBalance(userId)
+	balance, err := s.repo.getUserBalance(userId)
+	if err != nil {
+		return 0, err
+	}
+
+	marketValues, err := s.investmentService.GetMarketValues(userId)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, value := range marketValues {
+		balance += value
+	}
+
+	return balance, nil
+}
+
+func (s *service) getStatement(userId, accountId uint, year int, month time.Month) (*statementResponse, error) {
+	ok, err := s.repo.accountExistsAndBelongsToUser(userId, accountId, "")
+	if err != nil {
+		return nil, fmt.Errorf("error checking account ownership: %v", err)
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("account with ID %d does not exist or belong to user with ID %d", accountId, userId)
+	}
+
+	account, err := s.repo.getAccount(accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	transactions, err := s.transactionService.GetAccountTransactionsByMonth(accountId, year, month)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	opening, err := s.repo.getAccountBalanceBefore(accountId, start)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := buildStatement(statementAccount{
+		ID:        account.ID,
+		Name:      account.Name,
+		Currency:  account.Currency,
+		Liability: account.Liability,
+	}, start, opening, transactions)
+
+	// The closing balance is the one of the account, the lines have to add up to it
+	change, err := s.repo.getAccountBalance(accountId, &start)
+	if err != nil {
+		return nil, err
+	}
+
+	if math.Abs(opening+change-statement.ClosingBalance) > 0.005 {
+		s.logger.Errorf("statement of account %d for %d-%02d does not add up: %f, expected %f",
+			accountId, year, month, statement.ClosingBalance, opening+change)
+	}
+
+	statement.ClosingBalance = opening + change
+
+	return &statement, nil
 }
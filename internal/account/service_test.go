@@ -0,0 +1,234 @@
+package account
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/emPeeGee/raffinance/internal/event/eventtest"
+	"github.com/emPeeGee/raffinance/internal/investment"
+	"github.com/emPeeGee/raffinance/internal/transaction"
+	"github.com/emPeeGee/raffinance/pkg/log"
+)
+
+// ledger holds the signed balance of every account, both fakes below book into it
+type ledger map[uint]float64
+
+// fakeRepository keeps the accounts of a single user in memory
+type fakeRepository struct {
+	accounts map[uint]accountResponse
+	ledger   ledger
+	nextID   uint
+}
+
+func newFakeRepository(l ledger) *fakeRepository {
+	return &fakeRepository{accounts: map[uint]accountResponse{}, ledger: l, nextID: 1}
+}
+
+func (r *fakeRepository) getAccounts(uint) ([]accountResponse, error) {
+	var accounts []accountResponse
+	for _, a := range r.accounts {
+		a.Balance = r.ledger[a.ID]
+		accounts = append(accounts, a)
+	}
+
+	return accounts, nil
+}
+
+func (r *fakeRepository) getAccount(id uint) (*accountDetailsResponse, error) {
+	a := r.accounts[id]
+	return &accountDetailsResponse{ID: a.ID, Name: a.Name, Balance: r.ledger[id], Liability: a.Liability}, nil
+}
+
+func (r *fakeRepository) createAccount(_ uint, account createAccountDTO) (*accountResponse, error) {
+	created := accountResponse{ID: r.nextID, Name: account.Name, Currency: account.Currency, Liability: account.Liability}
+	r.accounts[created.ID] = created
+	r.nextID++
+
+	return &created, nil
+}
+
+func (r *fakeRepository) updateAccount(_, id uint, account updateAccountDTO) (*accountResponse, error) {
+	updated := r.accounts[id]
+	updated.Name = account.Name
+	updated.Liability = account.Liability
+	updated.Balance = r.ledger[id]
+	r.accounts[id] = updated
+
+	return &updated, nil
+}
+
+func (r *fakeRepository) deleteAccount(_, id uint) error {
+	delete(r.accounts, id)
+	return nil
+}
+
+func (r *fakeRepository) accountExistsAndBelongsToUser(_, id uint, name string) (bool, error) {
+	if id > 0 {
+		_, ok := r.accounts[id]
+		return ok, nil
+	}
+
+	for _, a := range r.accounts {
+		if a.Name == name {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (r *fakeRepository) accountIsUsed(uint) error { return nil }
+
+func (r *fakeRepository) getAccountBalance(id uint, _ *time.Time) (float64, error) {
+	return r.ledger[id], nil
+}
+
+func (r *fakeRepository) getAccountBalanceBefore(uint, time.Time) (float64, error) { return 0, nil }
+
+func (r *fakeRepository) getUserBalance(uint) (float64, error) {
+	var balance float64
+	for _, amount := range r.ledger {
+		balance += amount
+	}
+
+	return balance, nil
+}
+
+// fakeTransactionService books the initial and adjustment transactions into the ledger
+type fakeTransactionService struct {
+	transaction.Service
+	ledger ledger
+}
+
+func (s *fakeTransactionService) CreateInitialTransaction(_, accountId uint, amount float64) (*transaction.TransactionResponse, error) {
+	s.ledger[accountId] += amount
+	return &transaction.TransactionResponse{}, nil
+}
+
+func (s *fakeTransactionService) CreateAdjustmentTransaction(
+	_, accountId uint,
+	amount float64,
+	trType transaction.TransactionType,
+) (*transaction.TransactionResponse, error) {
+	if trType == transaction.EXPENSE {
+		amount = -amount
+	}
+
+	s.ledger[accountId] += amount
+	return &transaction.TransactionResponse{}, nil
+}
+
+// fakeInvestmentService holds no securities
+type fakeInvestmentService struct {
+	investment.Service
+}
+
+func (fakeInvestmentService) GetMarketValues(uint) (map[uint]float64, error) {
+	return map[uint]float64{}, nil
+}
+
+func newLedgerService(t *testing.T) (*service, *eventtest.Recorder) {
+	t.Helper()
+
+	l := ledger{}
+	transactionService := &fakeTransactionService{ledger: l}
+
+	recorder := eventtest.NewRecorder()
+	return NewAccountService(transactionService, fakeInvestmentService{}, newFakeRepository(l), recorder, log.New()), recorder
+}
+
+func TestCreateLiabilityWithOpeningDebt(t *testing.T) {
+	s, _ := newLedgerService(t)
+
+	checking, err := s.createAccount(1, createAccountDTO{Name: "Checking", Balance: 2500, Currency: "EUR"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loan, err := s.createAccount(1, createAccountDTO{Name: "Loan", Balance: -10000, Currency: "EUR", Liability: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loan.Balance != -10000 {
+		t.Errorf("created loan has a balance of %.2f, expected -10000", loan.Balance)
+	}
+
+	if balance, _ := s.repo.getAccountBalance(loan.ID, nil); balance != -10000 {
+		t.Errorf("loan balance is %.2f, expected -10000", balance)
+	}
+
+	if balance, _ := s.repo.getAccountBalance(checking.ID, nil); balance != 2500 {
+		t.Errorf("checking balance is %.2f, expected 2500", balance)
+	}
+
+	// The debt is subtracted from the balance of the user
+	total, err := s.getUserBalance(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if total != -7500 {
+		t.Errorf("user balance is %.2f, expected -7500", total)
+	}
+}
+
+func TestUpdateLiabilityAdjustsTheDebt(t *testing.T) {
+	s, _ := newLedgerService(t)
+
+	loan, err := s.createAccount(1, createAccountDTO{Name: "Loan", Balance: -10000, Currency: "EUR", Liability: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, owed := range []float64{-9000, -12000, 0} {
+		name := fmt.Sprintf("Loan %d", i)
+		updated, err := s.updateAccount(1, loan.ID, updateAccountDTO{Name: name, Balance: owed, Liability: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if updated.Balance != owed {
+			t.Errorf("loan balance is %.2f, expected %.2f", updated.Balance, owed)
+		}
+	}
+}
+
+func TestAccountCreatedCarriesTheInitialBalance(t *testing.T) {
+	s, recorder := newLedgerService(t)
+
+	created, err := s.createAccount(1, createAccountDTO{Name: "Savings", Balance: 2500, Currency: "EUR"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The initial balance is booked before the account is published
+	eventtest.AssertPublished(t, recorder, "account.created")
+	published := eventtest.Find[AccountCreated](recorder)[0]
+	if published.Account.ID != created.ID || published.Account.Balance != 2500 {
+		t.Errorf("published account %d with a balance of %.2f, expected %d with 2500",
+			published.Account.ID, published.Account.Balance, created.ID)
+	}
+}
+
+func TestCheckBalance(t *testing.T) {
+	tests := []struct {
+		balance   float64
+		liability bool
+		valid     bool
+	}{
+		{balance: 100, valid: true},
+		{balance: 0, valid: true},
+		{balance: -100, valid: false},
+		{balance: -100, liability: true, valid: true},
+		{balance: 100, liability: true, valid: true},
+	}
+
+	for _, test := range tests {
+		err := checkBalance(test.balance, test.liability)
+		if (err == nil) != test.valid {
+			t.Errorf("checkBalance(%.2f, %t) = %v, expected valid %t", test.balance, test.liability, err, test.valid)
+		}
+	}
+}
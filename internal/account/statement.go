@@ -0,0 +1,187 @@
+package account
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/emPeeGee/raffinance/internal/transaction"
+	"github.com/emPeeGee/raffinance/pkg/pdf"
+)
+
+//go:embed templates/statement.html
+var templatesFS embed.FS
+
+var statementTemplate = template.Must(template.New("statement.html").Funcs(template.FuncMap{
+	"amount": formatAmount,
+	"date":   func(t time.Time) string { return t.Format("02 Jan 2006") },
+}).ParseFS(templatesFS, "templates/statement.html"))
+
+// buildStatement lists the transactions of the month oldest first, signed from the account point of view,
+// with the running balance from the opening balance
+func buildStatement(account statementAccount, start time.Time, opening float64, transactions []transaction.TransactionResponse) statementResponse {
+	sorted := append([]transaction.TransactionResponse(nil), transactions...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Date.Equal(sorted[j].Date) {
+			return sorted[i].ID < sorted[j].ID
+		}
+
+		return sorted[i].Date.Before(sorted[j].Date)
+	})
+
+	statement := statementResponse{
+		Account:        account,
+		Year:           start.Year(),
+		Month:          start.Month(),
+		PeriodStart:    start,
+		PeriodEnd:      start.AddDate(0, 1, -1),
+		OpeningBalance: opening,
+		Lines:          make([]statementLine, 0, len(sorted)),
+		GeneratedAt:    time.Now().UTC(),
+	}
+
+	balance := opening
+	for _, t := range sorted {
+		line := statementLine{
+			TransactionID: t.ID,
+			Date:          t.Date,
+			Description:   t.Description,
+			Category:      t.Category.Name,
+		}
+
+		switch transaction.TransactionType(t.TransactionTypeID) {
+		case transaction.INCOME:
+			line.Type, line.Amount = "Income", t.Amount
+			statement.Totals.Income += t.Amount
+		case transaction.EXPENSE:
+			line.Type, line.Amount = "Expense", -t.Amount
+			statement.Totals.Expense += t.Amount
+		case transaction.TRANSFER:
+			if t.ToAccountID == account.ID {
+				line.Type, line.Amount = "Transfer in", t.Amount
+				statement.Totals.TransferIn += t.Amount
+			} else {
+				line.Type, line.Amount = "Transfer out", -t.Amount
+				statement.Totals.TransferOut += t.Amount
+			}
+		}
+
+		balance += line.Amount
+		line.Balance = balance
+		statement.Lines = append(statement.Lines, line)
+	}
+
+	statement.Totals.Net = balance - opening
+	statement.ClosingBalance = balance
+
+	return statement
+}
+
+func formatAmount(value float64) string {
+	return strconv.FormatFloat(value, 'f', 2, 64)
+}
+
+func renderStatementHTML(w io.Writer, statement *statementResponse) error {
+	return statementTemplate.Execute(w, statement)
+}
+
+// statement layout in points
+const (
+	pdfMargin     = 40.0
+	pdfLineHeight = 16.0
+	pdfBottom     = pdf.PageHeight - 60
+)
+
+// renderStatementPDF lays out the statement like the html one, the table continues on new pages
+func renderStatementPDF(w io.Writer, statement *statementResponse) error {
+	doc := pdf.New()
+	right := pdf.PageWidth - pdfMargin
+
+	// x of the columns, the amounts are aligned on the right of their column
+	dateX, descriptionX, categoryX, typeX := pdfMargin, pdfMargin+70, pdfMargin+250, pdfMargin+340
+	amountRight, balanceRight := right-80, right
+
+	doc.AddPage()
+	y := pdfMargin + 10
+
+	doc.SetFont(true, 16)
+	doc.Text(pdfMargin, y, "Account statement")
+	y += 24
+
+	doc.SetFont(false, 10)
+	doc.Text(pdfMargin, y, fmt.Sprintf("%s (%s)", statement.Account.Name, statement.Account.Currency))
+	doc.TextRight(right, y, fmt.Sprintf("%s - %s",
+		statement.PeriodStart.Format("02 Jan 2006"), statement.PeriodEnd.Format("02 Jan 2006")))
+	y += pdfLineHeight
+
+	doc.Text(pdfMargin, y, "Opening balance")
+	doc.TextRight(balanceRight, y, formatAmount(statement.OpeningBalance))
+	y += pdfLineHeight * 1.5
+
+	header := func() {
+		doc.SetFont(true, 9)
+		doc.Text(dateX, y, "Date")
+		doc.Text(descriptionX, y, "Description")
+		doc.Text(categoryX, y, "Category")
+		doc.Text(typeX, y, "Type")
+		doc.TextRight(amountRight, y, "Amount")
+		doc.TextRight(balanceRight, y, "Balance")
+		doc.Line(pdfMargin, y+5, right, y+5)
+		y += pdfLineHeight
+		doc.SetFont(false, 9)
+	}
+
+	header()
+	for _, line := range statement.Lines {
+		if y > pdfBottom {
+			doc.AddPage()
+			y = pdfMargin + 10
+			header()
+		}
+
+		doc.Text(dateX, y, line.Date.Format("02 Jan 2006"))
+		doc.Text(descriptionX, y, doc.Truncate(line.Description, categoryX-descriptionX-8))
+		doc.Text(categoryX, y, doc.Truncate(line.Category, typeX-categoryX-8))
+		doc.Text(typeX, y, line.Type)
+		doc.TextRight(amountRight, y, formatAmount(line.Amount))
+		doc.TextRight(balanceRight, y, formatAmount(line.Balance))
+		y += pdfLineHeight
+	}
+
+	if y+pdfLineHeight*8 > pdfBottom {
+		doc.AddPage()
+		y = pdfMargin + 10
+	}
+
+	doc.Line(pdfMargin, y-10, right, y-10)
+	y += 4
+
+	doc.SetFont(false, 10)
+	for _, total := range []struct {
+		label string
+		value float64
+	}{
+		{"Income", statement.Totals.Income},
+		{"Expense", statement.Totals.Expense},
+		{"Transfers in", statement.Totals.TransferIn},
+		{"Transfers out", statement.Totals.TransferOut},
+		{"Net change", statement.Totals.Net},
+	} {
+		doc.Text(typeX, y, total.label)
+		doc.TextRight(balanceRight, y, formatAmount(total.value))
+		y += pdfLineHeight
+	}
+
+	doc.SetFont(true, 11)
+	doc.Text(typeX, y+4, "Closing balance")
+	doc.TextRight(balanceRight, y+4, formatAmount(statement.ClosingBalance))
+
+	doc.SetFont(false, 8)
+	doc.Text(pdfMargin, pdf.PageHeight-pdfMargin, "Generated on "+statement.GeneratedAt.Format("02 Jan 2006 15:04 MST"))
+
+	return doc.Write(w)
+}
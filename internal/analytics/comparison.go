@@ -0,0 +1,81 @@
+package analytics
+
+import "time"
+
+const (
+	baselinePreviousMonth  = "previousMonth"
+	baselinePreviousYear   = "previousYear"
+	baselineRolling3Months = "rolling3Months"
+
+	comparisonByCategory = "category"
+	comparisonByTag      = "tag"
+	comparisonByAccount  = "account"
+)
+
+// newComparisonRange returns the month and the range it is compared with
+func newComparisonRange(month time.Time, baseline string) comparisonRange {
+	start := truncateToPeriod(month, intervalMonth)
+	ranges := comparisonRange{currentStart: start, currentEnd: start.AddDate(0, 1, 0)}
+
+	switch baseline {
+	case baselinePreviousYear:
+		ranges.baselineStart = start.AddDate(-1, 0, 0)
+		ranges.baselineEnd = start.AddDate(-1, 1, 0)
+	case baselineRolling3Months:
+		ranges.baselineStart = start.AddDate(0, -3, 0)
+		ranges.baselineEnd = start
+	default:
+		ranges.baselineStart = start.AddDate(0, -1, 0)
+		ranges.baselineEnd = start
+	}
+
+	return ranges
+}
+
+func newComparisonItem(id uint, label string, current, baseline float64) ComparisonItem {
+	item := ComparisonItem{
+		ID:       id,
+		Label:    label,
+		Current:  current,
+		Baseline: baseline,
+		Delta:    current - baseline,
+	}
+
+	if baseline != 0 {
+		percent := item.Delta / baseline * 100
+		item.DeltaPercent = &percent
+	}
+
+	return item
+}
+
+// buildComparisonReport computes the deltas. The rolling baseline is the monthly average of its three months
+func buildComparisonReport(amounts []comparisonAmount, ranges comparisonRange, baseline, groupBy, txnType string) ComparisonReport {
+	months := 1.0
+	if baseline == baselineRolling3Months {
+		months = 3
+	}
+
+	report := ComparisonReport{
+		Baseline:      baseline,
+		GroupBy:       groupBy,
+		Type:          txnType,
+		CurrentStart:  ranges.currentStart,
+		CurrentEnd:    ranges.currentEnd,
+		BaselineStart: ranges.baselineStart,
+		BaselineEnd:   ranges.baselineEnd,
+		Items:         make([]ComparisonItem, 0, len(amounts)),
+	}
+
+	var current, previous float64
+	for _, amount := range amounts {
+		report.Items = append(report.Items, newComparisonItem(amount.ID, amount.Label, amount.Current, amount.Baseline/months))
+		current += amount.Current
+		previous += amount.Baseline / months
+	}
+
+	// Grouped by tag, a transaction may be counted several times, so the total is a sum of the tags
+	report.Total = newComparisonItem(0, "Total", current, previous)
+
+	return report
+}
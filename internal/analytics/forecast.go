@@ -0,0 +1,252 @@
+package analytics
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	forecastDefaultDays = 30
+	// patternLookbackDays is how far back the history is searched for recurring transactions
+	patternLookbackDays = 365
+	// minPatternOccurrences is the number of times a transaction must repeat to be considered recurring
+	minPatternOccurrences = 3
+
+	forecastScheduled = "scheduled"
+	forecastRecurring = "recurring"
+
+	crossingBelow = "below"
+	crossingAbove = "above"
+)
+
+type patternKey struct {
+	accountID   uint
+	description string
+	// cents avoids comparing floats
+	cents int64
+}
+
+// recurringPattern is a detected pattern, with the occurrence it is projected from
+type recurringPattern struct {
+	RecurringPattern
+	accountID uint
+	anchor    time.Time
+}
+
+func dayOf(date time.Time) time.Time {
+	return truncateToPeriod(date.UTC(), intervalDay)
+}
+
+// addMonths adds months keeping the day of the month, clamped to the last day of shorter months
+func addMonths(date time.Time, months int) time.Time {
+	first := time.Date(date.Year(), date.Month()+time.Month(months), 1, 0, 0, 0, 0, date.Location())
+	lastDay := first.AddDate(0, 1, -1).Day()
+
+	day := date.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+
+	return first.AddDate(0, 0, day-1)
+}
+
+// occurrence returns the date of the n-th repetition after the anchor. Intervals close to a month
+// or a year follow the calendar, so they don't drift
+func (p *recurringPattern) occurrence(n int) time.Time {
+	switch {
+	case p.IntervalDays >= 28 && p.IntervalDays <= 31:
+		return addMonths(p.anchor, n)
+	case p.IntervalDays >= 360 && p.IntervalDays <= 370:
+		return addMonths(p.anchor, 12*n)
+	}
+
+	return p.anchor.AddDate(0, 0, n*p.IntervalDays)
+}
+
+func median(values []int) int {
+	sorted := append([]int(nil), values...)
+	sort.Ints(sorted)
+
+	return sorted[len(sorted)/2]
+}
+
+// detectPatterns groups the legs by account, description and amount and keeps the groups which repeat
+// at a regular interval. A pattern which missed more than two repetitions before now is considered ended
+func detectPatterns(legs []accountLeg, now time.Time) []recurringPattern {
+	groups := make(map[patternKey][]accountLeg)
+	var keys []patternKey
+
+	for _, leg := range legs {
+		description := strings.ToLower(strings.TrimSpace(leg.Description))
+		if description == "" {
+			continue
+		}
+
+		key := patternKey{leg.AccountID, description, int64(math.Round(leg.Amount * 100))}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+
+		groups[key] = append(groups[key], leg)
+	}
+
+	var patterns []recurringPattern
+	for _, key := range keys {
+		group := groups[key]
+		if len(group) < minPatternOccurrences {
+			continue
+		}
+
+		intervals := make([]int, 0, len(group)-1)
+		for i := 1; i < len(group); i++ {
+			intervals = append(intervals, int(dayOf(group[i].Date).Sub(dayOf(group[i-1].Date)).Hours()/24))
+		}
+
+		interval := median(intervals)
+		if interval < 1 {
+			continue
+		}
+
+		// Calendar months vary between 28 and 31 days, so the tolerance grows with the interval
+		tolerance := math.Max(2, float64(interval)*0.15)
+
+		regular := true
+		for _, i := range intervals {
+			if math.Abs(float64(i-interval)) > tolerance {
+				regular = false
+				break
+			}
+		}
+
+		if !regular {
+			continue
+		}
+
+		last := group[len(group)-1]
+		if dayOf(now).Sub(dayOf(last.Date)).Hours()/24 > 2*float64(interval)+tolerance {
+			continue
+		}
+
+		patterns = append(patterns, recurringPattern{
+			RecurringPattern: RecurringPattern{
+				Description:  strings.TrimSpace(last.Description),
+				Amount:       last.Amount,
+				IntervalDays: interval,
+				Occurrences:  len(group),
+				LastDate:     last.Date,
+			},
+			accountID: key.accountID,
+			anchor:    dayOf(last.Date),
+		})
+	}
+
+	return patterns
+}
+
+// buildForecastReport projects the balance of every account at the end of each day, from today to
+// days ahead. It starts from the balance at now, then applies the future-dated transactions and the
+// repetitions of the recurring patterns which fall after now
+func buildForecastReport(
+	accounts []accountInfo,
+	balances []accountAmount,
+	legs []accountLeg,
+	now time.Time,
+	days int,
+	threshold float64,
+) ForecastReport {
+	today := dayOf(now)
+	end := today.AddDate(0, 0, days)
+
+	current := make(map[uint]float64, len(balances))
+	for _, b := range balances {
+		current[b.AccountID] = b.Amount
+	}
+
+	entries := make(map[uint][]ForecastEntry)
+	for _, leg := range legs {
+		if leg.Date.Before(now) || dayOf(leg.Date).After(end) {
+			continue
+		}
+
+		entries[leg.AccountID] = append(entries[leg.AccountID], ForecastEntry{
+			Date:        leg.Date,
+			Description: leg.Description,
+			Amount:      leg.Amount,
+			Source:      forecastScheduled,
+		})
+	}
+
+	patterns := make(map[uint][]RecurringPattern)
+	for _, pattern := range detectPatterns(legs, now) {
+		patterns[pattern.accountID] = append(patterns[pattern.accountID], pattern.RecurringPattern)
+
+		for n := 1; ; n++ {
+			date := pattern.occurrence(n)
+			if date.After(end) {
+				break
+			}
+
+			if date.Before(today) {
+				continue
+			}
+
+			entries[pattern.accountID] = append(entries[pattern.accountID], ForecastEntry{
+				Date:        date,
+				Description: pattern.Description,
+				Amount:      pattern.Amount,
+				Source:      forecastRecurring,
+			})
+		}
+	}
+
+	report := ForecastReport{
+		Days:      days,
+		Threshold: threshold,
+		Accounts:  make([]AccountForecast, 0, len(accounts)),
+	}
+
+	for _, account := range accounts {
+		accountEntries := entries[account.ID]
+		sort.SliceStable(accountEntries, func(i, j int) bool {
+			return accountEntries[i].Date.Before(accountEntries[j].Date)
+		})
+
+		forecast := AccountForecast{
+			ID:             account.ID,
+			Name:           account.Name,
+			Currency:       account.Currency,
+			Liability:      account.Liability,
+			CurrentBalance: current[account.ID],
+			Balances:       make([]DateValue, 0, days+1),
+			Entries:        make([]ForecastEntry, 0, len(accountEntries)),
+			Patterns:       make([]RecurringPattern, 0, len(patterns[account.ID])),
+			Crossings:      make([]ThresholdCrossing, 0),
+		}
+		forecast.Entries = append(forecast.Entries, accountEntries...)
+		forecast.Patterns = append(forecast.Patterns, patterns[account.ID]...)
+
+		balance := forecast.CurrentBalance
+		next := 0
+		for day := today; !day.After(end); day = day.AddDate(0, 0, 1) {
+			previous := balance
+
+			for ; next < len(accountEntries) && dayOf(accountEntries[next].Date).Equal(day); next++ {
+				balance += accountEntries[next].Amount
+			}
+
+			forecast.Balances = append(forecast.Balances, DateValue{Date: day, Value: balance})
+
+			if previous >= threshold && balance < threshold {
+				forecast.Crossings = append(forecast.Crossings, ThresholdCrossing{Date: day, Balance: balance, Direction: crossingBelow})
+			} else if previous < threshold && balance >= threshold {
+				forecast.Crossings = append(forecast.Crossings, ThresholdCrossing{Date: day, Balance: balance, Direction: crossingAbove})
+			}
+		}
+
+		report.Accounts = append(report.Accounts, forecast)
+	}
+
+	return report
+}
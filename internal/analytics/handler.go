@@ -1,7 +1,10 @@
 package analytics
 
 import (
+	"bytes"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/emPeeGee/raffinance/internal/auth"
 	"github.com/emPeeGee/raffinance/pkg/errorutil"
@@ -22,6 +25,16 @@ func RegisterHandlers(apiRg *gin.RouterGroup, service Service, validate *validat
 		api.GET("/txnCount", h.GetTransactionsCount)
 		api.GET("/categoriesSpending", h.GetCategoriesSpending)
 		api.GET("/categoriesIncome", h.GetCategoriesIncome)
+		api.GET("/netWorth", h.GetNetWorth)
+		api.GET("/forecast", h.GetForecast)
+		api.GET("/comparison", h.GetComparison)
+		api.GET("/heatmap", h.GetExpenseHeatmap)
+		api.GET("/tagsSpending", h.GetTagsSpending)
+		api.GET("/tagsIncome", h.GetTagsIncome)
+		api.GET("/tagsEvolution", h.GetTagSeries)
+		api.GET("/tagsByCategory", h.GetTagCategories)
+		api.GET("/realizedGains", h.GetRealizedGains)
+		api.GET("/realizedGains/csv", h.GetRealizedGainsCSV)
 	}
 }
 
@@ -203,3 +216,321 @@ func (h *handler) GetTransactionsCount(c *gin.Context) {
 
 	c.JSON(http.StatusOK, income)
 }
+
+func (h *handler) GetRealizedGains(c *gin.Context) {
+	userID, err := auth.GetUserId(c)
+	if err != nil || userID == nil {
+		errorutil.Unauthorized(c, err.Error(), "missing user ID")
+		return
+	}
+
+	params := &RealizedGainsParams{}
+	if err := c.ShouldBindQuery(params); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	params.setTimeToNilIfZero()
+
+	if err := h.validate.Struct(params); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	gains, err := h.service.GetRealizedGains(*userID, params)
+	if err != nil {
+		errorutil.InternalServer(c, err.Error(), "")
+		return
+	}
+
+	c.JSON(http.StatusOK, gains)
+}
+
+func (h *handler) GetRealizedGainsCSV(c *gin.Context) {
+	userID, err := auth.GetUserId(c)
+	if err != nil || userID == nil {
+		errorutil.Unauthorized(c, err.Error(), "missing user ID")
+		return
+	}
+
+	params := &RealizedGainsParams{}
+	if err := c.ShouldBindQuery(params); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	params.setTimeToNilIfZero()
+
+	if err := h.validate.Struct(params); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := h.service.WriteRealizedGainsCSV(*userID, params, &buf); err != nil {
+		errorutil.InternalServer(c, err.Error(), "")
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="realized-gains.csv"`)
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
+
+func (h *handler) GetNetWorth(c *gin.Context) {
+	userID, err := auth.GetUserId(c)
+	if err != nil || userID == nil {
+		errorutil.Unauthorized(c, err.Error(), "missing user ID")
+		return
+	}
+
+	params := &NetWorthParams{}
+	if err := c.ShouldBindQuery(params); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	params.setTimeToNilIfZero()
+
+	if err := h.validate.Struct(params); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	netWorth, err := h.service.GetNetWorth(*userID, params)
+	if err != nil {
+		errorutil.InternalServer(c, err.Error(), "")
+		return
+	}
+
+	c.JSON(http.StatusOK, netWorth)
+}
+
+func (h *handler) GetForecast(c *gin.Context) {
+	userID, err := auth.GetUserId(c)
+	if err != nil || userID == nil {
+		errorutil.Unauthorized(c, err.Error(), "missing user ID")
+		return
+	}
+
+	params := &ForecastParams{}
+	if err := c.ShouldBindQuery(params); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	if err := h.validate.Struct(params); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	forecast, err := h.service.GetForecast(*userID, params)
+	if err != nil {
+		errorutil.InternalServer(c, err.Error(), "")
+		return
+	}
+
+	c.JSON(http.StatusOK, forecast)
+}
+
+func (h *handler) GetComparison(c *gin.Context) {
+	userID, err := auth.GetUserId(c)
+	if err != nil || userID == nil {
+		errorutil.Unauthorized(c, err.Error(), "missing user ID")
+		return
+	}
+
+	params := &ComparisonParams{}
+	if err := c.ShouldBindQuery(params); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	if err := h.validate.Struct(params); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	comparison, err := h.service.GetComparison(*userID, params)
+	if err != nil {
+		errorutil.InternalServer(c, err.Error(), "")
+		return
+	}
+
+	c.JSON(http.StatusOK, comparison)
+}
+
+func (h *handler) GetExpenseHeatmap(c *gin.Context) {
+	userID, err := auth.GetUserId(c)
+	if err != nil || userID == nil {
+		errorutil.Unauthorized(c, err.Error(), "missing user ID")
+		return
+	}
+
+	params := &HeatmapParams{}
+	if err := c.ShouldBindQuery(params); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	params.setTimeToNilIfZero()
+
+	if err := h.validate.Struct(params); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	if params.Timezone != "" {
+		if _, err := time.LoadLocation(params.Timezone); err != nil {
+			errorutil.BadRequest(c, err.Error(), "unknown timezone")
+			return
+		}
+	}
+
+	params.Accounts, err = util.ParseStringToUintArr(c.Query("accounts"))
+	if err != nil {
+		errorutil.BadRequest(c, fmt.Sprintf("invalid accounts parameter: %s", err.Error()), "")
+		return
+	}
+
+	params.Categories, err = util.ParseStringToUintArr(c.Query("categories"))
+	if err != nil {
+		errorutil.BadRequest(c, fmt.Sprintf("invalid categories parameter: %s", err.Error()), "")
+		return
+	}
+
+	params.Tags, err = util.ParseStringToUintArr(c.Query("tags"))
+	if err != nil {
+		errorutil.BadRequest(c, fmt.Sprintf("invalid tags parameter: %s", err.Error()), "")
+		return
+	}
+
+	heatmap, err := h.service.GetExpenseHeatmap(*userID, params)
+	if err != nil {
+		errorutil.InternalServer(c, err.Error(), "")
+		return
+	}
+
+	c.JSON(http.StatusOK, heatmap)
+}
+
+func (h *handler) GetTagsSpending(c *gin.Context) {
+	userID, err := auth.GetUserId(c)
+	if err != nil || userID == nil {
+		errorutil.Unauthorized(c, err.Error(), "missing user ID")
+		return
+	}
+
+	params := &RangeDateParams{}
+	if err := c.ShouldBindQuery(params); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	params.setTimeToNilIfZero()
+
+	if err := h.validate.Struct(params); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	spending, err := h.service.GetTagsSpending(*userID, params)
+	if err != nil {
+		errorutil.InternalServer(c, err.Error(), "")
+		return
+	}
+
+	c.JSON(http.StatusOK, spending)
+}
+
+func (h *handler) GetTagsIncome(c *gin.Context) {
+	userID, err := auth.GetUserId(c)
+	if err != nil || userID == nil {
+		errorutil.Unauthorized(c, err.Error(), "missing user ID")
+		return
+	}
+
+	params := &RangeDateParams{}
+	if err := c.ShouldBindQuery(params); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	params.setTimeToNilIfZero()
+
+	if err := h.validate.Struct(params); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	income, err := h.service.GetTagsIncome(*userID, params)
+	if err != nil {
+		errorutil.InternalServer(c, err.Error(), "")
+		return
+	}
+
+	c.JSON(http.StatusOK, income)
+}
+
+func (h *handler) GetTagSeries(c *gin.Context) {
+	userID, err := auth.GetUserId(c)
+	if err != nil || userID == nil {
+		errorutil.Unauthorized(c, err.Error(), "missing user ID")
+		return
+	}
+
+	params := &TagSeriesParams{}
+	if err := c.ShouldBindQuery(params); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	params.setTimeToNilIfZero()
+
+	if err := h.validate.Struct(params); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	params.Tags, err = util.ParseStringToUintArr(c.Query("tags"))
+	if err != nil {
+		errorutil.BadRequest(c, fmt.Sprintf("invalid tags parameter: %s", err.Error()), "")
+		return
+	}
+
+	series, err := h.service.GetTagSeries(*userID, params)
+	if err != nil {
+		errorutil.InternalServer(c, err.Error(), "")
+		return
+	}
+
+	c.JSON(http.StatusOK, series)
+}
+
+func (h *handler) GetTagCategories(c *gin.Context) {
+	userID, err := auth.GetUserId(c)
+	if err != nil || userID == nil {
+		errorutil.Unauthorized(c, err.Error(), "missing user ID")
+		return
+	}
+
+	params := &TagCrossParams{}
+	if err := c.ShouldBindQuery(params); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	params.setTimeToNilIfZero()
+
+	if err := h.validate.Struct(params); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	cross, err := h.service.GetTagCategories(*userID, params)
+	if err != nil {
+		errorutil.InternalServer(c, err.Error(), "")
+		return
+	}
+
+	c.JSON(http.StatusOK, cross)
+}
@@ -0,0 +1,33 @@
+package analytics
+
+// buildHeatmapReport fills the whole week, monday to sunday, hour by hour, so empty cells are reported too
+func buildHeatmapReport(cells []HeatmapCell, timezone string) HeatmapReport {
+	report := HeatmapReport{
+		Timezone: timezone,
+		Cells:    make([]HeatmapCell, 0, 7*24),
+	}
+
+	for weekday := 1; weekday <= 7; weekday++ {
+		for hour := 0; hour < 24; hour++ {
+			report.Cells = append(report.Cells, HeatmapCell{Weekday: weekday, Hour: hour})
+		}
+	}
+
+	for _, cell := range cells {
+		if cell.Weekday < 1 || cell.Weekday > 7 || cell.Hour < 0 || cell.Hour > 23 {
+			continue
+		}
+
+		report.Cells[(cell.Weekday-1)*24+cell.Hour] = cell
+
+		if cell.Amount > report.MaxAmount {
+			report.MaxAmount = cell.Amount
+		}
+
+		if cell.Count > report.MaxCount {
+			report.MaxCount = cell.Count
+		}
+	}
+
+	return report
+}
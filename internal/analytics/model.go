@@ -3,6 +3,7 @@ package analytics
 import (
 	"time"
 
+	"github.com/emPeeGee/raffinance/internal/investment"
 	"github.com/go-playground/validator"
 )
 
@@ -27,6 +28,246 @@ type BalanceEvolutionParams struct {
 	AccountID *uint `form:"account_id"`
 }
 
+type RealizedGainsParams struct {
+	RangeDateParams
+	AccountID *uint `form:"account_id"`
+	TaxYear   int   `form:"tax_year" binding:"omitempty,gte=1900"`
+}
+
+type GainTotals struct {
+	Proceeds      float64 `json:"proceeds"`
+	CostBasis     float64 `json:"costBasis"`
+	Gain          float64 `json:"gain"`
+	ShortTermGain float64 `json:"shortTermGain"`
+	LongTermGain  float64 `json:"longTermGain"`
+}
+
+type SaleGain struct {
+	SaleID    uint                             `json:"saleId"`
+	AccountID uint                             `json:"accountId"`
+	Security  investment.SecurityShortResponse `json:"security"`
+	SoldAt    time.Time                        `json:"soldAt"`
+	Quantity  float64                          `json:"quantity"`
+	GainTotals
+	Lots []investment.LotSale `json:"lots"`
+}
+
+type TaxYearGain struct {
+	Year int `json:"year"`
+	GainTotals
+}
+
+type RealizedGainsReport struct {
+	Sales    []SaleGain    `json:"sales"`
+	TaxYears []TaxYearGain `json:"taxYears"`
+	Total    GainTotals    `json:"total"`
+}
+
+type NetWorthParams struct {
+	RangeDateParams
+	Interval string `form:"interval" binding:"omitempty,oneof=day week month"`
+}
+
+type NetWorthAccount struct {
+	ID        uint        `json:"id"`
+	Name      string      `json:"name"`
+	Currency  string      `json:"currency"`
+	Liability bool        `json:"liability"`
+	Balances  []DateValue `json:"balances"`
+}
+
+type NetWorthPoint struct {
+	Date        time.Time `json:"date"`
+	Assets      float64   `json:"assets"`
+	Liabilities float64   `json:"liabilities"`
+	NetWorth    float64   `json:"netWorth"`
+}
+
+type NetWorthReport struct {
+	Interval string            `json:"interval"`
+	Accounts []NetWorthAccount `json:"accounts"`
+	Points   []NetWorthPoint   `json:"points"`
+}
+
+type ForecastParams struct {
+	AccountID *uint   `form:"account_id"`
+	Days      int     `form:"days" binding:"omitempty,gte=1,lte=365"`
+	Threshold float64 `form:"threshold"`
+}
+
+// RecurringPattern is a transaction repeating with the same description and amount at a regular interval
+type RecurringPattern struct {
+	Description  string    `json:"description"`
+	Amount       float64   `json:"amount"`
+	IntervalDays int       `json:"intervalDays"`
+	Occurrences  int       `json:"occurrences"`
+	LastDate     time.Time `json:"lastDate"`
+}
+
+type ForecastEntry struct {
+	Date        time.Time `json:"date"`
+	Description string    `json:"description"`
+	Amount      float64   `json:"amount"`
+	// Source is scheduled for future-dated transactions and recurring for the projected patterns
+	Source string `json:"source"`
+}
+
+type ThresholdCrossing struct {
+	Date    time.Time `json:"date"`
+	Balance float64   `json:"balance"`
+	// Direction is below when the balance drops under the threshold and above when it recovers
+	Direction string `json:"direction"`
+}
+
+type AccountForecast struct {
+	ID             uint                `json:"id"`
+	Name           string              `json:"name"`
+	Currency       string              `json:"currency"`
+	Liability      bool                `json:"liability"`
+	CurrentBalance float64             `json:"currentBalance"`
+	Balances       []DateValue         `json:"balances"`
+	Entries        []ForecastEntry     `json:"entries"`
+	Patterns       []RecurringPattern  `json:"patterns"`
+	Crossings      []ThresholdCrossing `json:"crossings"`
+}
+
+type ForecastReport struct {
+	Days      int               `json:"days"`
+	Threshold float64           `json:"threshold"`
+	Accounts  []AccountForecast `json:"accounts"`
+}
+
+type ComparisonParams struct {
+	// Month is the month compared, the current month by default
+	Month    *time.Time `form:"month" time_format:"2006-01" time_utc:"1"`
+	Baseline string     `form:"baseline" binding:"omitempty,oneof=previousMonth previousYear rolling3Months"`
+	GroupBy  string     `form:"group_by" binding:"omitempty,oneof=category tag account"`
+	Type     string     `form:"type" binding:"omitempty,oneof=income expense"`
+}
+
+type ComparisonItem struct {
+	ID       uint    `json:"id"`
+	Label    string  `json:"label"`
+	Current  float64 `json:"current"`
+	Baseline float64 `json:"baseline"`
+	Delta    float64 `json:"delta"`
+	// DeltaPercent is nil when there is nothing to compare with
+	DeltaPercent *float64 `json:"deltaPercent"`
+}
+
+type ComparisonReport struct {
+	Baseline      string           `json:"baseline"`
+	GroupBy       string           `json:"groupBy"`
+	Type          string           `json:"type"`
+	CurrentStart  time.Time        `json:"currentStart"`
+	CurrentEnd    time.Time        `json:"currentEnd"`
+	BaselineStart time.Time        `json:"baselineStart"`
+	BaselineEnd   time.Time        `json:"baselineEnd"`
+	Items         []ComparisonItem `json:"items"`
+	Total         ComparisonItem   `json:"total"`
+}
+
+// comparisonRange holds the compared ranges, the ends are exclusive
+type comparisonRange struct {
+	currentStart  time.Time
+	currentEnd    time.Time
+	baselineStart time.Time
+	baselineEnd   time.Time
+}
+
+// comparisonAmount is the amount of a group in both ranges
+type comparisonAmount struct {
+	ID       uint
+	Label    string
+	Current  float64
+	Baseline float64
+}
+
+type HeatmapParams struct {
+	RangeDateParams
+	// Timezone is the IANA name of the user timezone, UTC by default
+	Timezone   string `form:"timezone"`
+	Accounts   []uint `form:"-"`
+	Categories []uint `form:"-"`
+	Tags       []uint `form:"-"`
+}
+
+type HeatmapCell struct {
+	// Weekday goes from 1 (monday) to 7 (sunday)
+	Weekday int     `json:"weekday"`
+	Hour    int     `json:"hour"`
+	Count   int     `json:"count"`
+	Amount  float64 `json:"amount"`
+}
+
+type HeatmapReport struct {
+	Timezone  string        `json:"timezone"`
+	Cells     []HeatmapCell `json:"cells"`
+	MaxAmount float64       `json:"maxAmount"`
+	MaxCount  int           `json:"maxCount"`
+}
+
+type TagSeriesParams struct {
+	RangeDateParams
+	Interval string `form:"interval" binding:"omitempty,oneof=day week month"`
+	Type     string `form:"type" binding:"omitempty,oneof=income expense"`
+	Tags     []uint `form:"-"`
+}
+
+type TagCrossParams struct {
+	RangeDateParams
+	Type string `form:"type" binding:"omitempty,oneof=income expense"`
+}
+
+type TagSeries struct {
+	ID     uint        `json:"id"`
+	Name   string      `json:"name"`
+	Color  string      `json:"color"`
+	Total  float64     `json:"total"`
+	Points []DateValue `json:"points"`
+}
+
+type TagCategoryValue struct {
+	TagID      uint    `json:"tagId"`
+	Tag        string  `json:"tag"`
+	CategoryID uint    `json:"categoryId"`
+	Category   string  `json:"category"`
+	Count      int     `json:"count"`
+	Amount     float64 `json:"amount"`
+}
+
+// tagAmount is the amount of a tag in a period
+type tagAmount struct {
+	TagID  uint
+	Name   string
+	Color  string
+	Period time.Time
+	Amount float64
+}
+
+// accountInfo is an account of the user as needed by the reports
+type accountInfo struct {
+	ID        uint
+	Name      string
+	Currency  string
+	Liability bool
+}
+
+// accountAmount is an amount per account, optionally per period
+type accountAmount struct {
+	AccountID uint
+	Period    time.Time
+	Amount    float64
+}
+
+// accountLeg is a single movement of an account with the description of its transaction
+type accountLeg struct {
+	AccountID   uint
+	Date        time.Time
+	Amount      float64
+	Description string
+}
+
 type LabelValue struct {
 	Label string  `json:"label"`
 	Value float64 `json:"value"`
@@ -0,0 +1,113 @@
+package analytics
+
+import (
+	"time"
+)
+
+const (
+	intervalDay   = "day"
+	intervalWeek  = "week"
+	intervalMonth = "month"
+)
+
+// truncateToPeriod returns the start of the day, week (monday) or month of the date, like date_trunc does
+func truncateToPeriod(date time.Time, interval string) time.Time {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+
+	switch interval {
+	case intervalWeek:
+		// time.Weekday starts on sunday, date_trunc weeks start on monday
+		offset := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -offset)
+	case intervalMonth:
+		return time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, date.Location())
+	}
+
+	return day
+}
+
+func nextPeriod(period time.Time, interval string) time.Time {
+	switch interval {
+	case intervalWeek:
+		return period.AddDate(0, 0, 7)
+	case intervalMonth:
+		return period.AddDate(0, 1, 0)
+	}
+
+	return period.AddDate(0, 0, 1)
+}
+
+// periodsBetween lists the start of every period touched by the range
+func periodsBetween(start, end time.Time, interval string) []time.Time {
+	var periods []time.Time
+
+	for period := truncateToPeriod(start, interval); !period.After(end); period = nextPeriod(period, interval) {
+		periods = append(periods, period)
+	}
+
+	return periods
+}
+
+// buildNetWorthReport accumulates the movements over the opening balances. The balance of a period
+// is the balance at its end. A liability account goes negative as it is spent, its liability is the
+// amount owed, the opposite of its balance
+func buildNetWorthReport(
+	accounts []accountInfo,
+	opening []accountAmount,
+	movements []accountAmount,
+	periods []time.Time,
+	interval string,
+) NetWorthReport {
+	balances := make(map[uint]float64, len(accounts))
+	for _, o := range opening {
+		balances[o.AccountID] = o.Amount
+	}
+
+	byPeriod := make(map[int64]map[uint]float64)
+	for _, m := range movements {
+		period := m.Period.Unix()
+		if byPeriod[period] == nil {
+			byPeriod[period] = make(map[uint]float64)
+		}
+
+		byPeriod[period][m.AccountID] += m.Amount
+	}
+
+	report := NetWorthReport{
+		Interval: interval,
+		Accounts: make([]NetWorthAccount, len(accounts)),
+		Points:   make([]NetWorthPoint, 0, len(periods)),
+	}
+
+	for i, account := range accounts {
+		report.Accounts[i] = NetWorthAccount{
+			ID:        account.ID,
+			Name:      account.Name,
+			Currency:  account.Currency,
+			Liability: account.Liability,
+			Balances:  make([]DateValue, 0, len(periods)),
+		}
+	}
+
+	for _, period := range periods {
+		point := NetWorthPoint{Date: period}
+
+		for i, account := range accounts {
+			balances[account.ID] += byPeriod[period.Unix()][account.ID]
+			balance := balances[account.ID]
+
+			report.Accounts[i].Balances = append(report.Accounts[i].Balances, DateValue{Date: period, Value: balance})
+
+			if account.Liability {
+				point.Liabilities -= balance
+			} else {
+				point.Assets += balance
+			}
+		}
+
+		point.NetWorth = point.Assets - point.Liabilities
+		report.Points = append(report.Points, point)
+	}
+
+	return report
+}
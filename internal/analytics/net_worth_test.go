@@ -0,0 +1,41 @@
+package analytics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildNetWorthReportLiabilitySpentAndRepaid(t *testing.T) {
+	january := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	february := time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)
+
+	accounts := []accountInfo{
+		{ID: 1, Name: "Checking", Currency: "EUR"},
+		{ID: 2, Name: "Card", Currency: "EUR", Liability: true},
+	}
+	opening := []accountAmount{{AccountID: 1, Amount: 1000}}
+	movements := []accountAmount{
+		// the card spends 500 in january
+		{AccountID: 2, Period: january, Amount: -500},
+		// and is repaid from the checking account in february
+		{AccountID: 1, Period: february, Amount: -500},
+		{AccountID: 2, Period: february, Amount: 500},
+	}
+
+	report := buildNetWorthReport(accounts, opening, movements, []time.Time{january, february}, intervalMonth)
+
+	expected := []NetWorthPoint{
+		{Date: january, Assets: 1000, Liabilities: 500, NetWorth: 500},
+		{Date: february, Assets: 500, Liabilities: 0, NetWorth: 500},
+	}
+
+	if len(report.Points) != len(expected) {
+		t.Fatalf("got %d points, expected %d", len(report.Points), len(expected))
+	}
+
+	for i, point := range report.Points {
+		if point != expected[i] {
+			t.Errorf("point %d is %+v, expected %+v", i, point, expected[i])
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package analytics
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/emPeeGee/raffinance/internal/investment"
+)
+
+// longTermHoldingDays is the holding period after which a gain is considered long term
+const longTermHoldingDays = 365
+
+var realizedGainsCSVHeader = []string{
+	"sale_id", "lot_id", "account_id", "symbol", "currency", "lot_method", "acquired_at", "sold_at",
+	"quantity", "cost_basis", "proceeds", "gain", "holding_days", "term",
+}
+
+func (t *GainTotals) add(sale investment.LotSale) {
+	t.Proceeds += sale.Proceeds
+	t.CostBasis += sale.CostBasis
+	t.Gain += sale.Gain
+
+	if sale.HoldingDays > longTermHoldingDays {
+		t.LongTermGain += sale.Gain
+	} else {
+		t.ShortTermGain += sale.Gain
+	}
+}
+
+// filterLotSales keeps the lot sales sold within the date range and the tax year of the params
+func filterLotSales(sales []investment.LotSale, params *RealizedGainsParams) []investment.LotSale {
+	filtered := make([]investment.LotSale, 0, len(sales))
+
+	for _, sale := range sales {
+		if params.TaxYear != 0 && sale.SoldAt.Year() != params.TaxYear {
+			continue
+		}
+
+		if params.StartDate != nil && sale.SoldAt.Before(*params.StartDate) {
+			continue
+		}
+
+		if params.EndDate != nil && sale.SoldAt.After(*params.EndDate) {
+			continue
+		}
+
+		filtered = append(filtered, sale)
+	}
+
+	return filtered
+}
+
+// buildRealizedGainsReport groups the lot sales per sale and per tax year (calendar year of the sale)
+func buildRealizedGainsReport(sales []investment.LotSale) RealizedGainsReport {
+	report := RealizedGainsReport{
+		Sales:    make([]SaleGain, 0),
+		TaxYears: make([]TaxYearGain, 0),
+	}
+
+	saleIndex := make(map[uint]int)
+	yearIndex := make(map[int]int)
+
+	for _, sale := range sales {
+		i, ok := saleIndex[sale.SaleID]
+		if !ok {
+			i = len(report.Sales)
+			saleIndex[sale.SaleID] = i
+			report.Sales = append(report.Sales, SaleGain{
+				SaleID:    sale.SaleID,
+				AccountID: sale.AccountID,
+				Security:  sale.Security,
+				SoldAt:    sale.SoldAt,
+			})
+		}
+
+		report.Sales[i].Quantity += sale.Quantity
+		report.Sales[i].GainTotals.add(sale)
+		report.Sales[i].Lots = append(report.Sales[i].Lots, sale)
+
+		year := sale.SoldAt.Year()
+		j, ok := yearIndex[year]
+		if !ok {
+			j = len(report.TaxYears)
+			yearIndex[year] = j
+			report.TaxYears = append(report.TaxYears, TaxYearGain{Year: year})
+		}
+
+		report.TaxYears[j].GainTotals.add(sale)
+		report.Total.add(sale)
+	}
+
+	sort.Slice(report.TaxYears, func(i, j int) bool {
+		return report.TaxYears[i].Year < report.TaxYears[j].Year
+	})
+
+	return report
+}
+
+// writeRealizedGainsCSV writes one row per lot sale
+func writeRealizedGainsCSV(w io.Writer, sales []investment.LotSale) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write(realizedGainsCSVHeader); err != nil {
+		return err
+	}
+
+	for _, sale := range sales {
+		term := "short"
+		if sale.HoldingDays > longTermHoldingDays {
+			term = "long"
+		}
+
+		if err := writer.Write([]string{
+			strconv.FormatUint(uint64(sale.SaleID), 10),
+			strconv.FormatUint(uint64(sale.LotID), 10),
+			strconv.FormatUint(uint64(sale.AccountID), 10),
+			sale.Security.Symbol,
+			sale.Security.Currency,
+			string(sale.LotMethod),
+			sale.AcquiredAt.Format("2006-01-02"),
+			sale.SoldAt.Format("2006-01-02"),
+			formatFloat(sale.Quantity),
+			formatFloat(sale.CostBasis),
+			formatFloat(sale.Proceeds),
+			formatFloat(sale.Gain),
+			strconv.Itoa(sale.HoldingDays),
+			term,
+		}); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,109 @@
+package analytics
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/emPeeGee/raffinance/internal/investment"
+)
+
+func lotSale(saleID, lotID uint, acquired, sold string, quantity, costBasis, proceeds float64) investment.LotSale {
+	acquiredAt, _ := time.Parse("2006-01-02", acquired)
+	soldAt, _ := time.Parse("2006-01-02", sold)
+
+	return investment.LotSale{
+		SaleID:      saleID,
+		LotID:       lotID,
+		AccountID:   1,
+		Security:    investment.SecurityShortResponse{ID: 1, Symbol: "AAPL", Name: "Apple", Currency: "USD"},
+		LotMethod:   investment.FIFO,
+		AcquiredAt:  acquiredAt,
+		SoldAt:      soldAt,
+		Quantity:    quantity,
+		CostBasis:   costBasis,
+		Proceeds:    proceeds,
+		Gain:        proceeds - costBasis,
+		HoldingDays: int(soldAt.Sub(acquiredAt).Hours() / 24),
+	}
+}
+
+// testLotSales are a sale on new year's eve matched to a short and a long term lot, then a sale
+// on the next day
+func testLotSales() []investment.LotSale {
+	return []investment.LotSale{
+		lotSale(10, 1, "2022-06-01", "2023-12-31", 5, 500, 700),
+		lotSale(10, 2, "2023-11-01", "2023-12-31", 2, 240, 280),
+		lotSale(11, 2, "2023-11-01", "2024-01-01", 3, 360, 330),
+	}
+}
+
+func TestBuildRealizedGainsReportSplitsTaxYears(t *testing.T) {
+	report := buildRealizedGainsReport(testLotSales())
+
+	if len(report.Sales) != 2 {
+		t.Fatalf("got %d sales, expected 2", len(report.Sales))
+	}
+
+	first := report.Sales[0]
+	if first.SaleID != 10 || first.Quantity != 7 || len(first.Lots) != 2 {
+		t.Errorf("first sale is %d of %f in %d lots, expected 10 of 7 in 2 lots", first.SaleID, first.Quantity, len(first.Lots))
+	}
+
+	if first.LongTermGain != 200 || first.ShortTermGain != 40 || first.Gain != 240 {
+		t.Errorf("first sale gains %+v, expected 200 long and 40 short term", first.GainTotals)
+	}
+
+	want := []TaxYearGain{
+		{Year: 2023, GainTotals: GainTotals{Proceeds: 980, CostBasis: 740, Gain: 240, ShortTermGain: 40, LongTermGain: 200}},
+		{Year: 2024, GainTotals: GainTotals{Proceeds: 330, CostBasis: 360, Gain: -30, ShortTermGain: -30}},
+	}
+
+	if !reflect.DeepEqual(report.TaxYears, want) {
+		t.Errorf("tax years are %+v, expected %+v", report.TaxYears, want)
+	}
+
+	total := GainTotals{Proceeds: 1310, CostBasis: 1100, Gain: 210, ShortTermGain: 10, LongTermGain: 200}
+	if report.Total != total {
+		t.Errorf("total is %+v, expected %+v", report.Total, total)
+	}
+}
+
+func TestFilterLotSalesByTaxYear(t *testing.T) {
+	sales := filterLotSales(testLotSales(), &RealizedGainsParams{TaxYear: 2024})
+	if len(sales) != 1 || sales[0].SaleID != 11 {
+		t.Errorf("kept %+v, expected the sale 11 of 2024", sales)
+	}
+
+	sales = filterLotSales(testLotSales(), &RealizedGainsParams{TaxYear: 2023})
+	if len(sales) != 2 {
+		t.Errorf("kept %d lot sales of 2023, expected 2", len(sales))
+	}
+}
+
+func TestWriteRealizedGainsCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeRealizedGainsCSV(&buf, testLotSales()[:2]); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{
+			"sale_id", "lot_id", "account_id", "symbol", "currency", "lot_method", "acquired_at", "sold_at",
+			"quantity", "cost_basis", "proceeds", "gain", "holding_days", "term",
+		},
+		{"10", "1", "1", "AAPL", "USD", "FIFO", "2022-06-01", "2023-12-31", "5", "500", "700", "200", "578", "long"},
+		{"10", "2", "1", "AAPL", "USD", "FIFO", "2023-11-01", "2023-12-31", "2", "240", "280", "40", "60", "short"},
+	}
+
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("csv is\n%v\nexpected\n%v", rows, want)
+	}
+}
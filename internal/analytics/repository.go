@@ -1,6 +1,8 @@
 package analytics
 
 import (
+	"fmt"
+	"sort"
 	"time"
 
 	"github.com/emPeeGee/raffinance/internal/entity"
@@ -16,6 +18,16 @@ type Repository interface {
 	GetTopTransactions(userID uint, params *TopTransactionsParams) ([]entity.Transaction, error)
 	GetCategoriesReport(userID uint, txnType transaction.TransactionType, params *RangeDateParams) ([]LabelValue, error)
 	GetTransactionCountByDay(userID uint, params *YearlyTransactionsParams) ([]DateValue, error)
+
+	GetAccounts(userID uint) ([]accountInfo, error)
+	GetAccountBalancesBefore(userID uint, before time.Time) ([]accountAmount, error)
+	GetAccountMovementsByPeriod(userID uint, interval string, start, end time.Time) ([]accountAmount, error)
+	GetComparison(userID uint, groupBy string, txnType transaction.TransactionType, ranges comparisonRange) ([]comparisonAmount, error)
+	GetExpenseHeatmap(userID uint, params *HeatmapParams) ([]HeatmapCell, error)
+	GetTagsReport(userID uint, txnType transaction.TransactionType, params *RangeDateParams) ([]LabelValue, error)
+	GetTagsByPeriod(userID uint, txnType transaction.TransactionType, interval string, start, end time.Time, tags []uint) ([]tagAmount, error)
+	GetTagCategoryReport(userID uint, txnType transaction.TransactionType, params *RangeDateParams) ([]TagCategoryValue, error)
+	GetAccountLegsSince(userID uint, accountID *uint, since time.Time) ([]accountLeg, error)
 }
 
 type repository struct {
@@ -52,28 +64,50 @@ func (r *repository) GetCashFlowReport(userID uint, params *RangeDateParams) ([]
 	return trends, nil
 }
 
+// GetBalanceEvolutionReport follows the balance day by day. It works on the account legs, so a
+// transfer moves money out of one account and into the other, and starts from the balance the
+// account (or all the accounts of the user) had right before the start date
 func (r *repository) GetBalanceEvolutionReport(userID uint, params *BalanceEvolutionParams) ([]DateValue, error) {
 	var reports []DateValue
 
-	query := r.db.Table("transactions").
-		Select("date::date AS date, SUM(CASE WHEN transactions.transaction_type_id = 1 THEN transactions.amount WHEN transactions.transaction_type_id = 2 THEN -transactions.amount ELSE 0  END) AS value").
-		Joins("JOIN accounts ON transactions.to_account_id = accounts.id").
-		Where("transactions.deleted_at IS NULL AND accounts.user_id = ?", userID).
-		Group("date::date").
-		Order("date::date ASC")
+	legs := func() *gorm.DB {
+		query := r.db.Table("(?) AS legs", gorm.Expr(transaction.AccountLegsQuery)).
+			Joins("JOIN accounts ON accounts.id = legs.account_id").
+			Where("accounts.user_id = ? AND accounts.deleted_at IS NULL", userID)
+
+		if params.AccountID != nil {
+			query = query.Where("legs.account_id = ?", params.AccountID)
+		}
+
+		return query
+	}
 
+	var balance float64 = 0
 	if params.StartDate != nil {
-		query = query.Where("date >= ?", params.StartDate)
+		filter := transaction.BalanceFilter{UserID: &userID, Before: params.StartDate}
+		if params.AccountID != nil {
+			filter.AccountIDs = []uint{*params.AccountID}
+		}
+
+		opening, err := transaction.Balance(r.db, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		balance = opening
 	}
 
-	if params.EndDate != nil {
-		query = query.Where("date <= ?", params.EndDate)
+	query := legs().
+		Select("legs.date::date AS date, SUM(legs.amount) AS value").
+		Group("legs.date::date").
+		Order("legs.date::date ASC")
+
+	if params.StartDate != nil {
+		query = query.Where("legs.date >= ?", params.StartDate)
 	}
 
-	if params.AccountID != nil {
-		// If there are account id, when it should be calculated different:
-		// transfers should be taken into account
-		query = query.Where("transactions.to_account_id = ?", params.AccountID)
+	if params.EndDate != nil {
+		query = query.Where("legs.date <= ?", params.EndDate)
 	}
 
 	rows, err := query.Rows()
@@ -82,7 +116,6 @@ func (r *repository) GetBalanceEvolutionReport(userID uint, params *BalanceEvolu
 	}
 	defer rows.Close()
 
-	var balance float64 = 0
 	for rows.Next() {
 		var report DateValue
 		var date time.Time
@@ -96,7 +129,7 @@ func (r *repository) GetBalanceEvolutionReport(userID uint, params *BalanceEvolu
 		reports = append(reports, report)
 	}
 
-	return reports, nil
+	return reports, rows.Err()
 }
 
 func (r *repository) GetTopTransactions(userID uint, params *TopTransactionsParams) ([]entity.Transaction, error) {
@@ -165,3 +198,225 @@ func (r *repository) GetTransactionCountByDay(userID uint, params *YearlyTransac
 
 	return counts, nil
 }
+
+func (r *repository) GetAccounts(userID uint) ([]accountInfo, error) {
+	var accounts []accountInfo
+
+	if err := r.db.Model(&entity.Account{}).
+		Select("id, name, currency, liability").
+		Where("user_id = ?", userID).
+		Order("id ASC").
+		Scan(&accounts).Error; err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+// GetAccountBalancesBefore returns the balance of every account of the user right before the date
+func (r *repository) GetAccountBalancesBefore(userID uint, before time.Time) ([]accountAmount, error) {
+	byAccount, err := transaction.Balances(r.db, transaction.BalanceFilter{UserID: &userID, Before: &before})
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make([]accountAmount, 0, len(byAccount))
+	for accountID, amount := range byAccount {
+		balances = append(balances, accountAmount{AccountID: accountID, Amount: amount})
+	}
+
+	sort.Slice(balances, func(i, j int) bool {
+		return balances[i].AccountID < balances[j].AccountID
+	})
+
+	return balances, nil
+}
+
+// GetAccountMovementsByPeriod sums the movements of every account of the user per day, week or month
+func (r *repository) GetAccountMovementsByPeriod(userID uint, interval string, start, end time.Time) ([]accountAmount, error) {
+	var movements []accountAmount
+
+	query := `
+		SELECT legs.account_id, date_trunc(?, legs.date AT TIME ZONE 'UTC') AS period, SUM(legs.amount) AS amount
+		FROM (` + transaction.AccountLegsQuery + `) AS legs
+		JOIN accounts ON accounts.id = legs.account_id
+		WHERE accounts.user_id = ? AND accounts.deleted_at IS NULL AND legs.date BETWEEN ? AND ?
+		GROUP BY legs.account_id, period
+		ORDER BY period ASC`
+
+	if err := r.db.Raw(query, interval, userID, start, end).Scan(&movements).Error; err != nil {
+		return nil, err
+	}
+
+	return movements, nil
+}
+
+// GetAccountLegsSince returns the movements of the accounts of the user from the date on, future-dated ones included
+func (r *repository) GetAccountLegsSince(userID uint, accountID *uint, since time.Time) ([]accountLeg, error) {
+	var legs []accountLeg
+
+	query := r.db.Table("(?) AS legs", gorm.Expr(transaction.AccountLegsQuery)).
+		Select("legs.account_id, legs.date, legs.amount, transactions.description").
+		Joins("JOIN accounts ON accounts.id = legs.account_id").
+		Joins("JOIN transactions ON transactions.id = legs.id").
+		Where("accounts.user_id = ? AND accounts.deleted_at IS NULL AND legs.date >= ?", userID, since).
+		Order("legs.date ASC, legs.id ASC")
+
+	if accountID != nil {
+		query = query.Where("legs.account_id = ?", accountID)
+	}
+
+	if err := query.Scan(&legs).Error; err != nil {
+		return nil, err
+	}
+
+	return legs, nil
+}
+
+// GetComparison sums the transactions of the type per category, tag or account within both ranges.
+// A transaction with several tags counts for each of them
+func (r *repository) GetComparison(userID uint, groupBy string, txnType transaction.TransactionType, ranges comparisonRange) ([]comparisonAmount, error) {
+	var amounts []comparisonAmount
+
+	query := r.db.Table("transactions").
+		Joins("JOIN accounts ON accounts.id = transactions.to_account_id").
+		Where("accounts.user_id = ? AND transactions.deleted_at IS NULL AND transactions.transaction_type_id = ?", userID, txnType).
+		Where("(transactions.date >= ? AND transactions.date < ?) OR (transactions.date >= ? AND transactions.date < ?)",
+			ranges.currentStart, ranges.currentEnd, ranges.baselineStart, ranges.baselineEnd)
+
+	id, label := "accounts.id", "accounts.name"
+	switch groupBy {
+	case comparisonByCategory:
+		query = query.Joins("JOIN categories ON categories.id = transactions.category_id")
+		id, label = "categories.id", "categories.name"
+	case comparisonByTag:
+		query = query.Joins("JOIN transaction_tags ON transaction_tags.transaction_id = transactions.id").
+			Joins("JOIN tags ON tags.id = transaction_tags.tag_id AND tags.deleted_at IS NULL")
+		id, label = "tags.id", "tags.name"
+	}
+
+	if err := query.
+		Select(fmt.Sprintf(`%s AS id, %s AS label,
+			COALESCE(SUM(transactions.amount) FILTER (WHERE transactions.date >= ? AND transactions.date < ?), 0) AS current,
+			COALESCE(SUM(transactions.amount) FILTER (WHERE transactions.date >= ? AND transactions.date < ?), 0) AS baseline`, id, label),
+			ranges.currentStart, ranges.currentEnd, ranges.baselineStart, ranges.baselineEnd).
+		Group(id + ", " + label).
+		Order(label + " ASC").
+		Scan(&amounts).Error; err != nil {
+		return nil, err
+	}
+
+	return amounts, nil
+}
+
+// GetExpenseHeatmap sums the expenses per weekday and hour, both taken in the timezone of the params
+func (r *repository) GetExpenseHeatmap(userID uint, params *HeatmapParams) ([]HeatmapCell, error) {
+	var cells []HeatmapCell
+
+	query := r.db.Table("transactions").
+		Select(`EXTRACT(ISODOW FROM transactions.date AT TIME ZONE ?)::int AS weekday,
+			EXTRACT(HOUR FROM transactions.date AT TIME ZONE ?)::int AS hour,
+			COUNT(*) AS count, SUM(transactions.amount) AS amount`, params.Timezone, params.Timezone).
+		Joins("JOIN accounts ON accounts.id = transactions.to_account_id").
+		Where("accounts.user_id = ? AND transactions.deleted_at IS NULL AND transactions.transaction_type_id = ?", userID, transaction.EXPENSE).
+		Group("weekday, hour").
+		Order("weekday ASC, hour ASC")
+
+	if params.StartDate != nil && params.EndDate != nil {
+		query = query.Where("transactions.date BETWEEN ? AND ?", params.StartDate, params.EndDate)
+	}
+
+	if len(params.Accounts) > 0 {
+		query = query.Where("transactions.to_account_id IN (?)", params.Accounts)
+	}
+
+	if len(params.Categories) > 0 {
+		query = query.Where("transactions.category_id IN (?)", params.Categories)
+	}
+
+	if len(params.Tags) > 0 {
+		subquery := r.db.Table("transaction_tags").
+			Select("DISTINCT transaction_id").
+			Where("tag_id IN (?)", params.Tags)
+		query = query.Where("transactions.id IN (?)", subquery)
+	}
+
+	if err := query.Scan(&cells).Error; err != nil {
+		return nil, err
+	}
+
+	return cells, nil
+}
+
+// tagTransactions joins the transactions of the type to their tags. A transaction with several tags yields
+// one row per tag, so its whole amount counts for each of its tags, and untagged transactions are left out
+func (r *repository) tagTransactions(userID uint, txnType transaction.TransactionType) *gorm.DB {
+	return r.db.Table("transactions").
+		Joins("JOIN accounts ON accounts.id = transactions.to_account_id").
+		Joins("JOIN transaction_tags ON transaction_tags.transaction_id = transactions.id").
+		Joins("JOIN tags ON tags.id = transaction_tags.tag_id").
+		Where("accounts.user_id = ? AND transactions.transaction_type_id = ?", userID, txnType).
+		Where("transactions.deleted_at IS NULL AND tags.deleted_at IS NULL")
+}
+
+func (r *repository) GetTagsReport(userID uint, txnType transaction.TransactionType, params *RangeDateParams) ([]LabelValue, error) {
+	var byTag []LabelValue
+
+	query := r.tagTransactions(userID, txnType).
+		Select("tags.name AS label, SUM(transactions.amount) AS value").
+		Group("tags.id, tags.name").
+		Order("value DESC")
+
+	if params.StartDate != nil && params.EndDate != nil {
+		query = query.Where("transactions.date BETWEEN ? AND ?", params.StartDate, params.EndDate)
+	}
+
+	if err := query.Scan(&byTag).Error; err != nil {
+		return nil, err
+	}
+
+	return byTag, nil
+}
+
+// GetTagsByPeriod sums the transactions of every tag per day, week or month
+func (r *repository) GetTagsByPeriod(userID uint, txnType transaction.TransactionType, interval string, start, end time.Time, tags []uint) ([]tagAmount, error) {
+	var amounts []tagAmount
+
+	query := r.tagTransactions(userID, txnType).
+		Select(`tags.id AS tag_id, tags.name, tags.color,
+			date_trunc(?, transactions.date AT TIME ZONE 'UTC') AS period, SUM(transactions.amount) AS amount`, interval).
+		Where("transactions.date BETWEEN ? AND ?", start, end).
+		Group("tags.id, tags.name, tags.color, period").
+		Order("tags.name ASC, period ASC")
+
+	if len(tags) > 0 {
+		query = query.Where("tags.id IN (?)", tags)
+	}
+
+	if err := query.Scan(&amounts).Error; err != nil {
+		return nil, err
+	}
+
+	return amounts, nil
+}
+
+func (r *repository) GetTagCategoryReport(userID uint, txnType transaction.TransactionType, params *RangeDateParams) ([]TagCategoryValue, error) {
+	var cells []TagCategoryValue
+
+	query := r.tagTransactions(userID, txnType).
+		Joins("JOIN categories ON categories.id = transactions.category_id").
+		Select(`tags.id AS tag_id, tags.name AS tag, categories.id AS category_id, categories.name AS category,
+			COUNT(*) AS count, SUM(transactions.amount) AS amount`).
+		Group("tags.id, tags.name, categories.id, categories.name").
+		Order("tags.name ASC, categories.name ASC")
+
+	if params.StartDate != nil && params.EndDate != nil {
+		query = query.Where("transactions.date BETWEEN ? AND ?", params.StartDate, params.EndDate)
+	}
+
+	if err := query.Scan(&cells).Error; err != nil {
+		return nil, err
+	}
+
+	return cells, nil
+}
@@ -1,13 +1,19 @@
 package analytics
 
 import (
+	"fmt"
+	"io"
 	"time"
 
+	"github.com/emPeeGee/raffinance/internal/investment"
 	"github.com/emPeeGee/raffinance/internal/transaction"
 	"github.com/emPeeGee/raffinance/pkg/log"
 	"github.com/emPeeGee/raffinance/pkg/util"
 )
 
+// maxReportPeriods limits the size of the time series reports
+const maxReportPeriods = 1000
+
 type Service interface {
 	GetCashFlowReport(userID uint, params *RangeDateParams) (*Report, error)
 	GetBalanceEvolution(userID uint, params *BalanceEvolutionParams) (*Report, error)
@@ -15,15 +21,41 @@ type Service interface {
 	GetCategoriesSpending(userID uint, params *RangeDateParams) (*Report, error)
 	GetCategoriesIncome(userID uint, params *RangeDateParams) (*Report, error)
 	GetTransactionsCountByDay(userID uint, params *YearlyTransactionsParams) (*Report, error)
+	GetRealizedGains(userID uint, params *RealizedGainsParams) (*Report, error)
+	GetNetWorth(userID uint, params *NetWorthParams) (*Report, error)
+	GetForecast(userID uint, params *ForecastParams) (*Report, error)
+	GetComparison(userID uint, params *ComparisonParams) (*Report, error)
+	GetExpenseHeatmap(userID uint, params *HeatmapParams) (*Report, error)
+	GetTagsSpending(userID uint, params *RangeDateParams) (*Report, error)
+	GetTagsIncome(userID uint, params *RangeDateParams) (*Report, error)
+	GetTagSeries(userID uint, params *TagSeriesParams) (*Report, error)
+	GetTagCategories(userID uint, params *TagCrossParams) (*Report, error)
+	WriteRealizedGainsCSV(userID uint, params *RealizedGainsParams, w io.Writer) error
+}
+
+// values of the type param of the reports
+const (
+	typeIncome  = "income"
+	typeExpense = "expense"
+)
+
+// transactionTypeOf maps the type param of the reports, expense is the default
+func transactionTypeOf(name string) transaction.TransactionType {
+	if name == typeIncome {
+		return transaction.INCOME
+	}
+
+	return transaction.EXPENSE
 }
 
 type service struct {
-	repo   Repository
-	logger log.Logger
+	repo              Repository
+	investmentService investment.Service
+	logger            log.Logger
 }
 
-func NewAnalyticsService(repo Repository, logger log.Logger) *service {
-	return &service{repo: repo, logger: logger}
+func NewAnalyticsService(repo Repository, investmentService investment.Service, logger log.Logger) *service {
+	return &service{repo: repo, investmentService: investmentService, logger: logger}
 }
 
 func (s *service) GetCashFlowReport(userID uint, params *RangeDateParams) (*Report, error) {
@@ -128,3 +160,248 @@ func (s *service) GetTransactionsCountByDay(userID uint, params *YearlyTransacti
 		Data:  data,
 	}, nil
 }
+
+func (s *service) GetRealizedGains(userID uint, params *RealizedGainsParams) (*Report, error) {
+	sales, err := s.getLotSales(userID, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Report{
+		Title: "Realized gains",
+		Data:  buildRealizedGainsReport(sales),
+	}, nil
+}
+
+func (s *service) WriteRealizedGainsCSV(userID uint, params *RealizedGainsParams, w io.Writer) error {
+	sales, err := s.getLotSales(userID, params)
+	if err != nil {
+		return err
+	}
+
+	return writeRealizedGainsCSV(w, sales)
+}
+
+func (s *service) getLotSales(userID uint, params *RealizedGainsParams) ([]investment.LotSale, error) {
+	if params.EndDate != nil && params.StartDate != nil {
+		params.EndDate = util.EndOfTheDay(*params.EndDate)
+	}
+
+	sales, err := s.investmentService.GetLotSales(userID, params.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	return filterLotSales(sales, params), nil
+}
+
+func (s *service) GetNetWorth(userID uint, params *NetWorthParams) (*Report, error) {
+	if params.Interval == "" {
+		params.Interval = intervalMonth
+	}
+
+	// Without a range, the last year is reported
+	end := time.Now().UTC()
+	start := end.AddDate(-1, 0, 0)
+	if params.StartDate != nil && params.EndDate != nil {
+		start = params.StartDate.UTC()
+		end = util.EndOfTheDay(params.EndDate.UTC()).UTC()
+	}
+
+	periods := periodsBetween(start, end, params.Interval)
+	if len(periods) > maxReportPeriods {
+		return nil, fmt.Errorf("the range contains %d periods, at most %d are allowed, use a larger interval", len(periods), maxReportPeriods)
+	}
+
+	accounts, err := s.repo.GetAccounts(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Movements are counted from the start of the first period, so the first balance is complete
+	opening, err := s.repo.GetAccountBalancesBefore(userID, periods[0])
+	if err != nil {
+		return nil, err
+	}
+
+	movements, err := s.repo.GetAccountMovementsByPeriod(userID, params.Interval, periods[0], end)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Report{
+		Title: "Net worth",
+		Data:  buildNetWorthReport(accounts, opening, movements, periods, params.Interval),
+	}, nil
+}
+
+func (s *service) GetForecast(userID uint, params *ForecastParams) (*Report, error) {
+	if params.Days == 0 {
+		params.Days = forecastDefaultDays
+	}
+
+	accounts, err := s.repo.GetAccounts(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.AccountID != nil {
+		var selected []accountInfo
+		for _, account := range accounts {
+			if account.ID == *params.AccountID {
+				selected = append(selected, account)
+			}
+		}
+
+		if len(selected) == 0 {
+			return nil, fmt.Errorf("account %d not found", *params.AccountID)
+		}
+
+		accounts = selected
+	}
+
+	// The current balance leaves out the future-dated transactions, they are replayed on their date
+	now := time.Now().UTC()
+	balances, err := s.repo.GetAccountBalancesBefore(userID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	legs, err := s.repo.GetAccountLegsSince(userID, params.AccountID, now.AddDate(0, 0, -patternLookbackDays))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Report{
+		Title: "Forecast",
+		Data:  buildForecastReport(accounts, balances, legs, now, params.Days, params.Threshold),
+	}, nil
+}
+
+func (s *service) GetComparison(userID uint, params *ComparisonParams) (*Report, error) {
+	if params.Baseline == "" {
+		params.Baseline = baselinePreviousMonth
+	}
+
+	if params.GroupBy == "" {
+		params.GroupBy = comparisonByCategory
+	}
+
+	if params.Type == "" {
+		params.Type = typeExpense
+	}
+
+	month := time.Now().UTC()
+	if params.Month != nil && !params.Month.IsZero() {
+		month = params.Month.UTC()
+	}
+
+	ranges := newComparisonRange(month, params.Baseline)
+
+	amounts, err := s.repo.GetComparison(userID, params.GroupBy, transactionTypeOf(params.Type), ranges)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Report{
+		Title: "Comparison",
+		Data:  buildComparisonReport(amounts, ranges, params.Baseline, params.GroupBy, params.Type),
+	}, nil
+}
+
+func (s *service) GetExpenseHeatmap(userID uint, params *HeatmapParams) (*Report, error) {
+	if params.Timezone == "" {
+		params.Timezone = "UTC"
+	}
+
+	if params.EndDate != nil && params.StartDate != nil {
+		params.EndDate = util.EndOfTheDay(*params.EndDate)
+	}
+
+	cells, err := s.repo.GetExpenseHeatmap(userID, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Report{
+		Title: "Expense heatmap",
+		Data:  buildHeatmapReport(cells, params.Timezone),
+	}, nil
+}
+
+func (s *service) GetTagsSpending(userID uint, params *RangeDateParams) (*Report, error) {
+	if params.EndDate != nil && params.StartDate != nil {
+		params.EndDate = util.EndOfTheDay(*params.EndDate)
+	}
+
+	data, err := s.repo.GetTagsReport(userID, transaction.EXPENSE, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Report{
+		Title: "Tags Spending",
+		Data:  data,
+	}, nil
+}
+
+func (s *service) GetTagsIncome(userID uint, params *RangeDateParams) (*Report, error) {
+	if params.EndDate != nil && params.StartDate != nil {
+		params.EndDate = util.EndOfTheDay(*params.EndDate)
+	}
+
+	data, err := s.repo.GetTagsReport(userID, transaction.INCOME, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Report{
+		Title: "Tags Income",
+		Data:  data,
+	}, nil
+}
+
+func (s *service) GetTagSeries(userID uint, params *TagSeriesParams) (*Report, error) {
+	if params.Interval == "" {
+		params.Interval = intervalMonth
+	}
+
+	// Without a range, the last year is reported
+	end := time.Now().UTC()
+	start := end.AddDate(-1, 0, 0)
+	if params.StartDate != nil && params.EndDate != nil {
+		start = params.StartDate.UTC()
+		end = util.EndOfTheDay(params.EndDate.UTC()).UTC()
+	}
+
+	periods := periodsBetween(start, end, params.Interval)
+	if len(periods) > maxReportPeriods {
+		return nil, fmt.Errorf("the range contains %d periods, at most %d are allowed, use a larger interval", len(periods), maxReportPeriods)
+	}
+
+	amounts, err := s.repo.GetTagsByPeriod(userID, transactionTypeOf(params.Type), params.Interval, periods[0], end, params.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Report{
+		Title: "Tags evolution",
+		Data:  buildTagSeries(amounts, periods),
+	}, nil
+}
+
+func (s *service) GetTagCategories(userID uint, params *TagCrossParams) (*Report, error) {
+	if params.EndDate != nil && params.StartDate != nil {
+		params.EndDate = util.EndOfTheDay(*params.EndDate)
+	}
+
+	data, err := s.repo.GetTagCategoryReport(userID, transactionTypeOf(params.Type), &params.RangeDateParams)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Report{
+		Title: "Tags by category",
+		Data:  data,
+	}, nil
+}
@@ -0,0 +1,32 @@
+package analytics
+
+import "time"
+
+// buildTagSeries fills every period of every tag, the periods without transactions count as zero
+func buildTagSeries(amounts []tagAmount, periods []time.Time) []TagSeries {
+	series := make([]TagSeries, 0)
+	index := make(map[uint]int)
+	byPeriod := make(map[uint]map[int64]float64)
+
+	for _, amount := range amounts {
+		i, ok := index[amount.TagID]
+		if !ok {
+			i = len(series)
+			index[amount.TagID] = i
+			series = append(series, TagSeries{ID: amount.TagID, Name: amount.Name, Color: amount.Color})
+			byPeriod[amount.TagID] = make(map[int64]float64)
+		}
+
+		series[i].Total += amount.Amount
+		byPeriod[amount.TagID][amount.Period.Unix()] += amount.Amount
+	}
+
+	for i := range series {
+		series[i].Points = make([]DateValue, 0, len(periods))
+		for _, period := range periods {
+			series[i].Points = append(series[i].Points, DateValue{Date: period, Value: byPeriod[series[i].ID][period.Unix()]})
+		}
+	}
+
+	return series
+}
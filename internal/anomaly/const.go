@@ -0,0 +1,28 @@
+package anomaly
+
+type Kind string
+
+const (
+	// LARGE_TRANSACTION is a transaction much larger than the usual amount of its category
+	LARGE_TRANSACTION Kind = "LARGE_TRANSACTION"
+	// CATEGORY_SPIKE is a month where the spend of a category is far above its trailing months
+	CATEGORY_SPIKE Kind = "CATEGORY_SPIKE"
+	// NEW_MERCHANT is a big transaction with a description never used before
+	NEW_MERCHANT Kind = "NEW_MERCHANT"
+	// NEW_LOCATION is a big transaction at a location never used before
+	NEW_LOCATION Kind = "NEW_LOCATION"
+)
+
+const (
+	// scoreThreshold is the robust z-score above which an amount is unusual
+	scoreThreshold = 3.5
+	// newPlaceThreshold is lower, a new merchant or location is already unusual by itself
+	newPlaceThreshold = 2.5
+	// minSamples is the history needed before the amounts of a category are judged
+	minSamples = 5
+	// minMonths is the number of trailing months needed to judge a monthly spend
+	minMonths = 3
+
+	historyDays    = 365
+	trailingMonths = 6
+)
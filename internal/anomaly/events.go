@@ -0,0 +1,9 @@
+package anomaly
+
+type AnomaliesDetected struct {
+	UserID    uint              `json:"-"`
+	Anomalies []AnomalyResponse `json:"anomalies"`
+}
+
+func (e AnomaliesDetected) EventName() string { return "anomalies.detected" }
+func (e AnomaliesDetected) EventUserID() uint { return e.UserID }
@@ -0,0 +1,73 @@
+package anomaly
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/emPeeGee/raffinance/internal/auth"
+	"github.com/emPeeGee/raffinance/pkg/errorutil"
+	"github.com/emPeeGee/raffinance/pkg/log"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator"
+)
+
+func RegisterHandlers(apiRg *gin.RouterGroup, service Service, validate *validator.Validate, logger log.Logger) {
+	h := handler{service, logger, validate}
+
+	api := apiRg.Group("/anomalies")
+	{
+		api.GET("", h.getAnomalies)
+		api.PUT("/:id/dismiss", h.dismissAnomaly)
+	}
+}
+
+type handler struct {
+	service  Service
+	logger   log.Logger
+	validate *validator.Validate
+}
+
+func (h *handler) getAnomalies(c *gin.Context) {
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	var params anomaliesParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	anomalies, err := h.service.getAnomalies(*userId, params)
+	if err != nil {
+		errorutil.InternalServer(c, "something went wrong, we are working", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, anomalies)
+}
+
+func (h *handler) dismissAnomaly(c *gin.Context) {
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	anomalyId, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		errorutil.BadRequest(c, err.Error(), "the id must be an integer")
+		return
+	}
+
+	if err := h.service.dismissAnomaly(*userId, uint(anomalyId)); err != nil {
+		errorutil.NotFound(c, err.Error(), "Not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"ok": true,
+	})
+}
@@ -0,0 +1,27 @@
+package anomaly
+
+import "time"
+
+type AnomalyResponse struct {
+	ID            uint       `json:"id"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	TransactionID *uint      `json:"transactionId,omitempty"`
+	CategoryID    *uint      `json:"categoryId,omitempty"`
+	Kind          Kind       `json:"kind"`
+	Reason        string     `json:"reason"`
+	Period        *time.Time `json:"period,omitempty"`
+	Amount        float64    `json:"amount"`
+	Expected      float64    `json:"expected"`
+	Score         float64    `json:"score"`
+	Dismissed     bool       `json:"dismissed"`
+}
+
+type anomaliesParams struct {
+	Dismissed bool `form:"dismissed"`
+}
+
+// monthAmount is the spend of a category in a month
+type monthAmount struct {
+	Period time.Time
+	Amount float64
+}
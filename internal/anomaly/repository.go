@@ -0,0 +1,202 @@
+package anomaly
+
+import (
+	"errors"
+	"time"
+
+	"github.com/emPeeGee/raffinance/internal/entity"
+	"github.com/emPeeGee/raffinance/internal/transaction"
+	"github.com/emPeeGee/raffinance/pkg/log"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Repository interface {
+	getAnomalies(userID uint, dismissed bool) ([]AnomalyResponse, error)
+	dismissAnomaly(userID, id uint) error
+	createAnomalies(anomalies []entity.Anomaly) ([]entity.Anomaly, error)
+	anomalyExists(userID uint, kind Kind, transactionID, categoryID *uint, period *time.Time) (bool, error)
+
+	getCategoryExpenses(userID, categoryID, excludeID uint, since time.Time) ([]float64, error)
+	getExpenses(userID, excludeID uint, since time.Time) ([]float64, error)
+	getCategoryMonths(userID, categoryID uint, start, end time.Time) ([]monthAmount, error)
+	descriptionUsed(userID, excludeID uint, description string) (bool, error)
+	locationUsed(userID, excludeID uint, location string) (bool, error)
+}
+
+type repository struct {
+	db     *gorm.DB
+	logger log.Logger
+}
+
+func NewAnomalyRepository(db *gorm.DB, logger log.Logger) *repository {
+	return &repository{db: db, logger: logger}
+}
+
+func (r *repository) getAnomalies(userID uint, dismissed bool) ([]AnomalyResponse, error) {
+	var anomalies = make([]AnomalyResponse, 0)
+
+	query := r.db.Model(&entity.Anomaly{}).
+		Where("user_id = ?", userID).
+		Order("created_at DESC")
+
+	if !dismissed {
+		query = query.Where("dismissed = false")
+	}
+
+	if err := query.Find(&anomalies).Error; err != nil {
+		return nil, err
+	}
+
+	return anomalies, nil
+}
+
+func (r *repository) dismissAnomaly(userID, id uint) error {
+	result := r.db.Model(&entity.Anomaly{}).
+		Where("id = ? AND user_id = ?", id, userID).
+		Update("dismissed", true)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("anomaly not found")
+	}
+
+	return nil
+}
+
+// createAnomalies returns the anomalies created. A category spike already saved for the month, by a
+// transaction inspected concurrently, is skipped by the unique index
+func (r *repository) createAnomalies(anomalies []entity.Anomaly) ([]entity.Anomaly, error) {
+	created := make([]entity.Anomaly, 0, len(anomalies))
+
+	for i := range anomalies {
+		anomaly := anomalies[i]
+
+		// one by one, the ids returned by a batch are not matched to the rows when some are skipped
+		result := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&anomaly)
+		if result.Error != nil {
+			return created, result.Error
+		}
+
+		if result.RowsAffected > 0 {
+			created = append(created, anomaly)
+		}
+	}
+
+	return created, nil
+}
+
+func (r *repository) anomalyExists(userID uint, kind Kind, transactionID, categoryID *uint, period *time.Time) (bool, error) {
+	var count int64
+
+	query := r.db.Model(&entity.Anomaly{}).Where("user_id = ? AND kind = ?", userID, kind)
+
+	if transactionID != nil {
+		query = query.Where("transaction_id = ?", transactionID)
+	}
+
+	if categoryID != nil {
+		query = query.Where("category_id = ?", categoryID)
+	}
+
+	if period != nil {
+		query = query.Where("period = ?", period)
+	}
+
+	if err := query.Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// expenses selects the expenses of the user, the transaction being inspected left out
+func (r *repository) expenses(userID, excludeID uint) *gorm.DB {
+	return r.db.Table("transactions").
+		Joins("JOIN accounts ON accounts.id = transactions.to_account_id").
+		Where("accounts.user_id = ? AND transactions.deleted_at IS NULL", userID).
+		Where("transactions.transaction_type_id = ? AND transactions.id <> ?", transaction.EXPENSE, excludeID)
+}
+
+func (r *repository) getCategoryExpenses(userID, categoryID, excludeID uint, since time.Time) ([]float64, error) {
+	var amounts []float64
+
+	if err := r.expenses(userID, excludeID).
+		Where("transactions.category_id = ? AND transactions.date >= ?", categoryID, since).
+		Pluck("transactions.amount", &amounts).Error; err != nil {
+		return nil, err
+	}
+
+	return amounts, nil
+}
+
+func (r *repository) getExpenses(userID, excludeID uint, since time.Time) ([]float64, error) {
+	var amounts []float64
+
+	if err := r.expenses(userID, excludeID).
+		Where("transactions.date >= ?", since).
+		Pluck("transactions.amount", &amounts).Error; err != nil {
+		return nil, err
+	}
+
+	return amounts, nil
+}
+
+// getCategoryMonths sums the expenses of the category per month, the months without any are left out
+func (r *repository) getCategoryMonths(userID, categoryID uint, start, end time.Time) ([]monthAmount, error) {
+	var months []monthAmount
+
+	if err := r.expenses(userID, 0).
+		Select("date_trunc('month', transactions.date AT TIME ZONE 'UTC') AS period, SUM(transactions.amount) AS amount").
+		Where("transactions.category_id = ? AND transactions.date >= ? AND transactions.date < ?", categoryID, start, end).
+		Group("period").
+		Order("period ASC").
+		Scan(&months).Error; err != nil {
+		return nil, err
+	}
+
+	return months, nil
+}
+
+func (r *repository) descriptionUsed(userID, excludeID uint, description string) (bool, error) {
+	var count int64
+
+	if err := r.expenses(userID, excludeID).
+		Where("LOWER(TRIM(transactions.description)) = LOWER(TRIM(?))", description).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (r *repository) locationUsed(userID, excludeID uint, location string) (bool, error) {
+	var count int64
+
+	if err := r.expenses(userID, excludeID).
+		Where("LOWER(TRIM(transactions.location)) = LOWER(TRIM(?))", location).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func EntityToResponse(a *entity.Anomaly) AnomalyResponse {
+	return AnomalyResponse{
+		ID:            a.ID,
+		CreatedAt:     a.CreatedAt,
+		TransactionID: a.TransactionID,
+		CategoryID:    a.CategoryID,
+		Kind:          Kind(a.Kind),
+		Reason:        a.Reason,
+		Period:        a.Period,
+		Amount:        a.Amount,
+		Expected:      a.Expected,
+		Score:         a.Score,
+		Dismissed:     a.Dismissed,
+	}
+}
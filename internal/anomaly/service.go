@@ -0,0 +1,247 @@
+package anomaly
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/emPeeGee/raffinance/internal/entity"
+	"github.com/emPeeGee/raffinance/internal/event"
+	"github.com/emPeeGee/raffinance/internal/transaction"
+	"github.com/emPeeGee/raffinance/pkg/log"
+)
+
+type Service interface {
+	getAnomalies(userID uint, params anomaliesParams) ([]AnomalyResponse, error)
+	dismissAnomaly(userID, id uint) error
+	// Inspect looks for unusual spending around a new transaction. It stores the findings and publishes
+	// them as an AnomaliesDetected event
+	Inspect(userID uint, txn transaction.TransactionResponse)
+}
+
+type service struct {
+	repo   Repository
+	logger log.Logger
+	events event.Publisher
+}
+
+func NewAnomalyService(repo Repository, logger log.Logger, events event.Publisher) *service {
+	return &service{repo: repo, logger: logger, events: events}
+}
+
+func (s *service) getAnomalies(userID uint, params anomaliesParams) ([]AnomalyResponse, error) {
+	return s.repo.getAnomalies(userID, params.Dismissed)
+}
+
+func (s *service) dismissAnomaly(userID, id uint) error {
+	return s.repo.dismissAnomaly(userID, id)
+}
+
+func (s *service) Inspect(userID uint, txn transaction.TransactionResponse) {
+	if txn.TransactionTypeID != byte(transaction.EXPENSE) {
+		return
+	}
+
+	anomalies, err := s.detect(userID, txn)
+	if err != nil {
+		s.logger.Errorf("anomaly detection of transaction %d failed: %s", txn.ID, err.Error())
+		return
+	}
+
+	if len(anomalies) == 0 {
+		return
+	}
+
+	anomalies, err = s.repo.createAnomalies(anomalies)
+	if err != nil {
+		s.logger.Errorf("anomalies of transaction %d could not be saved: %s", txn.ID, err.Error())
+	}
+
+	if len(anomalies) == 0 {
+		return
+	}
+
+	detected := AnomaliesDetected{UserID: userID, Anomalies: make([]AnomalyResponse, 0, len(anomalies))}
+	for i := range anomalies {
+		detected.Anomalies = append(detected.Anomalies, EntityToResponse(&anomalies[i]))
+	}
+
+	if err := s.events.Publish(detected); err != nil {
+		s.logger.Errorf("anomalies of transaction %d could not be published: %s", txn.ID, err.Error())
+	}
+}
+
+func (s *service) detect(userID uint, txn transaction.TransactionResponse) ([]entity.Anomaly, error) {
+	var anomalies []entity.Anomaly
+
+	large, err := s.detectLargeTransaction(userID, txn)
+	if err != nil {
+		return nil, err
+	}
+
+	newPlaces, err := s.detectNewPlaces(userID, txn)
+	if err != nil {
+		return nil, err
+	}
+
+	spike, err := s.detectCategorySpike(userID, txn)
+	if err != nil {
+		return nil, err
+	}
+
+	anomalies = append(anomalies, large...)
+	anomalies = append(anomalies, newPlaces...)
+	return append(anomalies, spike...), nil
+}
+
+// detectLargeTransaction compares the amount with the expenses of the same category over the last year
+func (s *service) detectLargeTransaction(userID uint, txn transaction.TransactionResponse) ([]entity.Anomaly, error) {
+	amounts, err := s.repo.getCategoryExpenses(userID, txn.Category.ID, txn.ID, txn.Date.AddDate(0, 0, -historyDays))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(amounts) < minSamples {
+		return nil, nil
+	}
+
+	score, usual, ok := robustScore(txn.Amount, amounts)
+	if !ok || score < scoreThreshold {
+		return nil, nil
+	}
+
+	return []entity.Anomaly{{
+		UserID:        userID,
+		TransactionID: &txn.ID,
+		CategoryID:    &txn.Category.ID,
+		Kind:          string(LARGE_TRANSACTION),
+		Reason:        fmt.Sprintf("%.2f is much more than the usual %.2f spent on %s", txn.Amount, usual, txn.Category.Name),
+		Amount:        txn.Amount,
+		Expected:      usual,
+		Score:         score,
+	}}, nil
+}
+
+// detectNewPlaces flags a big expense with a description or a location never used before
+func (s *service) detectNewPlaces(userID uint, txn transaction.TransactionResponse) ([]entity.Anomaly, error) {
+	description := strings.TrimSpace(txn.Description)
+	location := strings.TrimSpace(txn.Location)
+	if description == "" && location == "" {
+		return nil, nil
+	}
+
+	amounts, err := s.repo.getExpenses(userID, txn.ID, txn.Date.AddDate(0, 0, -historyDays))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(amounts) < minSamples {
+		return nil, nil
+	}
+
+	score, usual, ok := robustScore(txn.Amount, amounts)
+	if !ok || score < newPlaceThreshold {
+		return nil, nil
+	}
+
+	var anomalies []entity.Anomaly
+
+	if description != "" {
+		used, err := s.repo.descriptionUsed(userID, txn.ID, description)
+		if err != nil {
+			return nil, err
+		}
+
+		if !used {
+			anomalies = append(anomalies, entity.Anomaly{
+				UserID:        userID,
+				TransactionID: &txn.ID,
+				CategoryID:    &txn.Category.ID,
+				Kind:          string(NEW_MERCHANT),
+				Reason:        fmt.Sprintf("first expense at %s is %.2f, the usual expense is %.2f", description, txn.Amount, usual),
+				Amount:        txn.Amount,
+				Expected:      usual,
+				Score:         score,
+			})
+		}
+	}
+
+	if location != "" {
+		used, err := s.repo.locationUsed(userID, txn.ID, location)
+		if err != nil {
+			return nil, err
+		}
+
+		if !used {
+			anomalies = append(anomalies, entity.Anomaly{
+				UserID:        userID,
+				TransactionID: &txn.ID,
+				CategoryID:    &txn.Category.ID,
+				Kind:          string(NEW_LOCATION),
+				Reason:        fmt.Sprintf("first expense in %s is %.2f, the usual expense is %.2f", location, txn.Amount, usual),
+				Amount:        txn.Amount,
+				Expected:      usual,
+				Score:         score,
+			})
+		}
+	}
+
+	return anomalies, nil
+}
+
+// detectCategorySpike compares the spend of the category in the month of the transaction with the
+// trailing months. A category spike is reported once per month
+func (s *service) detectCategorySpike(userID uint, txn transaction.TransactionResponse) ([]entity.Anomaly, error) {
+	date := txn.Date.UTC()
+	month := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	months, err := s.repo.getCategoryMonths(userID, txn.Category.ID, month.AddDate(0, -trailingMonths, 0), month.AddDate(0, 1, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	// The months without any expense count as zero
+	byMonth := make(map[int64]float64, len(months))
+	for _, m := range months {
+		byMonth[m.Period.Unix()] = m.Amount
+	}
+
+	trailing := make([]float64, 0, trailingMonths)
+	active := 0
+	for i := trailingMonths; i >= 1; i-- {
+		amount := byMonth[month.AddDate(0, -i, 0).Unix()]
+		if amount > 0 {
+			active++
+		}
+
+		trailing = append(trailing, amount)
+	}
+
+	if active < minMonths {
+		return nil, nil
+	}
+
+	current := byMonth[month.Unix()]
+	score, usual, ok := robustScore(current, trailing)
+	if !ok || score < scoreThreshold {
+		return nil, nil
+	}
+
+	exists, err := s.repo.anomalyExists(userID, CATEGORY_SPIKE, nil, &txn.Category.ID, &month)
+	if err != nil || exists {
+		return nil, err
+	}
+
+	return []entity.Anomaly{{
+		UserID:        userID,
+		TransactionID: &txn.ID,
+		CategoryID:    &txn.Category.ID,
+		Kind:          string(CATEGORY_SPIKE),
+		Reason: fmt.Sprintf("%.2f spent on %s in %s, the usual month is %.2f",
+			current, txn.Category.Name, month.Format("January 2006"), usual),
+		Period:   &month,
+		Amount:   current,
+		Expected: usual,
+		Score:    score,
+	}}, nil
+}
@@ -0,0 +1,55 @@
+package anomaly
+
+import (
+	"math"
+	"sort"
+)
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+
+	return sorted[middle]
+}
+
+// medianAbsoluteDeviation is the median distance of the values to their median
+func medianAbsoluteDeviation(values []float64, center float64) float64 {
+	deviations := make([]float64, len(values))
+	for i, value := range values {
+		deviations[i] = math.Abs(value - center)
+	}
+
+	return median(deviations)
+}
+
+// robustScore tells how far the value is from the samples, like a z-score but based on the median and
+// the median absolute deviation, so a few outliers in the history don't hide new ones (Iglewicz and Hoaglin).
+// When more than half of the samples are equal the MAD is zero and the mean absolute deviation is used
+// instead. It returns false when the samples don't vary at all
+func robustScore(value float64, samples []float64) (score float64, center float64, ok bool) {
+	if len(samples) == 0 {
+		return 0, 0, false
+	}
+
+	center = median(samples)
+
+	if mad := medianAbsoluteDeviation(samples, center); mad > 0 {
+		return 0.6745 * (value - center) / mad, center, true
+	}
+
+	var sum float64
+	for _, sample := range samples {
+		sum += math.Abs(sample - center)
+	}
+
+	if meanDeviation := sum / float64(len(samples)); meanDeviation > 0 {
+		return (value - center) / (1.253314 * meanDeviation), center, true
+	}
+
+	return 0, center, false
+}
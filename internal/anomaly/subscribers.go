@@ -0,0 +1,14 @@
+package anomaly
+
+import (
+	"github.com/emPeeGee/raffinance/internal/event"
+	"github.com/emPeeGee/raffinance/internal/transaction"
+)
+
+func RegisterSubscribers(bus *event.Bus, service Service) {
+	// Async, the detection queries the history and the response doesn't wait for it
+	event.On(bus, event.Async, func(e transaction.TransactionCreated) error {
+		service.Inspect(e.UserID, e.Transaction)
+		return nil
+	})
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/emPeeGee/raffinance/internal/entity"
+)
+
+const (
+	// accessTokenPrefix tells the personal access tokens from the JWTs, and lets secret scanners spot them
+	accessTokenPrefix = "raf_"
+	// accessTokenShown is how much of the token the list shows, to recognize it
+	accessTokenShown = len(accessTokenPrefix) + 8
+)
+
+// createAccessToken returns the token with its value, shown only this time
+func (s *service) createAccessToken(userID uint, input createAccessTokenDTO) (*createdAccessTokenResponse, error) {
+	random, _, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	value := accessTokenPrefix + random
+	token := entity.PersonalAccessToken{
+		UserID: userID,
+		Name:   input.Name,
+		Prefix: value[:accessTokenShown],
+		Hash:   hashToken(value),
+		Scopes: input.Scopes,
+	}
+
+	if input.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, input.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err := s.repo.createAccessToken(&token); err != nil {
+		return nil, err
+	}
+
+	return &createdAccessTokenResponse{accessTokenResponse: toAccessTokenResponse(token), Token: value}, nil
+}
+
+func (s *service) getAccessTokens(userID uint) ([]accessTokenResponse, error) {
+	tokens, err := s.repo.getAccessTokens(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]accessTokenResponse, len(tokens))
+	for i, token := range tokens {
+		responses[i] = toAccessTokenResponse(token)
+	}
+
+	return responses, nil
+}
+
+func (s *service) revokeAccessToken(userID, id uint) error {
+	ok, err := s.repo.revokeAccessToken(userID, id, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("access token with ID %d does not exist or belong to user with ID %d", id, userID)
+	}
+
+	return nil
+}
+
+func (s *service) authenticateAccessToken(token string) (*accessTokenStatus, error) {
+	return s.repo.authenticateAccessToken(hashToken(token), time.Now())
+}
+
+func toAccessTokenResponse(token entity.PersonalAccessToken) accessTokenResponse {
+	return accessTokenResponse{
+		ID:         token.ID,
+		Name:       token.Name,
+		Prefix:     token.Prefix,
+		Scopes:     token.Scopes,
+		CreatedAt:  token.CreatedAt,
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+	}
+}
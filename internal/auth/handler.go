@@ -1,33 +1,75 @@
 package auth
 
 import (
+	"errors"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/emPeeGee/raffinance/pkg/errorutil"
 	"github.com/emPeeGee/raffinance/pkg/log"
+	"github.com/emPeeGee/raffinance/pkg/ratelimit"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator"
 )
 
-func RegisterHandlers(authRg, apiRg *gin.RouterGroup, service Service, validate *validator.Validate, logger log.Logger) {
-	h := handler{service, logger, validate}
+func RegisterHandlers(
+	authRg, apiRg, wellKnownRg *gin.RouterGroup,
+	service Service,
+	clientURL string,
+	validate *validator.Validate,
+	logger log.Logger,
+) {
+	h := handler{service, logger, validate, clientURL}
+
+	// by client IP, the emails are limited by address in the service as well
+	forgotLimiter := ratelimit.New(5, 15*time.Minute)
+	resetLimiter := ratelimit.New(10, 15*time.Minute)
+	resendLimiter := ratelimit.New(5, 15*time.Minute)
+	mfaLimiter := ratelimit.New(10, 15*time.Minute)
+	oidcLimiter := ratelimit.New(20, 15*time.Minute)
 
 	auth := authRg.Group("")
 	{
 		auth.POST("/signUp", h.signUp)
 		auth.POST("/signIn", h.signIn)
+		auth.POST("/signIn/mfa", ratelimit.Handler(mfaLimiter), h.signInMFA)
+		auth.POST("/refresh", h.refresh)
+		auth.POST("/forgotPassword", ratelimit.Handler(forgotLimiter), h.forgotPassword)
+		auth.POST("/resetPassword", ratelimit.Handler(resetLimiter), h.resetPassword)
+		auth.POST("/verifyEmail", h.verifyEmail)
+		auth.POST("/resendVerification", ratelimit.Handler(resendLimiter), h.resendVerification)
+		auth.GET("/oidc/providers", h.getOIDCProviders)
+		auth.GET("/oidc/:provider/login", ratelimit.Handler(oidcLimiter), h.oidcLogin)
+		auth.GET("/oidc/:provider/callback", h.oidcCallback)
+		auth.POST("/oidc/exchange", ratelimit.Handler(oidcLimiter), h.oidcExchange)
 	}
 
-	api := apiRg.Group("")
+	// a personal access token can't manage the account, nor create other tokens
+	api := apiRg.Group("", RequireSession())
 	{
 		api.GET("/user", h.getUser)
+		api.PUT("/user/email", h.changeEmail)
+		api.POST("/logout", h.logout)
+		api.POST("/logoutAll", h.logoutAll)
+		api.POST("/mfa/totp", h.enrollTOTP)
+		api.POST("/mfa/totp/confirm", h.confirmTOTP)
+		api.POST("/mfa/totp/disable", h.disableTOTP)
+		api.GET("/accessTokens", h.getAccessTokens)
+		api.POST("/accessTokens", h.createAccessToken)
+		api.DELETE("/accessTokens/:id", h.revokeAccessToken)
 	}
+
+	wellKnownRg.GET("/jwks.json", h.getJWKS)
 }
 
 type handler struct {
 	service  Service
 	logger   log.Logger
 	validate *validator.Validate
+	// clientURL is the page of the client the sign in with a provider ends on
+	clientURL string
 }
 
 func (h *handler) signUp(c *gin.Context) {
@@ -69,19 +111,562 @@ func (h *handler) signIn(c *gin.Context) {
 		return
 	}
 
-	token, err := h.service.generateToken(credentials)
+	token, challenge, err := h.service.generateToken(credentials)
+	if err != nil {
+		if errors.Is(err, errEmailNotVerified) {
+			errorutil.Forbidden(c, err.Error(), "verify your email first")
+			return
+		}
+
+		errorutil.InternalServer(c, "something went wrong, we are working", err.Error())
+		return
+	}
+
+	// The second factor is asked with the challenge, by signInMFA
+	if challenge != nil {
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+
+	h.logger.Debug(token.Token)
+
+	c.JSON(http.StatusOK, token)
+}
+
+func (h *handler) signInMFA(c *gin.Context) {
+	var input mfaDTO
+
+	if err := c.BindJSON(&input); err != nil {
+		errorutil.BadRequest(c, "incorrect body", err.Error())
+		return
+	}
+
+	if err := h.validate.Struct(input); err != nil {
+		errorutil.BadRequest(c, "incorrect body", err.Error())
+		return
+	}
+
+	token, err := h.service.verifyMFA(input.MFAToken, input.Code)
+	if err != nil {
+		var locked *mfaLockedError
+		if errors.As(err, &locked) {
+			mfaLocked(c, locked)
+			return
+		}
+
+		if errors.Is(err, errInvalidMFAChallenge) || errors.Is(err, errInvalidMFACode) {
+			errorutil.Unauthorized(c, err.Error(), "")
+			return
+		}
+
+		errorutil.InternalServer(c, "something went wrong, we are working", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, token)
+}
+
+func (h *handler) refresh(c *gin.Context) {
+	var input refreshDTO
+
+	if err := c.BindJSON(&input); err != nil {
+		errorutil.BadRequest(c, "incorrect body", err.Error())
+		return
+	}
+
+	if err := h.validate.Struct(input); err != nil {
+		errorutil.BadRequest(c, "incorrect body", err.Error())
+		return
+	}
+
+	token, err := h.service.refreshToken(input.RefreshToken)
+	if err != nil {
+		if errors.Is(err, errInvalidRefreshToken) {
+			errorutil.Unauthorized(c, err.Error(), "sign in again")
+			return
+		}
+
+		errorutil.InternalServer(c, "something went wrong, we are working", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, token)
+}
+
+// forgotPassword answers the same whether or not a user has the email, the link is only emailed
+func (h *handler) forgotPassword(c *gin.Context) {
+	var input forgotPasswordDTO
+
+	if err := c.BindJSON(&input); err != nil {
+		errorutil.BadRequest(c, "incorrect body", err.Error())
+		return
+	}
+
+	if err := h.validate.Struct(input); err != nil {
+		errorutil.BadRequest(c, "incorrect body", err.Error())
+		return
+	}
+
+	h.service.forgotPassword(input.Email)
+
+	c.JSON(http.StatusAccepted, map[string]interface{}{
+		"ok": true,
+	})
+}
+
+func (h *handler) resetPassword(c *gin.Context) {
+	var input resetPasswordDTO
+
+	if err := c.BindJSON(&input); err != nil {
+		errorutil.BadRequest(c, "incorrect body", err.Error())
+		return
+	}
+
+	if err := h.validate.Struct(input); err != nil {
+		errorutil.BadRequest(c, "incorrect body", err.Error())
+		return
+	}
+
+	if err := h.service.resetPassword(input.Token, input.Password); err != nil {
+		if errors.Is(err, errInvalidResetToken) {
+			errorutil.BadRequest(c, err.Error(), "ask for a new reset link")
+			return
+		}
+
+		errorutil.InternalServer(c, "something went wrong, we are working", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"ok": true,
+	})
+}
+
+func (h *handler) verifyEmail(c *gin.Context) {
+	var input verifyEmailDTO
+
+	if err := c.BindJSON(&input); err != nil {
+		errorutil.BadRequest(c, "incorrect body", err.Error())
+		return
+	}
+
+	if err := h.validate.Struct(input); err != nil {
+		errorutil.BadRequest(c, "incorrect body", err.Error())
+		return
+	}
+
+	if err := h.service.verifyEmail(input.Token); err != nil {
+		if errors.Is(err, errInvalidVerificationLink) {
+			errorutil.BadRequest(c, err.Error(), "ask for a new verification link")
+			return
+		}
+
+		if errors.Is(err, errEmailUsed) {
+			errorutil.Error(c, http.StatusConflict, err.Error(), "the email was taken by another account since the link was sent")
+			return
+		}
+
+		errorutil.InternalServer(c, "something went wrong, we are working", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"ok": true,
+	})
+}
+
+// resendVerification answers the same whether or not a user has the email, like forgotPassword
+func (h *handler) resendVerification(c *gin.Context) {
+	var input resendVerificationDTO
+
+	if err := c.BindJSON(&input); err != nil {
+		errorutil.BadRequest(c, "incorrect body", err.Error())
+		return
+	}
+
+	if err := h.validate.Struct(input); err != nil {
+		errorutil.BadRequest(c, "incorrect body", err.Error())
+		return
+	}
+
+	retryAfter, err := h.service.resendVerification(input.Email)
+	if err != nil {
+		errorutil.InternalServer(c, "something went wrong, we are working", err.Error())
+		return
+	}
+
+	if retryAfter > 0 {
+		tooSoon(c, retryAfter)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, map[string]interface{}{
+		"ok": true,
+	})
+}
+
+// changeEmail emails a verification link to the new address, which replaces the email once verified
+func (h *handler) changeEmail(c *gin.Context) {
+	userId, err := GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, "the user is unknown", "you are not authorized")
+		return
+	}
+
+	var input changeEmailDTO
+
+	if err := c.BindJSON(&input); err != nil {
+		errorutil.BadRequest(c, "incorrect body", err.Error())
+		return
+	}
+
+	if err := h.validate.Struct(input); err != nil {
+		errorutil.BadRequest(c, "incorrect body", err.Error())
+		return
+	}
+
+	retryAfter, err := h.service.changeEmail(*userId, input.Email, input.Password)
+	if err != nil {
+		if errors.Is(err, errEmailUsed) {
+			errorutil.Error(c, http.StatusConflict, err.Error(), "choose another email")
+			return
+		}
+
+		errorutil.BadRequest(c, err.Error(), "the email could not be changed")
+		return
+	}
+
+	if retryAfter > 0 {
+		tooSoon(c, retryAfter)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, map[string]interface{}{
+		"ok": true,
+	})
+}
+
+func (h *handler) getOIDCProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"providers": h.service.oidcProviders(),
+	})
+}
+
+// oidcLogin redirects the browser to the provider, setting the cookie which ties the sign in to it
+func (h *handler) oidcLogin(c *gin.Context) {
+	location, browser, err := h.service.oidcLoginURL(c.Request.Context(), c.Param("provider"))
+	if err != nil {
+		if errors.Is(err, errUnknownProvider) {
+			errorutil.NotFound(c, err.Error(), "Not found")
+			return
+		}
+
+		errorutil.InternalServer(c, "the identity provider is not available", err.Error())
+		return
+	}
+
+	setSignInCookie(c, browser, int(oidcStateTTL.Seconds()))
+	c.Redirect(http.StatusFound, location)
+}
+
+// oidcCallback is where the provider redirects the browser back. It always redirects to the client,
+// with the login code or the error, a browser is not shown JSON
+func (h *handler) oidcCallback(c *gin.Context) {
+	if providerErr := c.Query("error"); providerErr != "" {
+		h.redirectToClient(c, "error", providerErr)
+		return
+	}
+
+	browser, _ := c.Cookie(signInCookie)
+	setSignInCookie(c, "", -1)
+
+	code, err := h.service.oidcCallback(c.Request.Context(), c.Param("provider"), c.Query("code"), c.Query("state"), browser)
+	if err != nil {
+		h.logger.Infof("sign in with %s failed: %s", c.Param("provider"), err.Error())
+
+		message := "the sign in with the provider failed"
+		if errors.Is(err, errInvalidState) || errors.Is(err, errNoEmail) || errors.Is(err, errEmailNotLinked) {
+			message = err.Error()
+		}
+
+		h.redirectToClient(c, "error", message)
+		return
+	}
+
+	h.redirectToClient(c, "code", code)
+}
+
+// setSignInCookie sets the cookie of the sign in with a provider, sent back only to its callback. Lax
+// sends it on the redirect from the provider. A negative maxAge deletes it
+func setSignInCookie(c *gin.Context, value string, maxAge int) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(signInCookie, value, maxAge, "/auth/oidc/"+c.Param("provider")+"/callback", "", secure, true)
+}
+
+func (h *handler) redirectToClient(c *gin.Context, key, value string) {
+	location, err := url.Parse(h.clientURL)
+	if err != nil {
+		errorutil.InternalServer(c, "something went wrong, we are working", err.Error())
+		return
+	}
+
+	query := location.Query()
+	query.Set(key, value)
+	location.RawQuery = query.Encode()
+
+	c.Redirect(http.StatusFound, location.String())
+}
+
+// oidcExchange answers the login code like signIn, with the tokens or the MFA challenge
+func (h *handler) oidcExchange(c *gin.Context) {
+	var input loginCodeDTO
+
+	if err := c.BindJSON(&input); err != nil {
+		errorutil.BadRequest(c, "incorrect body", err.Error())
+		return
+	}
+
+	if err := h.validate.Struct(input); err != nil {
+		errorutil.BadRequest(c, "incorrect body", err.Error())
+		return
+	}
+
+	token, challenge, err := h.service.exchangeLoginCode(input.Code)
+	if err != nil {
+		if errors.Is(err, errInvalidLogin) {
+			errorutil.Unauthorized(c, err.Error(), "sign in again")
+			return
+		}
+
+		if errors.Is(err, errEmailNotVerified) {
+			errorutil.Forbidden(c, err.Error(), "verify your email first")
+			return
+		}
+
+		errorutil.InternalServer(c, "something went wrong, we are working", err.Error())
+		return
+	}
+
+	if challenge != nil {
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+
+	c.JSON(http.StatusOK, token)
+}
+
+func (h *handler) enrollTOTP(c *gin.Context) {
+	userId, err := GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, "the user is unknown", "you are not authorized")
+		return
+	}
+
+	enrollment, err := h.service.enrollTOTP(*userId)
+	if err != nil {
+		if errors.Is(err, errTOTPEnabled) {
+			errorutil.Error(c, http.StatusConflict, err.Error(), "disable it first")
+			return
+		}
+
+		errorutil.InternalServer(c, "something went wrong, we are working", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, enrollment)
+}
+
+func (h *handler) confirmTOTP(c *gin.Context) {
+	userId, err := GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, "the user is unknown", "you are not authorized")
+		return
+	}
+
+	var input totpCodeDTO
+
+	if err := c.BindJSON(&input); err != nil {
+		errorutil.BadRequest(c, "incorrect body", err.Error())
+		return
+	}
+
+	if err := h.validate.Struct(input); err != nil {
+		errorutil.BadRequest(c, "incorrect body", err.Error())
+		return
+	}
+
+	codes, err := h.service.confirmTOTP(*userId, input.Code)
+	if err != nil {
+		if errors.Is(err, errInvalidMFACode) || errors.Is(err, errTOTPNotEnrolled) || errors.Is(err, errTOTPEnabled) {
+			errorutil.BadRequest(c, err.Error(), "two-factor authentication was not enabled")
+			return
+		}
+
+		errorutil.InternalServer(c, "something went wrong, we are working", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, codes)
+}
+
+func (h *handler) disableTOTP(c *gin.Context) {
+	userId, err := GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, "the user is unknown", "you are not authorized")
+		return
+	}
+
+	var input disableTOTPDTO
+
+	if err := c.BindJSON(&input); err != nil {
+		errorutil.BadRequest(c, "incorrect body", err.Error())
+		return
+	}
+
+	if err := h.validate.Struct(input); err != nil {
+		errorutil.BadRequest(c, "incorrect body", err.Error())
+		return
+	}
+
+	if err := h.service.disableTOTP(*userId, input.Password, input.Code); err != nil {
+		var locked *mfaLockedError
+		if errors.As(err, &locked) {
+			mfaLocked(c, locked)
+			return
+		}
+
+		errorutil.BadRequest(c, err.Error(), "two-factor authentication was not disabled")
+		return
+	}
+
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"ok": true,
+	})
+}
+
+// tooSoon answers that a verification email was just sent to the address
+func tooSoon(c *gin.Context, retryAfter time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+	errorutil.Error(c, http.StatusTooManyRequests, "a verification email was sent recently", "check your inbox or try again later")
+}
+
+// mfaLocked answers that too many wrong codes locked the second factor of the user
+func mfaLocked(c *gin.Context, locked *mfaLockedError) {
+	c.Header("Retry-After", strconv.Itoa(int(locked.retryAfter.Seconds())+1))
+	errorutil.Error(c, http.StatusTooManyRequests, locked.Error(), "try again later")
+}
+
+// logout revokes the session of the access token, its refresh token can't be used anymore
+func (h *handler) logout(c *gin.Context) {
+	sessionId, err := GetSessionId(c)
+	if err != nil || sessionId == nil {
+		errorutil.Unauthorized(c, "the session is unknown", "you are not authorized")
+		return
+	}
+
+	if err := h.service.logout(*sessionId); err != nil {
+		errorutil.InternalServer(c, "something went wrong, we are working", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"ok": true,
+	})
+}
+
+// logoutAll revokes every session of the user, the current one included, and the personal access tokens
+func (h *handler) logoutAll(c *gin.Context) {
+	userId, err := GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, "the user is unknown", "you are not authorized")
+		return
+	}
+
+	if err := h.service.logoutAll(*userId); err != nil {
+		errorutil.InternalServer(c, "something went wrong, we are working", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"ok": true,
+	})
+}
+
+// createAccessToken returns the token once, only its hash is kept
+func (h *handler) createAccessToken(c *gin.Context) {
+	userId, err := GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, "the user is unknown", "you are not authorized")
+		return
+	}
+
+	var input createAccessTokenDTO
+
+	if err := c.BindJSON(&input); err != nil {
+		errorutil.BadRequest(c, "incorrect body", err.Error())
+		return
+	}
+
+	if err := h.validate.Struct(input); err != nil {
+		errorutil.BadRequest(c, "incorrect body", err.Error())
+		return
+	}
+
+	token, err := h.service.createAccessToken(*userId, input)
 	if err != nil {
 		errorutil.InternalServer(c, "something went wrong, we are working", err.Error())
 		return
 	}
 
-	h.logger.Debug(token)
+	c.JSON(http.StatusCreated, token)
+}
+
+func (h *handler) getAccessTokens(c *gin.Context) {
+	userId, err := GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, "the user is unknown", "you are not authorized")
+		return
+	}
+
+	tokens, err := h.service.getAccessTokens(*userId)
+	if err != nil {
+		errorutil.InternalServer(c, "something went wrong, we are working", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (h *handler) revokeAccessToken(c *gin.Context) {
+	userId, err := GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, "the user is unknown", "you are not authorized")
+		return
+	}
+
+	tokenId, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		errorutil.BadRequest(c, err.Error(), "the id must be an integer")
+		return
+	}
+
+	if err := h.service.revokeAccessToken(*userId, uint(tokenId)); err != nil {
+		errorutil.NotFound(c, err.Error(), "Not found")
+		return
+	}
 
 	c.JSON(http.StatusOK, map[string]interface{}{
-		"token": token,
+		"ok": true,
 	})
 }
 
+// getJWKS publishes the public keys, for the other services to verify the access tokens
+func (h *handler) getJWKS(c *gin.Context) {
+	c.JSON(http.StatusOK, h.service.jwks())
+}
+
 func (h *handler) getUser(c *gin.Context) {
 	userId, err := GetUserId(c)
 	if err != nil {
@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/emPeeGee/raffinance/internal/config"
+	"github.com/emPeeGee/raffinance/pkg/log"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	minSecretLength = 32
+	randomKeyID     = "random"
+)
+
+// key verifies the access tokens carrying its kid, and signs them if it has the private part
+type key struct {
+	id     string
+	method jwt.SigningMethod
+	// private is nil for a public key, kept only to verify the tokens signed before a rotation
+	private interface{}
+	public  interface{}
+}
+
+// Keys are the keys of the access tokens, one of them signs the new tokens. Several keys verify,
+// so a key is rotated without invalidating the tokens it has already signed
+type Keys struct {
+	signing *key
+	byID    map[string]*key
+}
+
+// NewKeys loads the keys of the config. Without keys, a random EdDSA key is generated only if the
+// config allows it, the tokens of every instance must be verified by the others
+func NewKeys(cfg config.Auth, logger log.Logger) (*Keys, error) {
+	keys := &Keys{byID: map[string]*key{}}
+
+	if len(cfg.Keys) == 0 {
+		if !cfg.RandomKey {
+			return nil, errors.New("no JWT keys configured")
+		}
+
+		logger.Error("No JWT keys configured, the access tokens are signed by a random key, for development only. They won't survive a restart nor be accepted by another instance")
+
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
+		keys.signing = &key{id: randomKeyID, method: jwt.SigningMethodEdDSA, private: private, public: public}
+		keys.byID[randomKeyID] = keys.signing
+		return keys, nil
+	}
+
+	for id, path := range cfg.Keys {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+
+		k, err := parseKey(id, data)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+
+		keys.byID[id] = k
+	}
+
+	keys.signing = keys.byID[cfg.SigningKeyID]
+	if keys.signing == nil || keys.signing.private == nil {
+		return nil, fmt.Errorf("key %s can't sign, it has no private key", cfg.SigningKeyID)
+	}
+
+	return keys, nil
+}
+
+// parseKey reads a PEM RSA or Ed25519 key, private or public. Anything else is a HS256 secret
+func parseKey(id string, data []byte) (*key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		secret := bytes.TrimSpace(data)
+		if len(secret) < minSecretLength {
+			return nil, fmt.Errorf("a HS256 secret must have at least %d bytes", minSecretLength)
+		}
+
+		return &key{id: id, method: jwt.SigningMethodHS256, private: secret, public: secret}, nil
+	}
+
+	var parsed interface{}
+	var err error
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("PEM block %s is not supported", block.Type)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &key{id: id, method: jwt.SigningMethodRS256, private: k, public: &k.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &key{id: id, method: jwt.SigningMethodRS256, public: k}, nil
+	case ed25519.PrivateKey:
+		return &key{id: id, method: jwt.SigningMethodEdDSA, private: k, public: k.Public()}, nil
+	case ed25519.PublicKey:
+		return &key{id: id, method: jwt.SigningMethodEdDSA, public: k}, nil
+	default:
+		return nil, fmt.Errorf("key of type %T is not supported, only RSA and Ed25519", parsed)
+	}
+}
+
+// sign signs the claims by the signing key, naming it in the kid header
+func (k *Keys) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.signing.method, claims)
+	token.Header["kid"] = k.signing.id
+
+	return token.SignedString(k.signing.private)
+}
+
+// parse verifies the access token by the key of its kid and returns its claims
+func (k *Keys) parse(accessToken string) (*tokenClaims, error) {
+	token, err := jwt.ParseWithClaims(accessToken, &tokenClaims{}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		verifying, ok := k.byID[kid]
+		if !ok {
+			return nil, fmt.Errorf("key %q is unknown", kid)
+		}
+
+		// The algorithm is the one of the key, never the one the token claims
+		if token.Method.Alg() != verifying.method.Alg() {
+			return nil, errors.New(signingMethodErrorMsg)
+		}
+
+		return verifying.public, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*tokenClaims)
+	if !ok {
+		return nil, errors.New(tokenClaimsErrorMsg)
+	}
+
+	return claims, nil
+}
+
+// jwks returns the public keys, the HS256 secrets are not published
+func (k *Keys) jwks() jwksResponse {
+	response := jwksResponse{Keys: []jwk{}}
+
+	for _, verifying := range k.byID {
+		item := jwk{Kid: verifying.id, Use: "sig", Alg: verifying.method.Alg()}
+
+		switch public := verifying.public.(type) {
+		case *rsa.PublicKey:
+			item.Kty = "RSA"
+			item.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			item.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			item.Kty = "OKP"
+			item.Crv = "Ed25519"
+			item.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+
+		response.Keys = append(response.Keys, item)
+	}
+
+	sort.Slice(response.Keys, func(i, j int) bool { return response.Keys[i].Kid < response.Keys[j].Kid })
+
+	return response
+}
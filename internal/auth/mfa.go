@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/emPeeGee/raffinance/internal/entity"
+	"github.com/emPeeGee/raffinance/pkg/crypt"
+	"github.com/emPeeGee/raffinance/pkg/totp"
+)
+
+const (
+	mfaChallengeTTL = 5 * time.Minute
+	// mfaMaxAttempts is how many wrong codes a challenge takes, then the password is asked again
+	mfaMaxAttempts = 5
+	// mfaMaxFailures is how many wrong codes of a user, over all the challenges, lock the second factor.
+	// Each lock is twice longer than the previous one, until a good code
+	mfaMaxFailures = 10
+	mfaLockout     = 15 * time.Minute
+	mfaMaxLockout  = 24 * time.Hour
+	totpIssuer     = "Raffinance"
+	// totpSkew accepts the codes of the previous and the next period as well, for the clocks a bit off
+	totpSkew          = 1
+	recoveryCodeCount = 10
+)
+
+var (
+	errInvalidMFAChallenge = errors.New("the mfa token is invalid, expired or was tried too many times")
+	errInvalidMFACode      = errors.New("the code is invalid or was already used")
+	errTOTPEnabled         = errors.New("two-factor authentication is already enabled")
+	errTOTPNotEnrolled     = errors.New("two-factor authentication is not enrolled")
+)
+
+// mfaLockedError is returned while too many wrong codes lock the second factor of the user
+type mfaLockedError struct {
+	retryAfter time.Duration
+}
+
+func (e *mfaLockedError) Error() string {
+	return "too many wrong codes, two-factor authentication is locked for a while"
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// challengeMFA creates the challenge the second factor is given with
+func (s *service) challengeMFA(userID uint) (*mfaChallengeResponse, error) {
+	token, hash, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	challenge := entity.MFAChallenge{
+		CreatedAt: now,
+		UserID:    userID,
+		Hash:      hash,
+		ExpiresAt: now.Add(mfaChallengeTTL),
+	}
+
+	if err := s.repo.createMFAChallenge(&challenge); err != nil {
+		return nil, err
+	}
+
+	return &mfaChallengeResponse{MFARequired: true, MFAToken: token, ExpiresAt: challenge.ExpiresAt}, nil
+}
+
+// verifyMFA exchanges the challenge and a TOTP or recovery code for the tokens
+func (s *service) verifyMFA(mfaToken, code string) (*tokenResponse, error) {
+	now := time.Now()
+
+	challenge, err := s.repo.getMFAChallenge(hashToken(mfaToken))
+	if err != nil {
+		return nil, err
+	}
+
+	if challenge == nil || challenge.UsedAt != nil || challenge.ExpiresAt.Before(now) || challenge.Attempts >= mfaMaxAttempts {
+		return nil, errInvalidMFAChallenge
+	}
+
+	ok, err := s.checkSecondFactor(challenge.UserID, code, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		if err := s.repo.failMFAChallenge(challenge.ID); err != nil {
+			return nil, err
+		}
+
+		return nil, errInvalidMFACode
+	}
+
+	completed, err := s.repo.completeMFAChallenge(challenge.ID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if !completed {
+		return nil, errInvalidMFAChallenge
+	}
+
+	user, err := s.repo.getUserEntity(challenge.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.startSession(*user)
+}
+
+// checkSecondFactor accepts a TOTP code not used yet or an unused recovery code, and uses it. The
+// wrong codes are counted for the user, a few challenges can't be used to guess the code
+func (s *service) checkSecondFactor(userID uint, code string, now time.Time) (bool, error) {
+	current, err := s.repo.getTOTP(userID)
+	if err != nil {
+		return false, err
+	}
+
+	if current == nil || current.ConfirmedAt == nil {
+		return false, nil
+	}
+
+	if current.LockedUntil != nil && current.LockedUntil.After(now) {
+		return false, &mfaLockedError{retryAfter: current.LockedUntil.Sub(now)}
+	}
+
+	ok, err := s.useSecondFactor(userID, current.Secret, code, now)
+	if err != nil {
+		return false, err
+	}
+
+	if ok {
+		return true, s.repo.resetSecondFactorFailures(userID)
+	}
+
+	failures, err := s.repo.failSecondFactor(userID)
+	if err != nil {
+		return false, err
+	}
+
+	if failures > 0 && failures%mfaMaxFailures == 0 {
+		lockout := mfaLockout << (failures/mfaMaxFailures - 1)
+		if lockout > mfaMaxLockout || lockout <= 0 {
+			lockout = mfaMaxLockout
+		}
+
+		if err := s.repo.lockSecondFactor(userID, now.Add(lockout)); err != nil {
+			return false, err
+		}
+
+		return false, &mfaLockedError{retryAfter: lockout}
+	}
+
+	return false, nil
+}
+
+func (s *service) useSecondFactor(userID uint, secret, code string, now time.Time) (bool, error) {
+	code = strings.TrimSpace(code)
+	if step, ok := totp.Validate(secret, code, now, totpSkew); ok {
+		return s.repo.useTOTPStep(userID, step)
+	}
+
+	return s.repo.useRecoveryCode(userID, hashToken(normalizeRecoveryCode(code)), now)
+}
+
+// enrollTOTP generates the secret of the user, it guards the sign in once confirmed by a code
+func (s *service) enrollTOTP(userID uint) (*totpEnrollmentResponse, error) {
+	current, err := s.repo.getTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if current != nil && current.ConfirmedAt != nil {
+		return nil, errTOTPEnabled
+	}
+
+	user, err := s.repo.getUserEntity(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.replaceTOTP(&entity.TOTP{UserID: userID, Secret: secret}); err != nil {
+		return nil, err
+	}
+
+	return &totpEnrollmentResponse{Secret: secret, URI: totp.URI(totpIssuer, user.Username, secret)}, nil
+}
+
+// confirmTOTP enables the TOTP by its first code and returns the recovery codes, shown only once
+func (s *service) confirmTOTP(userID uint, code string) (*recoveryCodesResponse, error) {
+	current, err := s.repo.getTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if current == nil {
+		return nil, errTOTPNotEnrolled
+	}
+
+	if current.ConfirmedAt != nil {
+		return nil, errTOTPEnabled
+	}
+
+	now := time.Now()
+
+	step, ok := totp.Validate(current.Secret, code, now, totpSkew)
+	if !ok {
+		return nil, errInvalidMFACode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	recoveryCodes := make([]entity.RecoveryCode, len(hashes))
+	for i, hash := range hashes {
+		recoveryCodes[i] = entity.RecoveryCode{UserID: userID, Hash: hash}
+	}
+
+	if err := s.repo.confirmTOTP(userID, step, recoveryCodes, now); err != nil {
+		return nil, err
+	}
+
+	return &recoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// disableTOTP removes the TOTP and the recovery codes, after checking the password and a code
+func (s *service) disableTOTP(userID uint, password, code string) error {
+	user, err := s.repo.getUserEntity(userID)
+	if err != nil {
+		return err
+	}
+
+	if !crypt.CheckPasswordHashes(password, user.Password) {
+		return errors.New("password does not match")
+	}
+
+	ok, err := s.checkSecondFactor(userID, code, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return errInvalidMFACode
+	}
+
+	return s.repo.deleteTOTP(userID)
+}
+
+// newRecoveryCodes returns the codes, formatted as xxxxx-xxxxx, and their hashes
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		bytes := make([]byte, 7)
+		if _, err := rand.Read(bytes); err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(recoveryEncoding.EncodeToString(bytes)[:10])
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashToken(code)
+	}
+
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode drops the dash and the case, the way the code is typed doesn't matter
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
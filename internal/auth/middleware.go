@@ -1,8 +1,10 @@
 package auth
 
 import (
+	"net/http"
 	"strings"
 
+	"github.com/emPeeGee/raffinance/internal/config"
 	"github.com/emPeeGee/raffinance/pkg/errorutil"
 	"github.com/emPeeGee/raffinance/pkg/log"
 	"github.com/gin-gonic/gin"
@@ -11,9 +13,22 @@ import (
 const (
 	authorizationHeader = "Authorization"
 	userCtx             = "userId"
+	sessionCtx          = "sessionId"
+	scopesCtx           = "scopes"
 )
 
-func HandleUserIdentity(logger log.Logger) gin.HandlerFunc {
+// accountRoutes stay open to the users with an unverified email, to fix a wrong one or sign out
+var accountRoutes = map[string]bool{
+	"/api/user":       true,
+	"/api/user/email": true,
+	"/api/logout":     true,
+	"/api/logoutAll":  true,
+}
+
+// HandleUserIdentity authenticates the request by its access token, a JWT of a session which is not
+// revoked or a personal access token. The users with an unverified email are limited by the
+// verification policy
+func HandleUserIdentity(service Service, logger log.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		header := c.GetHeader(authorizationHeader)
 		if header == "" {
@@ -33,12 +48,74 @@ func HandleUserIdentity(logger log.Logger) gin.HandlerFunc {
 			return
 		}
 
-		userId, err := extractUserIdFromToken(headerParts[1])
+		if isAccessToken(headerParts[1]) {
+			handleAccessToken(c, service, headerParts[1])
+			return
+		}
+
+		claims, err := service.parseToken(headerParts[1])
 		if err != nil {
 			errorutil.Unauthorized(c, "the token is invalid", err.Error())
 			return
 		}
 
-		c.Set(userCtx, *userId)
+		session, err := service.checkSession(claims.UserId, claims.SessionId)
+		if err != nil {
+			errorutil.InternalServer(c, "something went wrong, we are working", err.Error())
+			return
+		}
+
+		if session == nil {
+			errorutil.Unauthorized(c, "the session is revoked", "sign in again")
+			return
+		}
+
+		if !session.EmailVerified && !unverifiedAllowed(service.emailVerificationPolicy(), c) {
+			errorutil.Forbidden(c, errEmailNotVerified.Error(), "verify your email first")
+			return
+		}
+
+		c.Set(userCtx, claims.UserId)
+		c.Set(sessionCtx, claims.SessionId)
 	}
 }
+
+// handleAccessToken authenticates the request of a script, its scopes are checked by RequireScope
+func handleAccessToken(c *gin.Context, service Service, token string) {
+	status, err := service.authenticateAccessToken(token)
+	if err != nil {
+		errorutil.InternalServer(c, "something went wrong, we are working", err.Error())
+		return
+	}
+
+	if status == nil {
+		errorutil.Unauthorized(c, "the token is invalid, expired or revoked", "create a new token")
+		return
+	}
+
+	if !status.EmailVerified && !unverifiedAllowed(service.emailVerificationPolicy(), c) {
+		errorutil.Forbidden(c, errEmailNotVerified.Error(), "verify your email first")
+		return
+	}
+
+	c.Set(userCtx, status.UserID)
+	c.Set(scopesCtx, []string(status.Scopes))
+}
+
+// unverifiedAllowed tells whether the policy lets a user with an unverified email do the request.
+// Restrict lets them read, block only lets them to the account routes, the tokens issued before
+// the policy was set still work
+func unverifiedAllowed(policy string, c *gin.Context) bool {
+	if policy == config.EmailVerificationOff || accountRoutes[c.FullPath()] {
+		return true
+	}
+
+	if policy == config.EmailVerificationRestrict {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return true
+		}
+	}
+
+	return false
+}
@@ -8,7 +8,7 @@ import (
 
 type createUserDTO struct {
 	Password string `json:"password" validate:"required,min=4,max=256"`
-	Email    string `json:"email" validate:"required,min=3,max=256"`
+	Email    string `json:"email" validate:"required,email,max=256"`
 	Phone    string `json:"phone" validate:"required,min=1,max=16"`
 	Username string `json:"username" validate:"required,min=3,max=64"`
 	Name     string `json:"name" validate:"required,min=2,max=256"`
@@ -24,11 +24,134 @@ type userHashedPassword struct {
 }
 
 type UserResponse struct {
-	Username     string         `json:"username"`
-	Name         string         `json:"name"`
-	Phone        string         `json:"phone"`
-	Email        string         `json:"email"`
-	LatestLogins pq.StringArray `json:"latestLogins" gorm:"type:varchar(64)[]"`
-	CreatedAt    time.Time      `json:"createdAt"`
-	UpdatedAt    time.Time      `json:"updatedAt"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+	Phone    string `json:"phone"`
+	Email    string `json:"email"`
+	// EmailVerifiedAt is nil until the email is verified
+	EmailVerifiedAt *time.Time     `json:"emailVerifiedAt"`
+	LatestLogins    pq.StringArray `json:"latestLogins" gorm:"type:varchar(64)[]"`
+	CreatedAt       time.Time      `json:"createdAt"`
+	UpdatedAt       time.Time      `json:"updatedAt"`
+}
+
+type forgotPasswordDTO struct {
+	Email string `json:"email" validate:"required,email,max=256"`
+}
+
+type resetPasswordDTO struct {
+	Token    string `json:"token" validate:"required,len=64,hexadecimal"`
+	Password string `json:"password" validate:"required,min=4,max=256"`
+}
+
+type verifyEmailDTO struct {
+	Token string `json:"token" validate:"required,len=64,hexadecimal"`
+}
+
+type resendVerificationDTO struct {
+	Email string `json:"email" validate:"required,email,max=256"`
+}
+
+// changeEmailDTO asks for the password, a stolen access token should not be enough to take the account
+type changeEmailDTO struct {
+	Email    string `json:"email" validate:"required,email,max=256"`
+	Password string `json:"password" validate:"required,min=4,max=256"`
+}
+
+type sessionStatus struct {
+	EmailVerified bool
+}
+
+type refreshDTO struct {
+	RefreshToken string `json:"refreshToken" validate:"required,len=64,hexadecimal"`
+}
+
+type tokenResponse struct {
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refreshToken"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
+// jwksResponse is the JSON Web Key Set of the public keys verifying the access tokens
+type jwksResponse struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// N and E are the modulus and exponent of a RSA key
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Crv and X are the curve and public key of an Ed25519 key
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type mfaDTO struct {
+	MFAToken string `json:"mfaToken" validate:"required,len=64,hexadecimal"`
+	// Code is a TOTP code or a recovery code
+	Code string `json:"code" validate:"required,max=32"`
+}
+
+type totpCodeDTO struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+// disableTOTPDTO asks for both factors again, a stolen access token should not be enough to remove one
+type disableTOTPDTO struct {
+	Password string `json:"password" validate:"required,min=4,max=256"`
+	Code     string `json:"code" validate:"required,max=32"`
+}
+
+// mfaChallengeResponse answers the sign in of a user with TOTP, instead of the tokens
+type mfaChallengeResponse struct {
+	MFARequired bool      `json:"mfaRequired"`
+	MFAToken    string    `json:"mfaToken"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+type totpEnrollmentResponse struct {
+	Secret string `json:"secret"`
+	// URI is the otpauth URI, shown as a QR code for the authenticator app
+	URI string `json:"uri"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type loginCodeDTO struct {
+	Code string `json:"code" validate:"required,len=64,hexadecimal"`
+}
+
+type createAccessTokenDTO struct {
+	Name   string   `json:"name" validate:"required,min=1,max=64"`
+	Scopes []string `json:"scopes" validate:"required,min=1,unique,dive,tokenscope"`
+	// ExpiresInDays is empty for a token which doesn't expire
+	ExpiresInDays int `json:"expiresInDays" validate:"omitempty,min=1,max=3650"`
+}
+
+type accessTokenStatus struct {
+	UserID        uint
+	Scopes        pq.StringArray `gorm:"type:varchar(64)[]"`
+	EmailVerified bool
+}
+
+type accessTokenResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+// createdAccessTokenResponse is the only response with the token, it can't be read later
+type createdAccessTokenResponse struct {
+	accessTokenResponse
+	Token string `json:"token"`
 }
@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emPeeGee/raffinance/internal/entity"
+	"github.com/emPeeGee/raffinance/pkg/oidc"
+)
+
+const (
+	// oidcStateTTL is the time the user has to sign in at the provider
+	oidcStateTTL = 10 * time.Minute
+	loginCodeTTL = time.Minute
+	// signInCookie ties the sign in with a provider to the browser which started it
+	signInCookie = "oidc_sign_in"
+)
+
+var (
+	errUnknownProvider = errors.New("the identity provider is unknown")
+	errInvalidState    = errors.New("the sign in expired or was already used, start it again")
+	errInvalidLogin    = errors.New("the login code is invalid, expired or already used")
+	errNoEmail         = errors.New("the identity provider did not share an email")
+	// errEmailNotLinked keeps a provider from taking an account by an address nobody verified
+	errEmailNotLinked = errors.New("an account has this email, sign in with the password to use it")
+)
+
+var usernameChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+func (s *service) oidcProviders() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// oidcLoginURL starts the sign in with the provider, the user is redirected to the returned page. The
+// second value is kept in a cookie of the browser, only that browser can end the sign in
+func (s *service) oidcLoginURL(ctx context.Context, provider string) (string, string, error) {
+	p, ok := s.providers[provider]
+	if !ok {
+		return "", "", errUnknownProvider
+	}
+
+	state, err := oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	browser, err := oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	if err := s.repo.createProviderSignIn(&entity.ProviderSignIn{
+		CreatedAt:   now,
+		Provider:    provider,
+		Hash:        hashToken(state),
+		BrowserHash: hashToken(browser),
+		Nonce:       nonce,
+		Verifier:    verifier,
+		ExpiresAt:   now.Add(oidcStateTTL),
+	}); err != nil {
+		return "", "", err
+	}
+
+	location, err := p.AuthURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	return location, browser, nil
+}
+
+// oidcCallback ends the sign in at the provider. It finds, links or creates the user of the identity
+// and returns the login code the client exchanges for the tokens. The browser must be the one which
+// started the sign in, else a victim could be signed in the account of an attacker by a link
+func (s *service) oidcCallback(ctx context.Context, provider, code, state, browser string) (string, error) {
+	p, ok := s.providers[provider]
+	if !ok {
+		return "", errUnknownProvider
+	}
+
+	if state == "" || browser == "" {
+		return "", errInvalidState
+	}
+
+	pending, err := s.repo.redeemProviderSignIn(provider, hashToken(state), hashToken(browser), time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	if pending == nil {
+		return "", errInvalidState
+	}
+
+	claims, err := p.Exchange(ctx, code, pending.Verifier, pending.Nonce)
+	if err != nil {
+		return "", err
+	}
+
+	user, err := s.resolveIdentity(provider, claims)
+	if err != nil {
+		return "", err
+	}
+
+	loginCode, hash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	if err := s.repo.createLoginCode(&entity.LoginCode{
+		CreatedAt: now,
+		UserID:    user.ID,
+		Hash:      hash,
+		ExpiresAt: now.Add(loginCodeTTL),
+	}); err != nil {
+		return "", err
+	}
+
+	return loginCode, nil
+}
+
+// resolveIdentity returns the user linked to the identity. Else an account with the same email is
+// linked, if both the provider and the account verified it, or a user is created
+func (s *service) resolveIdentity(provider string, claims *oidc.Claims) (*entity.User, error) {
+	user, err := s.repo.getUserByIdentity(provider, claims.Subject)
+	if err != nil || user != nil {
+		return user, err
+	}
+
+	if claims.Email == "" {
+		return nil, errNoEmail
+	}
+
+	identity := entity.ExternalIdentity{Provider: provider, Subject: claims.Subject, Email: claims.Email}
+
+	existing, err := s.repo.getUserByEmail(claims.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing != nil {
+		if !claims.EmailVerified || existing.EmailVerifiedAt == nil {
+			return nil, errEmailNotLinked
+		}
+
+		identity.UserID = existing.ID
+		if err := s.repo.linkIdentity(&identity); err != nil {
+			return nil, err
+		}
+
+		s.logger.Infof("user %d was linked to the %s identity %s", existing.ID, provider, claims.Subject)
+		return existing, nil
+	}
+
+	username, err := s.freeUsername(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	created := entity.User{
+		Name:     claims.Name,
+		Email:    claims.Email,
+		Username: username,
+		// No password, the user signs in with the provider or sets one by the password reset
+		Password:     "",
+		LatestLogins: []string{},
+	}
+
+	if created.Name == "" {
+		created.Name = username
+	}
+
+	if claims.EmailVerified {
+		now := time.Now()
+		created.EmailVerifiedAt = &now
+	}
+
+	if err := s.repo.createUserWithIdentity(&created, &identity); err != nil {
+		return nil, err
+	}
+
+	s.logger.Infof("user %d was created from the %s identity %s", created.ID, provider, claims.Subject)
+
+	if created.EmailVerifiedAt == nil {
+		s.verifications.Allow(strings.ToLower(created.Email))
+		go func() {
+			if err := s.sendVerification(&created, created.Email); err != nil {
+				s.logger.Errorf("verification email of user %d could not be sent: %s", created.ID, err.Error())
+			}
+		}()
+	}
+
+	return &created, nil
+}
+
+// freeUsername derives a username from the claims, with a number added while it is taken
+func (s *service) freeUsername(claims *oidc.Claims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	base = usernameChars.ReplaceAllString(strings.ToLower(base), "")
+	if len(base) > 56 {
+		base = base[:56]
+	}
+
+	for len(base) < 3 {
+		base += "_"
+	}
+
+	username := base
+	for i := 2; ; i++ {
+		used, err := s.repo.usernameIsUsed(username)
+		if err != nil || !used {
+			return username, err
+		}
+
+		username = fmt.Sprintf("%s%d", base, i)
+	}
+}
+
+// exchangeLoginCode answers like the sign in with the password, the second factor is still asked
+func (s *service) exchangeLoginCode(code string) (*tokenResponse, *mfaChallengeResponse, error) {
+	userID, err := s.repo.redeemLoginCode(hashToken(code), time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if userID == nil {
+		return nil, nil, errInvalidLogin
+	}
+
+	user, err := s.repo.getUserEntity(*userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.signIn(*user)
+}
@@ -0,0 +1,469 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emPeeGee/raffinance/internal/config"
+	"github.com/emPeeGee/raffinance/internal/entity"
+	"github.com/emPeeGee/raffinance/pkg/log"
+	"github.com/emPeeGee/raffinance/pkg/mail"
+	"github.com/emPeeGee/raffinance/pkg/oidc"
+	"github.com/emPeeGee/raffinance/pkg/oidc/oidctest"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator"
+	"gorm.io/gorm"
+)
+
+const (
+	testProvider  = "mock"
+	testClientURL = "https://raffinance.test/oidc/callback"
+)
+
+// fakeOIDCRepository keeps the sign ins, the users and their identities in memory. The other methods
+// of the Repository are not used by the sign in with a provider
+type fakeOIDCRepository struct {
+	Repository
+
+	mutex      sync.Mutex
+	signIns    []entity.ProviderSignIn
+	users      []entity.User
+	identities []entity.ExternalIdentity
+	loginCodes []entity.LoginCode
+}
+
+func (r *fakeOIDCRepository) createProviderSignIn(state *entity.ProviderSignIn) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.signIns = append(r.signIns, *state)
+	return nil
+}
+
+func (r *fakeOIDCRepository) redeemProviderSignIn(provider, hash, browserHash string, now time.Time) (*entity.ProviderSignIn, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, s := range r.signIns {
+		if s.Provider == provider && s.Hash == hash && s.BrowserHash == browserHash && s.ExpiresAt.After(now) {
+			r.signIns = append(r.signIns[:i], r.signIns[i+1:]...)
+			return &s, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (r *fakeOIDCRepository) getUserByIdentity(provider, subject string) (*entity.User, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return r.user(identity.UserID), nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (r *fakeOIDCRepository) getUserByEmail(email string) (*entity.User, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, user := range r.users {
+		if strings.EqualFold(user.Email, email) {
+			return r.user(user.ID), nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (r *fakeOIDCRepository) linkIdentity(identity *entity.ExternalIdentity) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.identities = append(r.identities, *identity)
+	return nil
+}
+
+func (r *fakeOIDCRepository) createUserWithIdentity(user *entity.User, identity *entity.ExternalIdentity) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	user.ID = uint(len(r.users) + 1)
+	identity.UserID = user.ID
+
+	r.users = append(r.users, *user)
+	r.identities = append(r.identities, *identity)
+	return nil
+}
+
+func (r *fakeOIDCRepository) usernameIsUsed(username string) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, user := range r.users {
+		if user.Username == username {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (r *fakeOIDCRepository) createLoginCode(code *entity.LoginCode) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.loginCodes = append(r.loginCodes, *code)
+	return nil
+}
+
+// user returns a copy of the user of the id, the mutex is held
+func (r *fakeOIDCRepository) user(id uint) *entity.User {
+	for _, user := range r.users {
+		if user.ID == id {
+			return &user
+		}
+	}
+
+	return nil
+}
+
+// addUser adds a user with the next id, verified when verifiedAt is given
+func (r *fakeOIDCRepository) addUser(username, email string, verifiedAt *time.Time) uint {
+	id := uint(len(r.users) + 1)
+	r.users = append(r.users, entity.User{Model: gorm.Model{ID: id}, Username: username, Email: email, EmailVerifiedAt: verifiedAt})
+	return id
+}
+
+func newOIDCService(t *testing.T) (*service, *fakeOIDCRepository, *oidctest.Provider) {
+	t.Helper()
+
+	idp := oidctest.NewProvider(t, "raffinance")
+	repo := &fakeOIDCRepository{}
+
+	s := NewAuthService(repo, nil, config.Auth{
+		OIDCProviders: []config.OIDCProvider{{
+			Name:        testProvider,
+			Issuer:      idp.Issuer(),
+			ClientID:    "raffinance",
+			RedirectURL: "https://raffinance.test/auth/oidc/mock/callback",
+			Scopes:      []string{"openid", "email", "profile"},
+		}},
+	}, mail.NewLogSender(log.New()), log.New())
+
+	return s, repo, idp
+}
+
+// signInWith signs in at the provider with the claims and returns the user of the login code
+func signInWith(t *testing.T, s *service, repo *fakeOIDCRepository, idp *oidctest.Provider, claims map[string]interface{}) (*entity.User, error) {
+	t.Helper()
+
+	location, browser, err := s.oidcLoginURL(context.Background(), testProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, state := idp.Authorize(t, location, claims)
+	loginCode, err := s.oidcCallback(context.Background(), testProvider, code, state, browser)
+	if err != nil {
+		return nil, err
+	}
+
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for _, c := range repo.loginCodes {
+		if c.Hash == hashToken(loginCode) {
+			return repo.user(c.UserID), nil
+		}
+	}
+
+	t.Fatalf("no login code was created for %s", loginCode)
+	return nil, nil
+}
+
+func TestOIDCLinksAVerifiedEmail(t *testing.T) {
+	s, repo, idp := newOIDCService(t)
+
+	verifiedAt := time.Now()
+	id := repo.addUser("ana", "Ana@example.com", &verifiedAt)
+
+	claims := map[string]interface{}{"sub": "subject", "email": "ana@example.com", "email_verified": true}
+
+	user, err := signInWith(t, s, repo, idp, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.ID != id {
+		t.Errorf("signed in the user %d, expected %d", user.ID, id)
+	}
+
+	if len(repo.identities) != 1 || repo.identities[0].UserID != id || repo.identities[0].Subject != "subject" {
+		t.Fatalf("the identities are %+v, expected the subject linked to the user %d", repo.identities, id)
+	}
+
+	// The next sign in finds the user by the identity, even after the email changed at the provider
+	claims["email"] = "ana@another.test"
+	user, err = signInWith(t, s, repo, idp, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.ID != id || len(repo.identities) != 1 || len(repo.users) != 1 {
+		t.Errorf("signed in the user %d with %d identities, expected the linked user %d", user.ID, len(repo.identities), id)
+	}
+}
+
+func TestOIDCRefusesAnEmailNotVerified(t *testing.T) {
+	verifiedAt := time.Now()
+
+	tests := []struct {
+		name          string
+		verifiedAt    *time.Time
+		emailVerified interface{}
+	}{
+		{name: "not verified by the provider", verifiedAt: &verifiedAt, emailVerified: false},
+		{name: "not verified by the provider as a string", verifiedAt: &verifiedAt, emailVerified: "false"},
+		{name: "without the claim", verifiedAt: &verifiedAt},
+		{name: "not verified by the account", emailVerified: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, repo, idp := newOIDCService(t)
+			repo.addUser("ana", "ana@example.com", test.verifiedAt)
+
+			claims := map[string]interface{}{"sub": "subject", "email": "ana@example.com"}
+			if test.emailVerified != nil {
+				claims["email_verified"] = test.emailVerified
+			}
+
+			_, err := signInWith(t, s, repo, idp, claims)
+			if !errors.Is(err, errEmailNotLinked) {
+				t.Errorf("got error %v, expected %v", err, errEmailNotLinked)
+			}
+
+			if len(repo.identities) != 0 || len(repo.users) != 1 || len(repo.loginCodes) != 0 {
+				t.Errorf("the refused sign in left %d identities, %d users and %d login codes",
+					len(repo.identities), len(repo.users), len(repo.loginCodes))
+			}
+		})
+	}
+}
+
+func TestOIDCRefusesWithoutEmail(t *testing.T) {
+	s, repo, idp := newOIDCService(t)
+
+	_, err := signInWith(t, s, repo, idp, map[string]interface{}{"sub": "subject"})
+	if !errors.Is(err, errNoEmail) {
+		t.Errorf("got error %v, expected %v", err, errNoEmail)
+	}
+}
+
+func TestOIDCCreatesAUser(t *testing.T) {
+	s, repo, idp := newOIDCService(t)
+	repo.addUser("ana.maria", "first@example.com", nil)
+	repo.addUser("ana.maria2", "second@example.com", nil)
+
+	user, err := signInWith(t, s, repo, idp, map[string]interface{}{
+		"sub":                "subject",
+		"email":              "ana@example.com",
+		"email_verified":     true,
+		"name":               "Ana Maria",
+		"preferred_username": "Ana.Maria",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.Username != "ana.maria3" || user.Name != "Ana Maria" || user.Email != "ana@example.com" {
+		t.Errorf("created the user %q named %q with %q", user.Username, user.Name, user.Email)
+	}
+
+	if user.EmailVerifiedAt == nil || user.Password != "" {
+		t.Errorf("the user created is verified at %v with the password %q, expected verified without password",
+			user.EmailVerifiedAt, user.Password)
+	}
+
+	if len(repo.identities) != 1 || repo.identities[0].UserID != user.ID || repo.identities[0].Provider != testProvider {
+		t.Errorf("the identities are %+v, expected the identity of the user %d", repo.identities, user.ID)
+	}
+}
+
+func TestFreeUsername(t *testing.T) {
+	tests := []struct {
+		name     string
+		claims   oidc.Claims
+		taken    []string
+		username string
+	}{
+		{
+			name:     "the preferred username",
+			claims:   oidc.Claims{PreferredUsername: "Ana", Email: "someone@example.com"},
+			username: "ana",
+		},
+		{
+			name:     "the email without the domain",
+			claims:   oidc.Claims{Email: "ana.maria@example.com"},
+			username: "ana.maria",
+		},
+		{
+			name:     "the characters out of the usernames are removed",
+			claims:   oidc.Claims{PreferredUsername: "Ána María+1"},
+			username: "namara1",
+		},
+		{
+			name:     "a short one is filled",
+			claims:   oidc.Claims{PreferredUsername: "a"},
+			username: "a__",
+		},
+		{
+			name:     "a number is added while it is taken",
+			claims:   oidc.Claims{PreferredUsername: "ana"},
+			taken:    []string{"ana", "ana2", "ana3"},
+			username: "ana4",
+		},
+		{
+			name:     "a long one is cut before the number",
+			claims:   oidc.Claims{PreferredUsername: strings.Repeat("a", 70)},
+			taken:    []string{strings.Repeat("a", 56)},
+			username: strings.Repeat("a", 56) + "2",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := &fakeOIDCRepository{}
+			for _, username := range test.taken {
+				repo.addUser(username, username+"@example.com", nil)
+			}
+
+			s := &service{repo: repo, logger: log.New()}
+
+			username, err := s.freeUsername(&test.claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if username != test.username {
+				t.Errorf("got %q, expected %q", username, test.username)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackBindsTheBrowser(t *testing.T) {
+	claims := map[string]interface{}{"sub": "subject", "email": "ana@example.com", "email_verified": true}
+
+	tests := []struct {
+		name    string
+		browser func(started string) string
+	}{
+		{name: "without the cookie", browser: func(string) string { return "" }},
+		{name: "with the cookie of another browser", browser: func(string) string { return "another browser" }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, repo, idp := newOIDCService(t)
+
+			location, browser, err := s.oidcLoginURL(context.Background(), testProvider)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			code, state := idp.Authorize(t, location, claims)
+
+			_, err = s.oidcCallback(context.Background(), testProvider, code, state, test.browser(browser))
+			if !errors.Is(err, errInvalidState) {
+				t.Fatalf("got error %v, expected %v", err, errInvalidState)
+			}
+
+			if len(repo.users) != 0 || len(repo.loginCodes) != 0 {
+				t.Errorf("the callback of another browser created %d users and %d login codes", len(repo.users), len(repo.loginCodes))
+			}
+
+			// The browser which started it still ends it
+			if _, err := s.oidcCallback(context.Background(), testProvider, code, state, browser); err != nil {
+				t.Fatal(err)
+			}
+
+			// Once
+			if _, err := s.oidcCallback(context.Background(), testProvider, code, state, browser); !errors.Is(err, errInvalidState) {
+				t.Errorf("got error %v on the second callback, expected %v", err, errInvalidState)
+			}
+		})
+	}
+}
+
+func TestOIDCHandlersSetAndCheckTheCookie(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s, repo, idp := newOIDCService(t)
+
+	router := gin.New()
+	RegisterHandlers(router.Group("/auth"), router.Group("/api"), router.Group("/.well-known"), s, testClientURL, validator.New(), log.New())
+
+	login := httptest.NewRecorder()
+	router.ServeHTTP(login, httptest.NewRequest(http.MethodGet, "/auth/oidc/mock/login", nil))
+
+	if login.Code != http.StatusFound {
+		t.Fatalf("the login answered %d", login.Code)
+	}
+
+	var cookie *http.Cookie
+	for _, c := range login.Result().Cookies() {
+		if c.Name == signInCookie {
+			cookie = c
+		}
+	}
+
+	if cookie == nil || cookie.Value == "" || !cookie.HttpOnly || cookie.Path != "/auth/oidc/mock/callback" {
+		t.Fatalf("the login set the cookie %+v", cookie)
+	}
+
+	code, state := idp.Authorize(t, login.Header().Get("Location"), map[string]interface{}{
+		"sub": "subject", "email": "ana@example.com", "email_verified": true,
+	})
+
+	callback := func(withCookie bool) url.Values {
+		request := httptest.NewRequest(http.MethodGet, "/auth/oidc/mock/callback?"+url.Values{"code": {code}, "state": {state}}.Encode(), nil)
+		if withCookie {
+			request.AddCookie(cookie)
+		}
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+
+		location, err := url.Parse(response.Header().Get("Location"))
+		if response.Code != http.StatusFound || err != nil || !strings.HasPrefix(location.String(), testClientURL+"?") {
+			t.Fatalf("the callback answered %d to %s", response.Code, response.Header().Get("Location"))
+		}
+
+		return location.Query()
+	}
+
+	if query := callback(false); query.Get("error") != errInvalidState.Error() || query.Get("code") != "" {
+		t.Errorf("the callback without the cookie redirected with %v", query)
+	}
+
+	if query := callback(true); query.Get("code") == "" || query.Get("error") != "" {
+		t.Errorf("the callback with the cookie redirected with %v", query)
+	}
+
+	if len(repo.users) != 1 || len(repo.loginCodes) != 1 {
+		t.Errorf("the sign in created %d users and %d login codes, expected 1 of each", len(repo.users), len(repo.loginCodes))
+	}
+}
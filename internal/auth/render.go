@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+
+	"github.com/emPeeGee/raffinance/pkg/mail"
+)
+
+//go:embed templates
+var templatesFS embed.FS
+
+var (
+	htmlTemplates = htmltemplate.Must(htmltemplate.ParseFS(templatesFS, "templates/*.html"))
+	textTemplates = texttemplate.Must(texttemplate.ParseFS(templatesFS, "templates/*.txt"))
+)
+
+const (
+	passwordResetEmail     = "password_reset"
+	emailVerificationEmail = "email_verification"
+)
+
+// linkEmail is what the emails carrying a link are rendered with
+type linkEmail struct {
+	Name     string
+	Link     string
+	ValidFor string
+}
+
+// renderEmail builds the email of the templates with the name, with both a text and an html body
+func renderEmail(name, to, subject string, data linkEmail) (mail.Message, error) {
+	var text, html bytes.Buffer
+
+	if err := textTemplates.ExecuteTemplate(&text, name+".txt", data); err != nil {
+		return mail.Message{}, err
+	}
+
+	if err := htmlTemplates.ExecuteTemplate(&html, name+".html", data); err != nil {
+		return mail.Message{}, err
+	}
+
+	return mail.Message{
+		To:      []string{to},
+		Subject: subject,
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
@@ -1,19 +1,61 @@
 package auth
 
 import (
+	"errors"
 	"time"
 
 	"github.com/emPeeGee/raffinance/internal/entity"
 	"github.com/emPeeGee/raffinance/pkg/log"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Repository interface {
-	createUser(user createUserDTO) error
+	createUser(user createUserDTO) (*entity.User, error)
 	updateLatestLogins(username string) error
 	getUserById(id uint) (UserResponse, error)
 	getUserByUsername(username string) (entity.User, error)
 	getHashedPasswordByUsername(username string) (userHashedPassword, error)
+	createSession(session *entity.Session) error
+	getSession(id uint) (*entity.Session, error)
+	checkSession(userID, id uint) (*sessionStatus, error)
+	revokeSession(id uint, now time.Time) error
+	revokeUserAccess(userID uint, now time.Time) error
+	deleteStaleSessions(userID uint, before time.Time) error
+	getRefreshToken(hash string) (*entity.RefreshToken, error)
+	rotateRefreshToken(id uint, next *entity.RefreshToken, now time.Time) (bool, error)
+	getUserByEmail(email string) (*entity.User, error)
+	createPasswordResetToken(token *entity.PasswordResetToken) error
+	resetPassword(hash, password string, now time.Time) (*uint, error)
+	getUserEntity(id uint) (*entity.User, error)
+	emailIsUsed(email string, exceptUserID uint) (bool, error)
+	createEmailVerificationToken(token *entity.EmailVerificationToken) error
+	verifyEmail(hash string, now time.Time) (*uint, error)
+	getTOTP(userID uint) (*entity.TOTP, error)
+	replaceTOTP(totp *entity.TOTP) error
+	confirmTOTP(userID uint, step int64, codes []entity.RecoveryCode, now time.Time) error
+	useTOTPStep(userID uint, step int64) (bool, error)
+	useRecoveryCode(userID uint, hash string, now time.Time) (bool, error)
+	failSecondFactor(userID uint) (int, error)
+	lockSecondFactor(userID uint, until time.Time) error
+	resetSecondFactorFailures(userID uint) error
+	deleteTOTP(userID uint) error
+	createMFAChallenge(challenge *entity.MFAChallenge) error
+	getMFAChallenge(hash string) (*entity.MFAChallenge, error)
+	failMFAChallenge(id uint) error
+	completeMFAChallenge(id uint, now time.Time) (bool, error)
+	createProviderSignIn(state *entity.ProviderSignIn) error
+	redeemProviderSignIn(provider, hash, browserHash string, now time.Time) (*entity.ProviderSignIn, error)
+	getUserByIdentity(provider, subject string) (*entity.User, error)
+	linkIdentity(identity *entity.ExternalIdentity) error
+	createUserWithIdentity(user *entity.User, identity *entity.ExternalIdentity) error
+	usernameIsUsed(username string) (bool, error)
+	createLoginCode(code *entity.LoginCode) error
+	redeemLoginCode(hash string, now time.Time) (*uint, error)
+	createAccessToken(token *entity.PersonalAccessToken) error
+	getAccessTokens(userID uint) ([]entity.PersonalAccessToken, error)
+	revokeAccessToken(userID, id uint, now time.Time) (bool, error)
+	authenticateAccessToken(hash string, now time.Time) (*accessTokenStatus, error)
 }
 
 type repository struct {
@@ -25,7 +67,7 @@ func NewAuthRepository(db *gorm.DB, logger log.Logger) *repository {
 	return &repository{db: db, logger: logger}
 }
 
-func (r *repository) createUser(user createUserDTO) error {
+func (r *repository) createUser(user createUserDTO) (*entity.User, error) {
 	newUser := entity.User{
 		Username:     user.Username,
 		Password:     user.Password,
@@ -36,10 +78,10 @@ func (r *repository) createUser(user createUserDTO) error {
 	}
 
 	if err := r.db.Create(&newUser).Error; err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return &newUser, nil
 }
 
 func (r *repository) getUserByUsername(username string) (entity.User, error) {
@@ -89,3 +131,548 @@ func (r *repository) getUserById(id uint) (UserResponse, error) {
 
 	return user, nil
 }
+
+// createSession creates the session together with its first refresh token
+func (r *repository) createSession(session *entity.Session) error {
+	return r.db.Create(session).Error
+}
+
+func (r *repository) getSession(id uint) (*entity.Session, error) {
+	var session entity.Session
+
+	if err := r.db.First(&session, id).Error; err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// checkSession returns nil when the session is revoked or not of the user
+func (r *repository) checkSession(userID, id uint) (*sessionStatus, error) {
+	var statuses []sessionStatus
+
+	if err := r.db.Model(&entity.Session{}).
+		Select("users.email_verified_at IS NOT NULL AS email_verified").
+		Joins("JOIN users ON users.id = sessions.user_id AND users.deleted_at IS NULL").
+		Where("sessions.id = ? AND sessions.user_id = ? AND sessions.revoked_at IS NULL", id, userID).
+		Limit(1).
+		Scan(&statuses).Error; err != nil {
+		return nil, err
+	}
+
+	if len(statuses) == 0 {
+		return nil, nil
+	}
+
+	return &statuses[0], nil
+}
+
+func (r *repository) revokeSession(id uint, now time.Time) error {
+	return r.db.Model(&entity.Session{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", now).Error
+}
+
+// revokeUserAccess revokes every session and personal access token of the user
+func (r *repository) revokeUserAccess(userID uint, now time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return revokeUserAccess(tx, userID, now)
+	})
+}
+
+func revokeUserAccess(tx *gorm.DB, userID uint, now time.Time) error {
+	if err := tx.Model(&entity.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+
+	return tx.Model(&entity.PersonalAccessToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error
+}
+
+// deleteStaleSessions deletes the sessions not refreshed since before, their refresh tokens have
+// expired. The refresh tokens are deleted by the cascade
+func (r *repository) deleteStaleSessions(userID uint, before time.Time) error {
+	return r.db.Where("user_id = ? AND last_used_at < ?", userID, before).Delete(&entity.Session{}).Error
+}
+
+func (r *repository) getRefreshToken(hash string) (*entity.RefreshToken, error) {
+	var token entity.RefreshToken
+
+	if err := r.db.Where("hash = ?", hash).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// rotateRefreshToken marks the token used and creates the next one of the session. The token is
+// marked only if it is still unused, so of two concurrent refreshes one gets false
+func (r *repository) rotateRefreshToken(id uint, next *entity.RefreshToken, now time.Time) (bool, error) {
+	rotated := false
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&entity.RefreshToken{}).
+			Where("id = ? AND used_at IS NULL", id).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		if err := tx.Create(next).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&entity.Session{}).Where("id = ?", next.SessionID).Update("last_used_at", now).Error; err != nil {
+			return err
+		}
+
+		rotated = true
+		return nil
+	})
+
+	return rotated, err
+}
+
+// getUserByEmail returns nil when no user has the email
+func (r *repository) getUserByEmail(email string) (*entity.User, error) {
+	var user entity.User
+
+	if err := r.db.Where("LOWER(email) = LOWER(?)", email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (r *repository) createPasswordResetToken(token *entity.PasswordResetToken) error {
+	return r.db.Create(token).Error
+}
+
+// resetPassword redeems the reset token and, in the same transaction, sets the password, voids the
+// other reset tokens of the user and revokes the sessions and the personal access tokens. It returns nil when the token is unknown,
+// used or expired
+func (r *repository) resetPassword(hash, password string, now time.Time) (*uint, error) {
+	var userID *uint
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var tokens []entity.PasswordResetToken
+
+		result := tx.Model(&tokens).
+			Clauses(clause.Returning{Columns: []clause.Column{{Name: "user_id"}}}).
+			Where("hash = ? AND used_at IS NULL AND expires_at > ?", hash, now).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if len(tokens) == 0 {
+			return nil
+		}
+
+		id := tokens[0].UserID
+
+		if err := tx.Model(&entity.User{}).Where("id = ?", id).Update("password", password).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&entity.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", id).
+			Update("used_at", now).Error; err != nil {
+			return err
+		}
+
+		if err := revokeUserAccess(tx, id, now); err != nil {
+			return err
+		}
+
+		userID = &id
+		return nil
+	})
+
+	return userID, err
+}
+
+func (r *repository) getUserEntity(id uint) (*entity.User, error) {
+	var user entity.User
+
+	if err := r.db.First(&user, id).Error; err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (r *repository) emailIsUsed(email string, exceptUserID uint) (bool, error) {
+	var count int64
+
+	if err := r.db.Model(&entity.User{}).
+		Where("LOWER(email) = LOWER(?) AND id <> ?", email, exceptUserID).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (r *repository) createEmailVerificationToken(token *entity.EmailVerificationToken) error {
+	return r.db.Create(token).Error
+}
+
+// verifyEmail redeems the verification token and makes its address the verified email of the user,
+// in one transaction. It returns nil when the token is unknown, used or expired, and errEmailUsed when
+// another account took the address since the link was sent
+func (r *repository) verifyEmail(hash string, now time.Time) (*uint, error) {
+	var userID *uint
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var tokens []entity.EmailVerificationToken
+
+		result := tx.Model(&tokens).
+			Clauses(clause.Returning{Columns: []clause.Column{{Name: "user_id"}, {Name: "email"}}}).
+			Where("hash = ? AND used_at IS NULL AND expires_at > ?", hash, now).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if len(tokens) == 0 {
+			return nil
+		}
+
+		token := tokens[0]
+
+		var used int64
+		if err := tx.Model(&entity.User{}).
+			Where("LOWER(email) = LOWER(?) AND id <> ?", token.Email, token.UserID).
+			Count(&used).Error; err != nil {
+			return err
+		}
+
+		// The token stays unused, the link works again if the address is freed before it expires
+		if used > 0 {
+			return errEmailUsed
+		}
+
+		if err := tx.Model(&entity.User{}).Where("id = ?", token.UserID).Updates(map[string]interface{}{
+			"email":             token.Email,
+			"email_verified_at": now,
+		}).Error; err != nil {
+			return err
+		}
+
+		// The links sent before for another address of the user are of no use anymore
+		if err := tx.Model(&entity.EmailVerificationToken{}).
+			Where("user_id = ? AND used_at IS NULL", token.UserID).
+			Update("used_at", now).Error; err != nil {
+			return err
+		}
+
+		userID = &token.UserID
+		return nil
+	})
+
+	return userID, err
+}
+
+// getTOTP returns nil when the user has no TOTP, confirmed or not
+func (r *repository) getTOTP(userID uint) (*entity.TOTP, error) {
+	var totp entity.TOTP
+
+	if err := r.db.Where("user_id = ?", userID).First(&totp).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &totp, nil
+}
+
+// replaceTOTP replaces the unconfirmed TOTP of the user, if one was left by an earlier enrolment
+func (r *repository) replaceTOTP(totp *entity.TOTP) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND confirmed_at IS NULL", totp.UserID).Delete(&entity.TOTP{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(totp).Error
+	})
+}
+
+// confirmTOTP enables the TOTP of the user with new recovery codes, the first code is used already
+func (r *repository) confirmTOTP(userID uint, step int64, codes []entity.RecoveryCode, now time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&entity.TOTP{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+			"confirmed_at":   now,
+			"last_used_step": step,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&entity.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&codes).Error
+	})
+}
+
+// useTOTPStep records the step of a valid code. It returns false when a code of this step or a later
+// one was used already, the code is being replayed
+func (r *repository) useTOTPStep(userID uint, step int64) (bool, error) {
+	result := r.db.Model(&entity.TOTP{}).
+		Where("user_id = ? AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+
+	return result.RowsAffected > 0, result.Error
+}
+
+// useRecoveryCode marks the code used, it returns false when it is not an unused code of the user
+func (r *repository) useRecoveryCode(userID uint, hash string, now time.Time) (bool, error) {
+	result := r.db.Model(&entity.RecoveryCode{}).
+		Where("user_id = ? AND hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", now)
+
+	return result.RowsAffected > 0, result.Error
+}
+
+// failSecondFactor counts a wrong code of the user and returns the failures since the last good one
+func (r *repository) failSecondFactor(userID uint) (int, error) {
+	var totps []entity.TOTP
+
+	if err := r.db.Model(&totps).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "failures"}}}).
+		Where("user_id = ?", userID).
+		Update("failures", gorm.Expr("failures + 1")).Error; err != nil {
+		return 0, err
+	}
+
+	if len(totps) == 0 {
+		return 0, nil
+	}
+
+	return totps[0].Failures, nil
+}
+
+func (r *repository) lockSecondFactor(userID uint, until time.Time) error {
+	return r.db.Model(&entity.TOTP{}).Where("user_id = ?", userID).Update("locked_until", until).Error
+}
+
+func (r *repository) resetSecondFactorFailures(userID uint) error {
+	return r.db.Model(&entity.TOTP{}).
+		Where("user_id = ? AND failures > 0", userID).
+		Updates(map[string]interface{}{"failures": 0, "locked_until": nil}).Error
+}
+
+func (r *repository) deleteTOTP(userID uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&entity.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("user_id = ?", userID).Delete(&entity.TOTP{}).Error
+	})
+}
+
+// createMFAChallenge creates the challenge, cleaning the expired ones
+func (r *repository) createMFAChallenge(challenge *entity.MFAChallenge) error {
+	if err := r.db.Where("expires_at < ?", challenge.CreatedAt).Delete(&entity.MFAChallenge{}).Error; err != nil {
+		r.logger.Errorf("expired mfa challenges could not be deleted: %s", err.Error())
+	}
+
+	return r.db.Create(challenge).Error
+}
+
+func (r *repository) getMFAChallenge(hash string) (*entity.MFAChallenge, error) {
+	var challenge entity.MFAChallenge
+
+	if err := r.db.Where("hash = ?", hash).First(&challenge).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &challenge, nil
+}
+
+func (r *repository) failMFAChallenge(id uint) error {
+	return r.db.Model(&entity.MFAChallenge{}).
+		Where("id = ?", id).
+		Update("attempts", gorm.Expr("attempts + 1")).Error
+}
+
+// completeMFAChallenge marks the challenge used, false when it was used concurrently
+func (r *repository) completeMFAChallenge(id uint, now time.Time) (bool, error) {
+	result := r.db.Model(&entity.MFAChallenge{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", now)
+
+	return result.RowsAffected > 0, result.Error
+}
+
+// createProviderSignIn creates the sign in, cleaning the sign ins never finished
+func (r *repository) createProviderSignIn(state *entity.ProviderSignIn) error {
+	if err := r.db.Where("expires_at < ?", state.CreatedAt).Delete(&entity.ProviderSignIn{}).Error; err != nil {
+		r.logger.Errorf("unfinished provider sign ins could not be deleted: %s", err.Error())
+	}
+
+	return r.db.Create(state).Error
+}
+
+// redeemProviderSignIn deletes the sign in and returns it, in one statement so a callback can't be replayed.
+// It returns nil when the state is unknown, of another provider, of another browser or expired
+func (r *repository) redeemProviderSignIn(provider, hash, browserHash string, now time.Time) (*entity.ProviderSignIn, error) {
+	var states []entity.ProviderSignIn
+
+	if err := r.db.Clauses(clause.Returning{}).
+		Where("provider = ? AND hash = ? AND browser_hash = ? AND expires_at > ?", provider, hash, browserHash, now).
+		Delete(&states).Error; err != nil {
+		return nil, err
+	}
+
+	if len(states) == 0 {
+		return nil, nil
+	}
+
+	return &states[0], nil
+}
+
+// getUserByIdentity returns nil when no user is linked to the subject
+func (r *repository) getUserByIdentity(provider, subject string) (*entity.User, error) {
+	var user entity.User
+
+	if err := r.db.
+		Joins("JOIN external_identities ON external_identities.user_id = users.id").
+		Where("external_identities.provider = ? AND external_identities.subject = ?", provider, subject).
+		First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (r *repository) linkIdentity(identity *entity.ExternalIdentity) error {
+	return r.db.Create(identity).Error
+}
+
+func (r *repository) createUserWithIdentity(user *entity.User, identity *entity.ExternalIdentity) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+
+		identity.UserID = user.ID
+		return tx.Create(identity).Error
+	})
+}
+
+func (r *repository) usernameIsUsed(username string) (bool, error) {
+	var count int64
+
+	if err := r.db.Model(&entity.User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (r *repository) createLoginCode(code *entity.LoginCode) error {
+	if err := r.db.Where("expires_at < ?", code.CreatedAt).Delete(&entity.LoginCode{}).Error; err != nil {
+		r.logger.Errorf("expired login codes could not be deleted: %s", err.Error())
+	}
+
+	return r.db.Create(code).Error
+}
+
+// redeemLoginCode marks the code used and returns its user, nil when it is unknown, used or expired
+func (r *repository) redeemLoginCode(hash string, now time.Time) (*uint, error) {
+	var codes []entity.LoginCode
+
+	result := r.db.Model(&codes).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "user_id"}}}).
+		Where("hash = ? AND used_at IS NULL AND expires_at > ?", hash, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if len(codes) == 0 {
+		return nil, nil
+	}
+
+	return &codes[0].UserID, nil
+}
+
+func (r *repository) createAccessToken(token *entity.PersonalAccessToken) error {
+	return r.db.Create(token).Error
+}
+
+// getAccessTokens returns the tokens of the user not revoked, the expired ones included
+func (r *repository) getAccessTokens(userID uint) ([]entity.PersonalAccessToken, error) {
+	var tokens []entity.PersonalAccessToken
+
+	if err := r.db.Where("user_id = ? AND revoked_at IS NULL", userID).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// revokeAccessToken returns false when the user has no such token
+func (r *repository) revokeAccessToken(userID, id uint, now time.Time) (bool, error) {
+	result := r.db.Model(&entity.PersonalAccessToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", now)
+
+	return result.RowsAffected > 0, result.Error
+}
+
+// authenticateAccessToken returns the user and the scopes of the valid token, nil otherwise. The last
+// use is recorded to the minute, not to write on every request of a busy script
+func (r *repository) authenticateAccessToken(hash string, now time.Time) (*accessTokenStatus, error) {
+	var statuses []accessTokenStatus
+
+	if err := r.db.Model(&entity.PersonalAccessToken{}).
+		Select("personal_access_tokens.user_id, personal_access_tokens.scopes, users.email_verified_at IS NOT NULL AS email_verified").
+		Joins("JOIN users ON users.id = personal_access_tokens.user_id AND users.deleted_at IS NULL").
+		Where("personal_access_tokens.hash = ? AND personal_access_tokens.revoked_at IS NULL", hash).
+		Where("personal_access_tokens.expires_at IS NULL OR personal_access_tokens.expires_at > ?", now).
+		Limit(1).
+		Scan(&statuses).Error; err != nil {
+		return nil, err
+	}
+
+	if len(statuses) == 0 {
+		return nil, nil
+	}
+
+	if err := r.db.Model(&entity.PersonalAccessToken{}).
+		Where("hash = ? AND (last_used_at IS NULL OR last_used_at < ?)", hash, now.Add(-time.Minute)).
+		Update("last_used_at", now).Error; err != nil {
+		r.logger.Errorf("last use of an access token could not be recorded: %s", err.Error())
+	}
+
+	return &statuses[0], nil
+}
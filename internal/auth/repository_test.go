@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emPeeGee/raffinance/pkg/log"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// statement is a query the fake database ran, with its arguments
+type statement struct {
+	query string
+	args  []driver.Value
+}
+
+// fakeDatabase records the statements instead of running them. The RETURNING queries return the
+// user in returning, the others the single value in count
+type fakeDatabase struct {
+	mutex      sync.Mutex
+	returning  uint
+	count      int64
+	statements []statement
+}
+
+func (d *fakeDatabase) Connect(context.Context) (driver.Conn, error) { return &fakeConn{d}, nil }
+func (d *fakeDatabase) Driver() driver.Driver                        { return nil }
+
+func (d *fakeDatabase) record(query string, args []driver.NamedValue) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+
+	d.statements = append(d.statements, statement{query, values})
+}
+
+// find returns the statements which contain every part
+func (d *fakeDatabase) find(parts ...string) []statement {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var found []statement
+	for _, s := range d.statements {
+		matches := true
+		for _, part := range parts {
+			matches = matches && strings.Contains(s.query, part)
+		}
+
+		if matches {
+			found = append(found, s)
+		}
+	}
+
+	return found
+}
+
+type fakeConn struct {
+	db *fakeDatabase
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return fakeTx{}, nil }
+
+func (c *fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+func (c *fakeConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.record(query, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.record(query, args)
+
+	if strings.Contains(query, "RETURNING") {
+		return &fakeRows{columns: []string{"user_id"}, values: [][]driver.Value{{int64(c.db.returning)}}}, nil
+	}
+
+	return &fakeRows{columns: []string{"value"}, values: [][]driver.Value{{c.db.count}}}, nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func newRecordingRepository(t *testing.T, returning uint) (*repository, *fakeDatabase) {
+	t.Helper()
+
+	fake := &fakeDatabase{returning: returning}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(fake)}), &gorm.Config{
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewAuthRepository(db, log.New()), fake
+}
+
+// assertRevoked checks that the sessions and the personal access tokens of the user were revoked
+func assertRevoked(t *testing.T, fake *fakeDatabase, userID uint) {
+	t.Helper()
+
+	for _, table := range []string{`"sessions"`, `"personal_access_tokens"`} {
+		found := fake.find(`UPDATE `+table+` SET "revoked_at"`, "revoked_at IS NULL")
+		if len(found) != 1 {
+			t.Errorf("%d statements revoked the rows of %s, expected 1", len(found), table)
+			continue
+		}
+
+		if !containsValue(found[0].args, int64(userID)) && !containsValue(found[0].args, userID) {
+			t.Errorf("the rows of %s were revoked with %v, expected the user %d", table, found[0].args, userID)
+		}
+	}
+}
+
+func containsValue(values []driver.Value, value driver.Value) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestResetPasswordRevokesTheAccessTokens(t *testing.T) {
+	repo, fake := newRecordingRepository(t, 42)
+
+	userID, err := repo.resetPassword("hash", "password", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if userID == nil || *userID != 42 {
+		t.Fatalf("reset the password of %v, expected the user 42", userID)
+	}
+
+	assertRevoked(t, fake, 42)
+}
+
+func TestRevokeUserAccess(t *testing.T) {
+	repo, fake := newRecordingRepository(t, 0)
+
+	if err := repo.revokeUserAccess(7, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	assertRevoked(t, fake, 7)
+}
+
+func TestVerifyEmail(t *testing.T) {
+	repo, fake := newRecordingRepository(t, 42)
+
+	userID, err := repo.verifyEmail("hash", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if userID == nil || *userID != 42 {
+		t.Fatalf("verified the email of %v, expected the user 42", userID)
+	}
+
+	if found := fake.find(`FROM "users"`, "LOWER(email) = LOWER($1) AND id <> $2"); len(found) != 1 {
+		t.Errorf("%d statements checked that the address is free, expected 1", len(found))
+	}
+
+	if found := fake.find(`UPDATE "users" SET "email"`); len(found) != 1 {
+		t.Errorf("%d statements set the email, expected 1", len(found))
+	}
+}
+
+func TestVerifyEmailRefusesAnAddressTaken(t *testing.T) {
+	repo, fake := newRecordingRepository(t, 42)
+	fake.count = 1
+
+	userID, err := repo.verifyEmail("hash", time.Now())
+	if !errors.Is(err, errEmailUsed) {
+		t.Fatalf("got %v and error %v, expected %v", userID, err, errEmailUsed)
+	}
+
+	if found := fake.find(`UPDATE "users"`); len(found) != 0 {
+		t.Errorf("the email of the user was set although another account has it")
+	}
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/emPeeGee/raffinance/pkg/errorutil"
+	"github.com/emPeeGee/raffinance/pkg/util"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator"
+)
+
+// Scopes lists what a personal access token may be given, the read and the write access of the
+// route groups. Write includes read
+var Scopes = []string{
+	"accounts:read", "accounts:write",
+	"transactions:read", "transactions:write",
+	"categories:read", "categories:write",
+	"tags:read", "tags:write",
+	"contacts:read", "contacts:write",
+	"investments:read", "investments:write",
+	"anomalies:read", "anomalies:write",
+	"digests:read", "digests:write",
+	"webhooks:read", "webhooks:write",
+	"analytics:read",
+}
+
+func ValidateScope(fl validator.FieldLevel) bool {
+	return util.Contains(Scopes, fl.Field().String())
+}
+
+// RequireScope rejects the personal access tokens without the scope of the group: read for the safe
+// methods, write for the others. The session tokens have every scope
+func RequireScope(group string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scopes, ok := getScopes(c)
+		if !ok {
+			return
+		}
+
+		write := group + ":write"
+		needed := write
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			needed = group + ":read"
+		}
+
+		if !util.Contains(scopes, needed) && !util.Contains(scopes, write) {
+			errorutil.Forbidden(c, "the token lacks the scope "+needed, "add the scope to the token")
+			return
+		}
+	}
+}
+
+// RequireSession rejects the personal access tokens, for the routes managing the account itself
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := getScopes(c); ok {
+			errorutil.Forbidden(c, "a personal access token can't be used here", "sign in to do it")
+			return
+		}
+	}
+}
+
+// getScopes returns the scopes of the personal access token of the request, false for a session
+func getScopes(c *gin.Context) ([]string, bool) {
+	value, ok := c.Get(scopesCtx)
+	if !ok {
+		return nil, false
+	}
+
+	scopes, ok := value.([]string)
+	return scopes, ok
+}
+
+// isAccessToken tells a personal access token from a JWT by its prefix
+func isAccessToken(token string) bool {
+	return strings.HasPrefix(token, accessTokenPrefix)
+}
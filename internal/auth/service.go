@@ -1,38 +1,115 @@
 package auth
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"net/url"
+	"strings"
 	"time"
 
+	"github.com/emPeeGee/raffinance/internal/config"
+	"github.com/emPeeGee/raffinance/internal/entity"
 	"github.com/emPeeGee/raffinance/pkg/crypt"
 	"github.com/emPeeGee/raffinance/pkg/log"
+	"github.com/emPeeGee/raffinance/pkg/mail"
+	"github.com/emPeeGee/raffinance/pkg/oidc"
+	"github.com/emPeeGee/raffinance/pkg/ratelimit"
 	"github.com/golang-jwt/jwt/v4"
 )
 
 const (
-	signingKey = "bv646gf930ds^#fg&)Fd_)))*("
-	tokenTTL   = time.Hour * 12
+	passwordResetTTL = time.Hour
+	// passwordResetsPerHour limits the emails sent to an address, whoever asks for them
+	passwordResetsPerHour = 3
+	emailVerificationTTL  = 24 * time.Hour
+	// verificationCooldown is the time between two verification emails to an address
+	verificationCooldown = time.Minute
+)
+
+var (
+	errInvalidRefreshToken     = errors.New("the refresh token is invalid, expired or revoked")
+	errInvalidResetToken       = errors.New("the reset token is invalid, expired or already used")
+	errInvalidVerificationLink = errors.New("the verification token is invalid, expired or already used")
+	errEmailNotVerified        = errors.New("the email is not verified, open the link emailed to you")
+	errEmailUsed               = errors.New("the email is used by another account")
 )
 
 type Service interface {
 	createUser(input createUserDTO) error
-	generateToken(credentials credentialsDTO) (string, error)
+	generateToken(credentials credentialsDTO) (*tokenResponse, *mfaChallengeResponse, error)
+	verifyMFA(mfaToken, code string) (*tokenResponse, error)
+	enrollTOTP(userID uint) (*totpEnrollmentResponse, error)
+	confirmTOTP(userID uint, code string) (*recoveryCodesResponse, error)
+	disableTOTP(userID uint, password, code string) error
+	oidcProviders() []string
+	oidcLoginURL(ctx context.Context, provider string) (string, string, error)
+	oidcCallback(ctx context.Context, provider, code, state, browser string) (string, error)
+	exchangeLoginCode(code string) (*tokenResponse, *mfaChallengeResponse, error)
+	createAccessToken(userID uint, input createAccessTokenDTO) (*createdAccessTokenResponse, error)
+	getAccessTokens(userID uint) ([]accessTokenResponse, error)
+	revokeAccessToken(userID, id uint) error
+	authenticateAccessToken(token string) (*accessTokenStatus, error)
+	refreshToken(refreshToken string) (*tokenResponse, error)
+	logout(sessionID uint) error
+	logoutAll(userID uint) error
+	checkSession(userID, sessionID uint) (*sessionStatus, error)
+	emailVerificationPolicy() string
+	parseToken(accessToken string) (*tokenClaims, error)
+	forgotPassword(email string)
+	resetPassword(token, password string) error
+	verifyEmail(token string) error
+	resendVerification(email string) (time.Duration, error)
+	changeEmail(userID uint, email, password string) (time.Duration, error)
+	jwks() jwksResponse
 	getUserById(id uint) (UserResponse, error)
 }
 
 type service struct {
 	repo   Repository
-	logger log.Logger
+	keys   *Keys
+	cfg    config.Auth
+	sender mail.Sender
+	// resets limits the password reset emails by address
+	resets *ratelimit.Limiter
+	// verifications spaces the verification emails by address
+	verifications *ratelimit.Limiter
+	// providers are the OpenID Connect providers by name
+	providers map[string]*oidc.Provider
+	logger    log.Logger
 }
 
 type tokenClaims struct {
 	jwt.StandardClaims
-	UserId uint `json:"userId"`
+	UserId    uint `json:"userId"`
+	SessionId uint `json:"sid"`
 }
 
-func NewAuthService(repository Repository, logger log.Logger) *service {
-	return &service{repo: repository, logger: logger}
+func NewAuthService(repository Repository, keys *Keys, cfg config.Auth, sender mail.Sender, logger log.Logger) *service {
+	providers := map[string]*oidc.Provider{}
+	for _, provider := range cfg.OIDCProviders {
+		providers[provider.Name] = oidc.NewProvider(oidc.Config{
+			Issuer:       provider.Issuer,
+			ClientID:     provider.ClientID,
+			ClientSecret: provider.ClientSecret,
+			RedirectURL:  provider.RedirectURL,
+			Scopes:       provider.Scopes,
+		})
+	}
+
+	return &service{
+		repo:          repository,
+		keys:          keys,
+		cfg:           cfg,
+		sender:        sender,
+		resets:        ratelimit.New(passwordResetsPerHour, time.Hour),
+		verifications: ratelimit.New(1, verificationCooldown),
+		providers:     providers,
+		logger:        logger,
+	}
 }
 
 func (s *service) createUser(user createUserDTO) error {
@@ -44,47 +121,390 @@ func (s *service) createUser(user createUserDTO) error {
 
 	user.Password = hashedPassword
 
-	err = s.repo.createUser(user)
+	created, err := s.repo.createUser(user)
 	if err != nil {
 		return err
 	}
 
+	s.verifications.Allow(strings.ToLower(created.Email))
+	go func() {
+		if err := s.sendVerification(created, created.Email); err != nil {
+			s.logger.Errorf("verification email of user %d could not be sent: %s", created.ID, err.Error())
+		}
+	}()
+
 	return nil
 }
 
-func (s *service) generateToken(credentials credentialsDTO) (string, error) {
+// generateToken signs the user in by the password. A user with TOTP gets a challenge instead of the
+// tokens, exchanged by verifyMFA with a code
+func (s *service) generateToken(credentials credentialsDTO) (*tokenResponse, *mfaChallengeResponse, error) {
 	hashedPassword, err := s.repo.getHashedPasswordByUsername(credentials.Username)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 
 	ok := crypt.CheckPasswordHashes(credentials.Password, hashedPassword.Password)
 	if !ok {
-		return "", errors.New("password does not match")
+		return nil, nil, errors.New("password does not match")
 	}
 
 	user, err := s.repo.getUserByUsername(credentials.Username)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 
-	if err := s.repo.updateLatestLogins(credentials.Username); err != nil {
-		return "", err
+	return s.signIn(user)
+}
+
+// signIn starts the session of the user authenticated by the first factor, or challenges the second
+func (s *service) signIn(user entity.User) (*tokenResponse, *mfaChallengeResponse, error) {
+	if user.EmailVerifiedAt == nil && s.cfg.EmailVerification == config.EmailVerificationBlock {
+		return nil, nil, errEmailNotVerified
+	}
+
+	totp, err := s.repo.getTOTP(user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if totp != nil && totp.ConfirmedAt != nil {
+		challenge, err := s.challengeMFA(user.ID)
+		return nil, challenge, err
+	}
+
+	token, err := s.startSession(user)
+	return token, nil, err
+}
+
+// startSession creates the session of the signed in user and its first tokens
+func (s *service) startSession(user entity.User) (*tokenResponse, error) {
+	if err := s.repo.updateLatestLogins(user.Username); err != nil {
+		return nil, err
 	}
 
 	str, _ := json.MarshalIndent(user, "", "\t")
 	s.logger.Debug(string(str))
 
-	// TODO: constants to be moved in config
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &tokenClaims{
+	now := time.Now()
+
+	// The sessions which were not refreshed in time can't be anymore, they are cleaned on sign in
+	if err := s.repo.deleteStaleSessions(user.ID, now.Add(-s.cfg.RefreshTokenTTL)); err != nil {
+		s.logger.Errorf("stale sessions of user %d could not be deleted: %s", user.ID, err.Error())
+	}
+
+	refreshToken, hash, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session := entity.Session{
+		UserID:        user.ID,
+		LastUsedAt:    now,
+		RefreshTokens: []entity.RefreshToken{{Hash: hash, ExpiresAt: now.Add(s.cfg.RefreshTokenTTL)}},
+	}
+
+	if err := s.repo.createSession(&session); err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(user.ID, session.ID, refreshToken, now)
+}
+
+// refreshToken exchanges the refresh token for a new access token and the next refresh token.
+// A refresh token already used means it was stolen, by whom used it first or by whom uses it now,
+// so the whole session is revoked
+func (s *service) refreshToken(refreshToken string) (*tokenResponse, error) {
+	now := time.Now()
+
+	current, err := s.repo.getRefreshToken(hashToken(refreshToken))
+	if err != nil {
+		return nil, err
+	}
+
+	if current == nil || current.ExpiresAt.Before(now) {
+		return nil, errInvalidRefreshToken
+	}
+
+	session, err := s.repo.getSession(current.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if session.RevokedAt != nil {
+		return nil, errInvalidRefreshToken
+	}
+
+	next, hash, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	rotated := false
+	if current.UsedAt == nil {
+		rotated, err = s.repo.rotateRefreshToken(current.ID, &entity.RefreshToken{
+			SessionID: session.ID,
+			Hash:      hash,
+			ExpiresAt: now.Add(s.cfg.RefreshTokenTTL),
+		}, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !rotated {
+		s.logger.Infof("refresh token of session %d was reused, the session is revoked", session.ID)
+
+		if err := s.repo.revokeSession(session.ID, now); err != nil {
+			return nil, err
+		}
+
+		return nil, errInvalidRefreshToken
+	}
+
+	return s.issueTokens(session.UserID, session.ID, next, now)
+}
+
+func (s *service) logout(sessionID uint) error {
+	return s.repo.revokeSession(sessionID, time.Now())
+}
+
+func (s *service) logoutAll(userID uint) error {
+	return s.repo.revokeUserAccess(userID, time.Now())
+}
+
+func (s *service) checkSession(userID, sessionID uint) (*sessionStatus, error) {
+	return s.repo.checkSession(userID, sessionID)
+}
+
+func (s *service) emailVerificationPolicy() string {
+	return s.cfg.EmailVerification
+}
+
+// forgotPassword emails a reset link if a user has the email. It works in background and reports
+// nothing, the response can't tell by its content or its timing whether the email exists
+func (s *service) forgotPassword(email string) {
+	go func() {
+		if err := s.sendPasswordReset(strings.ToLower(email)); err != nil {
+			s.logger.Errorf("password reset email could not be sent: %s", err.Error())
+		}
+	}()
+}
+
+func (s *service) sendPasswordReset(email string) error {
+	if ok, _ := s.resets.Allow(email); !ok {
+		s.logger.Infof("too many password resets asked for %s, no email is sent", email)
+		return nil
+	}
+
+	user, err := s.repo.getUserByEmail(email)
+	if err != nil || user == nil {
+		return err
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.createPasswordResetToken(&entity.PasswordResetToken{
+		UserID:    user.ID,
+		Hash:      hash,
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	}); err != nil {
+		return err
+	}
+
+	link, err := withToken(s.cfg.PasswordResetURL, token)
+	if err != nil {
+		return err
+	}
+
+	message, err := renderEmail(passwordResetEmail, user.Email, "Reset your Raffinance password", linkEmail{
+		Name:     user.Name,
+		Link:     link,
+		ValidFor: "1 hour",
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.sender.Send(message)
+}
+
+// resetPassword sets the new password and signs the user out of every session
+func (s *service) resetPassword(token, password string) error {
+	hashedPassword, err := crypt.HashPassphrase(password)
+	if err != nil {
+		return err
+	}
+
+	userID, err := s.repo.resetPassword(hashToken(token), hashedPassword, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if userID == nil {
+		return errInvalidResetToken
+	}
+
+	s.logger.Infof("password of user %d was reset, the sessions are revoked", *userID)
+	return nil
+}
+
+func (s *service) verifyEmail(token string) error {
+	userID, err := s.repo.verifyEmail(hashToken(token), time.Now())
+	if err != nil {
+		return err
+	}
+
+	if userID == nil {
+		return errInvalidVerificationLink
+	}
+
+	return nil
+}
+
+// resendVerification emails a new link if a user has the unverified email, in background like
+// forgotPassword. Only the cooldown of the address is reported, with the time left
+func (s *service) resendVerification(email string) (time.Duration, error) {
+	email = strings.ToLower(email)
+
+	if ok, retryAfter := s.verifications.Allow(email); !ok {
+		return retryAfter, nil
+	}
+
+	go func() {
+		user, err := s.repo.getUserByEmail(email)
+		if err == nil && user != nil && user.EmailVerifiedAt == nil {
+			err = s.sendVerification(user, user.Email)
+		}
+
+		if err != nil {
+			s.logger.Errorf("verification email could not be sent: %s", err.Error())
+		}
+	}()
+
+	return 0, nil
+}
+
+// changeEmail emails a verification link to the new address. The user keeps the current email
+// until the new one is verified
+func (s *service) changeEmail(userID uint, email, password string) (time.Duration, error) {
+	user, err := s.repo.getUserEntity(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if !crypt.CheckPasswordHashes(password, user.Password) {
+		return 0, errors.New("password does not match")
+	}
+
+	used, err := s.repo.emailIsUsed(email, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if used {
+		return 0, errEmailUsed
+	}
+
+	if ok, retryAfter := s.verifications.Allow(strings.ToLower(email)); !ok {
+		return retryAfter, nil
+	}
+
+	return 0, s.sendVerification(user, email)
+}
+
+// sendVerification emails the link verifying the address to it
+func (s *service) sendVerification(user *entity.User, email string) error {
+	token, hash, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.createEmailVerificationToken(&entity.EmailVerificationToken{
+		UserID:    user.ID,
+		Email:     email,
+		Hash:      hash,
+		ExpiresAt: time.Now().Add(emailVerificationTTL),
+	}); err != nil {
+		return err
+	}
+
+	link, err := withToken(s.cfg.EmailVerificationURL, token)
+	if err != nil {
+		return err
+	}
+
+	message, err := renderEmail(emailVerificationEmail, email, "Verify your Raffinance email", linkEmail{
+		Name:     user.Name,
+		Link:     link,
+		ValidFor: "24 hours",
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.sender.Send(message)
+}
+
+func (s *service) parseToken(accessToken string) (*tokenClaims, error) {
+	return s.keys.parse(accessToken)
+}
+
+func (s *service) jwks() jwksResponse {
+	return s.keys.jwks()
+}
+
+// issueTokens signs the access token of the session and pairs it with its refresh token
+func (s *service) issueTokens(userID, sessionID uint, refreshToken string, now time.Time) (*tokenResponse, error) {
+	expiresAt := now.Add(s.cfg.AccessTokenTTL)
+
+	signed, err := s.keys.sign(&tokenClaims{
 		jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(tokenTTL).Unix(),
-			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: expiresAt.Unix(),
+			IssuedAt:  now.Unix(),
 		},
-		user.ID,
+		userID,
+		sessionID,
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenResponse{Token: signed, RefreshToken: refreshToken, ExpiresAt: expiresAt}, nil
+}
+
+// newToken returns a random token and the hash it is stored by, for the refresh and the
+// one time tokens
+func newToken() (string, string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", "", err
+	}
+
+	token := hex.EncodeToString(bytes)
+	return token, hashToken(token), nil
+}
+
+// withToken adds the token to the query of the client page
+func withToken(page, token string) (string, error) {
+	link, err := url.Parse(page)
+	if err != nil {
+		return "", err
+	}
+
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return link.String(), nil
+}
 
-	return token.SignedString([]byte(signingKey))
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 func (s *service) getUserById(id uint) (UserResponse, error) {
@@ -1,11 +1,9 @@
 package auth
 
 import (
-	"errors"
 	"fmt"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
 )
 
 const (
@@ -13,39 +11,32 @@ const (
 	tokenClaimsErrorMsg   = "token claims are not of type *tokenClaims"
 )
 
-// extractUserIdFromToken parses the provided access token and returns the user ID if successful
-func extractUserIdFromToken(accessToken string) (*uint, error) {
-	token, err := jwt.ParseWithClaims(accessToken, &tokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New(signingMethodErrorMsg)
-		}
-
-		return []byte(signingKey), nil
-	})
-
-	if err != nil {
-		return nil, err
+// TODO: to be added associations and checking jwt
+// GetUserId tries to get the user id from context and return it if successful
+func GetUserId(c *gin.Context) (*uint, error) {
+	id, ok := c.Get(userCtx)
+	if !ok {
+		return nil, nil
 	}
 
-	claims, ok := token.Claims.(*tokenClaims)
+	idInt, ok := id.(uint)
 	if !ok {
-		return nil, errors.New(tokenClaimsErrorMsg)
+		return nil, fmt.Errorf("user ID is of invalid type: %v", id)
 	}
 
-	return &claims.UserId, nil
+	return &idInt, nil
 }
 
-// TODO: to be added associations and checking jwt
-// GetUserId tries to get the user id from context and return it if successful
-func GetUserId(c *gin.Context) (*uint, error) {
-	id, ok := c.Get(userCtx)
+// GetSessionId tries to get the session id of the access token from context and return it if successful
+func GetSessionId(c *gin.Context) (*uint, error) {
+	id, ok := c.Get(sessionCtx)
 	if !ok {
 		return nil, nil
 	}
 
 	idInt, ok := id.(uint)
 	if !ok {
-		return nil, fmt.Errorf("user ID is of invalid type: %v", id)
+		return nil, fmt.Errorf("session ID is of invalid type: %v", id)
 	}
 
 	return &idInt, nil
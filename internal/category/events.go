@@ -0,0 +1,25 @@
+package category
+
+type CategoryCreated struct {
+	UserID   uint             `json:"-"`
+	Category categoryResponse `json:"category"`
+}
+
+func (e CategoryCreated) EventName() string { return "category.created" }
+func (e CategoryCreated) EventUserID() uint { return e.UserID }
+
+type CategoryUpdated struct {
+	UserID   uint             `json:"-"`
+	Category categoryResponse `json:"category"`
+}
+
+func (e CategoryUpdated) EventName() string { return "category.updated" }
+func (e CategoryUpdated) EventUserID() uint { return e.UserID }
+
+type CategoryDeleted struct {
+	UserID     uint `json:"-"`
+	CategoryID uint `json:"categoryId"`
+}
+
+func (e CategoryDeleted) EventName() string { return "category.deleted" }
+func (e CategoryDeleted) EventUserID() uint { return e.UserID }
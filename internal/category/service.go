@@ -3,6 +3,7 @@ package category
 import (
 	"fmt"
 
+	"github.com/emPeeGee/raffinance/internal/event"
 	"github.com/emPeeGee/raffinance/pkg/log"
 )
 
@@ -16,10 +17,11 @@ type Service interface {
 type service struct {
 	repo   Repository
 	logger log.Logger
+	events event.Publisher
 }
 
-func NewCategoryService(repo Repository, logger log.Logger) *service {
-	return &service{repo: repo, logger: logger}
+func NewCategoryService(repo Repository, logger log.Logger, events event.Publisher) *service {
+	return &service{repo: repo, logger: logger, events: events}
 }
 
 func (s *service) createCategory(userID uint, category createCategoryDTO) (*categoryResponse, error) {
@@ -37,7 +39,16 @@ func (s *service) createCategory(userID uint, category createCategoryDTO) (*cate
 		return nil, fmt.Errorf("category with name %s exists", category.Name)
 	}
 
-	return s.repo.createCategory(userID, category)
+	created, err := s.repo.createCategory(userID, category)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.events.Publish(CategoryCreated{UserID: userID, Category: *created}); err != nil {
+		return nil, err
+	}
+
+	return created, nil
 }
 
 func (s *service) deleteCategory(userId, id uint) error {
@@ -54,7 +65,11 @@ func (s *service) deleteCategory(userId, id uint) error {
 		return err
 	}
 
-	return s.repo.deleteCategory(userId, id)
+	if err := s.repo.deleteCategory(userId, id); err != nil {
+		return err
+	}
+
+	return s.events.Publish(CategoryDeleted{UserID: userId, CategoryID: id})
 }
 
 func (s *service) getCategories(userId uint) ([]categoryResponse, error) {
@@ -86,5 +101,14 @@ func (s *service) updateCategory(userID, categoryId uint, category updateCategor
 		return nil, fmt.Errorf("category with name %s already exists", category.Name)
 	}
 
-	return s.repo.updateCategory(userID, categoryId, category)
+	updated, err := s.repo.updateCategory(userID, categoryId, category)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.events.Publish(CategoryUpdated{UserID: userID, Category: *updated}); err != nil {
+		return nil, err
+	}
+
+	return updated, nil
 }
@@ -0,0 +1,136 @@
+package category
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/emPeeGee/raffinance/internal/event/eventtest"
+	"github.com/emPeeGee/raffinance/pkg/log"
+)
+
+// fakeRepository keeps the categories of a single user in memory
+type fakeRepository struct {
+	categories map[uint]categoryResponse
+	nextID     uint
+	used       map[uint]bool
+}
+
+func newFakeRepository() *fakeRepository {
+	return &fakeRepository{categories: map[uint]categoryResponse{}, nextID: 1, used: map[uint]bool{}}
+}
+
+func (r *fakeRepository) getCategories(uint) ([]categoryResponse, error) {
+	var categories []categoryResponse
+	for _, c := range r.categories {
+		categories = append(categories, c)
+	}
+
+	return categories, nil
+}
+
+func (r *fakeRepository) createCategory(_ uint, category createCategoryDTO) (*categoryResponse, error) {
+	created := categoryResponse{ID: r.nextID, Name: category.Name, Color: category.Color, Icon: category.Icon}
+	r.categories[created.ID] = created
+	r.nextID++
+
+	return &created, nil
+}
+
+func (r *fakeRepository) updateCategory(_, id uint, category updateCategoryDTO) (*categoryResponse, error) {
+	updated := categoryResponse{ID: id, Name: category.Name, Color: category.Color, Icon: category.Icon}
+	r.categories[id] = updated
+
+	return &updated, nil
+}
+
+func (r *fakeRepository) deleteCategory(_, id uint) error {
+	delete(r.categories, id)
+	return nil
+}
+
+func (r *fakeRepository) categoryExistsAndBelongsToUser(_, id uint, name string) (bool, error) {
+	if id > 0 {
+		_, ok := r.categories[id]
+		return ok, nil
+	}
+
+	for _, c := range r.categories {
+		if c.Name == name {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (r *fakeRepository) categoryIsUsed(id uint) error {
+	if r.used[id] {
+		return errors.New("category is used")
+	}
+
+	return nil
+}
+
+func TestCategoryLifecyclePublishesEvents(t *testing.T) {
+	events := eventtest.NewRecorder()
+	s := NewCategoryService(newFakeRepository(), log.New(), events)
+
+	created, err := s.createCategory(1, createCategoryDTO{Name: "Groceries", Color: "#00ff00", Icon: "cart"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.updateCategory(1, created.ID, updateCategoryDTO{Name: "Food", Color: "#00ff00", Icon: "cart"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.deleteCategory(1, created.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	eventtest.AssertPublished(t, events, "category.created", "category.updated", "category.deleted")
+
+	updated := eventtest.Find[CategoryUpdated](events)
+	if len(updated) != 1 || updated[0].UserID != 1 || updated[0].Category.Name != "Food" {
+		t.Errorf("category.updated carries %+v", updated)
+	}
+
+	deleted := eventtest.Find[CategoryDeleted](events)
+	if len(deleted) != 1 || deleted[0].CategoryID != created.ID {
+		t.Errorf("category.deleted carries %+v", deleted)
+	}
+}
+
+func TestCategoryNotPublishedWhenRejected(t *testing.T) {
+	events := eventtest.NewRecorder()
+	repo := newFakeRepository()
+	s := NewCategoryService(repo, log.New(), events)
+
+	created, err := s.createCategory(1, createCategoryDTO{Name: "Rent", Color: "#ff0000", Icon: "home"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events.Reset()
+
+	if _, err := s.createCategory(1, createCategoryDTO{Name: "Rent", Color: "#ff0000", Icon: "home"}); err == nil {
+		t.Error("a duplicate name was accepted")
+	}
+
+	repo.used[created.ID] = true
+	if err := s.deleteCategory(1, created.ID); err == nil {
+		t.Error("a used category was deleted")
+	}
+
+	eventtest.AssertPublished(t, events)
+	eventtest.AssertNotPublished(t, events, "category.deleted")
+}
+
+func TestCategoryPublishFailureIsReturned(t *testing.T) {
+	events := eventtest.NewRecorder()
+	events.Err = errors.New("subscriber failed")
+	s := NewCategoryService(newFakeRepository(), log.New(), events)
+
+	if _, err := s.createCategory(1, createCategoryDTO{Name: "Travel", Color: "#0000ff", Icon: "plane"}); !errors.Is(err, events.Err) {
+		t.Errorf("got %v, expected the error of the subscriber", err)
+	}
+}
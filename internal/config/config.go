@@ -1,7 +1,9 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/emPeeGee/raffinance/pkg/log"
@@ -14,13 +16,29 @@ const (
 	defaultMaxHeaderBytes = 1 << 20 // 1 MB
 	defaultReadTimeout    = 10 * time.Second
 	defaultWriteTimeout   = 10 * time.Second
+	defaultAccessTokenTTL = 15 * time.Minute
+	defaultRefreshTTL     = 30 * 24 * time.Hour
 	path                  = "configs"
 	fileName              = "config"
 )
 
+// The email verification policies, for the users who did not verify their email yet
+const (
+	// EmailVerificationOff lets them use everything
+	EmailVerificationOff = "off"
+	// EmailVerificationRestrict lets them sign in and read, but not change anything
+	EmailVerificationRestrict = "restrict"
+	// EmailVerificationBlock doesn't let them sign in
+	EmailVerificationBlock = "block"
+)
+
 type Config struct {
 	Server
 	DB
+	Mail
+	Websocket
+	Auth
+	Webhook
 }
 
 type Server struct {
@@ -28,6 +46,67 @@ type Server struct {
 	MaxHeaderBytes int
 	ReadTimeout    time.Duration
 	WriteTimeout   time.Duration
+	// TrustedProxies are the proxies whose X-Forwarded-For gives the client IP, none by default so a
+	// client can't choose its IP and escape the rate limits
+	TrustedProxies []string
+}
+
+// Mail is the SMTP server the emails are sent through, without host they are only logged
+type Mail struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+type Websocket struct {
+	// AllowedOrigins are the origins of the browsers which may open a websocket
+	AllowedOrigins []string
+	// Backend carries the real-time messages between the instances, memory or postgres
+	Backend string
+}
+
+type Webhook struct {
+	// AllowPrivateURLs lets the webhooks call the loopback and private addresses, for development
+	// only, they would reach the internal services of the server
+	AllowPrivateURLs bool
+}
+
+// Auth configures the tokens
+type Auth struct {
+	// Keys are the files of the keys verifying the access tokens by their kid. PEM keys are for RS256
+	// and EdDSA, a public key only verifies, any other content is a HS256 secret
+	Keys map[string]string
+	// RandomKey signs the access tokens by a random key when there are no keys, for development only.
+	// Its tokens don't survive a restart and are rejected by the other instances
+	RandomKey bool
+	// SigningKeyID is the kid of the key signing the new access tokens
+	SigningKeyID    string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// PasswordResetURL is the page of the client resetting the password, the token is added to it
+	PasswordResetURL string
+	// EmailVerification is the policy for the users with an unverified email
+	EmailVerification string
+	// EmailVerificationURL is the page of the client verifying the email, the token is added to it
+	EmailVerificationURL string
+	// OIDCProviders are the identity providers the users may sign in with
+	OIDCProviders []OIDCProvider
+	// OIDCClientURL is the page of the client the sign in with a provider ends on, with a login code
+	// or an error added to it
+	OIDCClientURL string
+}
+
+// OIDCProvider is an OpenID Connect identity provider, named in the routes
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback of the server registered at the provider
+	RedirectURL string
+	Scopes      []string
 }
 
 type DB struct {
@@ -68,10 +147,182 @@ func Get(logger log.Logger) (*Config, error) {
 		MaxHeaderBytes: defaultMaxHeaderBytes,
 		ReadTimeout:    defaultReadTimeout,
 		WriteTimeout:   defaultWriteTimeout,
+		TrustedProxies: splitList(os.Getenv("TRUSTED_PROXIES")),
+	}
+
+	mail := Mail{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     os.Getenv("SMTP_PORT"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("MAIL_FROM"),
+	}
+
+	if mail.Port == "" {
+		mail.Port = "25"
+	}
+
+	if mail.From == "" {
+		mail.From = "raffinance@localhost"
+	}
+
+	websocket := Websocket{
+		AllowedOrigins: splitList(os.Getenv("WS_ALLOWED_ORIGINS")),
+		Backend:        os.Getenv("HUB_BACKEND"),
+	}
+
+	if len(websocket.AllowedOrigins) == 0 {
+		websocket.AllowedOrigins = []string{"http://localhost:3000"}
+	}
+
+	if websocket.Backend == "" {
+		websocket.Backend = "memory"
+	}
+
+	auth, err := getAuth(server.Addr)
+	if err != nil {
+		logger.Errorf("Error reading the auth config: %s", err.Error())
+		return nil, err
+	}
+
+	webhook := Webhook{
+		AllowPrivateURLs: os.Getenv("WEBHOOK_ALLOW_PRIVATE_URLS") == "true",
+	}
+
+	return &Config{server, db, mail, websocket, *auth, webhook}, nil
+
+}
+
+func getAuth(addr string) (*Auth, error) {
+	auth := Auth{
+		Keys:                 map[string]string{},
+		SigningKeyID:         os.Getenv("JWT_SIGNING_KEY_ID"),
+		AccessTokenTTL:       defaultAccessTokenTTL,
+		RefreshTokenTTL:      defaultRefreshTTL,
+		PasswordResetURL:     os.Getenv("PASSWORD_RESET_URL"),
+		EmailVerification:    os.Getenv("EMAIL_VERIFICATION"),
+		EmailVerificationURL: os.Getenv("EMAIL_VERIFICATION_URL"),
+		OIDCClientURL:        os.Getenv("OIDC_CLIENT_URL"),
+	}
+
+	// The pages of the web client by default
+	if auth.PasswordResetURL == "" {
+		auth.PasswordResetURL = "http://localhost:3000/resetPassword"
+	}
+
+	if auth.EmailVerificationURL == "" {
+		auth.EmailVerificationURL = "http://localhost:3000/verifyEmail"
+	}
+
+	if auth.OIDCClientURL == "" {
+		auth.OIDCClientURL = "http://localhost:3000/oidc/callback"
+	}
+
+	providers, err := getOIDCProviders(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	auth.OIDCProviders = providers
+
+	switch auth.EmailVerification {
+	case "":
+		auth.EmailVerification = EmailVerificationOff
+	case EmailVerificationOff, EmailVerificationRestrict, EmailVerificationBlock:
+	default:
+		return nil, fmt.Errorf("EMAIL_VERIFICATION %q is not off, restrict or block", auth.EmailVerification)
+	}
+
+	for _, item := range splitList(os.Getenv("JWT_KEYS")) {
+		kid, path, ok := strings.Cut(item, "=")
+		if !ok || kid == "" || path == "" {
+			return nil, fmt.Errorf("JWT_KEYS item %q is not kid=path", item)
+		}
+
+		auth.Keys[kid] = path
+	}
+
+	auth.RandomKey = os.Getenv("JWT_RANDOM_KEY") == "true"
+
+	if len(auth.Keys) == 0 && !auth.RandomKey {
+		return nil, fmt.Errorf("JWT_KEYS is not set, set JWT_RANDOM_KEY=true to sign by a random key in development")
+	}
+
+	if auth.SigningKeyID == "" && len(auth.Keys) == 1 {
+		for kid := range auth.Keys {
+			auth.SigningKeyID = kid
+		}
+	}
+
+	if _, ok := auth.Keys[auth.SigningKeyID]; len(auth.Keys) > 0 && !ok {
+		return nil, fmt.Errorf("JWT_SIGNING_KEY_ID %q is not one of JWT_KEYS", auth.SigningKeyID)
+	}
+
+	if ttl := os.Getenv("JWT_ACCESS_TTL"); ttl != "" {
+		duration, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("JWT_ACCESS_TTL: %w", err)
+		}
+
+		auth.AccessTokenTTL = duration
+	}
+
+	if ttl := os.Getenv("JWT_REFRESH_TTL"); ttl != "" {
+		duration, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("JWT_REFRESH_TTL: %w", err)
+		}
+
+		auth.RefreshTokenTTL = duration
+	}
+
+	return &auth, nil
+}
+
+// getOIDCProviders reads the providers named by OIDC_PROVIDERS, each from its OIDC_<NAME>_* variables
+func getOIDCProviders(addr string) ([]OIDCProvider, error) {
+	var providers []OIDCProvider
+
+	for _, name := range splitList(os.Getenv("OIDC_PROVIDERS")) {
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+
+		provider := OIDCProvider{
+			Name:         strings.ToLower(name),
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}
+
+		if provider.Issuer == "" || provider.ClientID == "" {
+			return nil, fmt.Errorf("OIDC provider %s needs %sISSUER and %sCLIENT_ID", name, prefix, prefix)
+		}
+
+		if provider.RedirectURL == "" {
+			provider.RedirectURL = "http://localhost" + addr + "/auth/oidc/" + provider.Name + "/callback"
+		}
+
+		if len(provider.Scopes) == 0 {
+			provider.Scopes = []string{"openid", "email", "profile"}
+		}
+
+		providers = append(providers, provider)
 	}
 
-	return &Config{server, db}, nil
+	return providers, nil
+}
+
+// splitList splits a comma separated variable, ignoring the blanks
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
 
+	return items
 }
 
 // TODO:
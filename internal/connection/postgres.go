@@ -9,8 +9,13 @@ import (
 	"gorm.io/gorm/logger"
 )
 
+// DSN is the connection string of the database, the listeners need it besides gorm
+func DSN(cfg config.DB) string {
+	return fmt.Sprintf("host=%s port=%s user=%s dbname=%s password=%s", cfg.Host, cfg.Port, cfg.Username, cfg.Name, cfg.Password)
+}
+
 func NewPostgresDB(cfg config.DB) (*gorm.DB, error) {
-	dsn := fmt.Sprintf("host=%s port=%s user=%s dbname=%s password=%s", cfg.Host, cfg.Port, cfg.Username, cfg.Name, cfg.Password)
+	dsn := DSN(cfg)
 	fmt.Println(dsn)
 
 	gormDB, err := gorm.Open(postgres.New(postgres.Config{
@@ -0,0 +1,99 @@
+package digest
+
+import (
+	"net/http"
+
+	"github.com/emPeeGee/raffinance/internal/auth"
+	"github.com/emPeeGee/raffinance/pkg/errorutil"
+	"github.com/emPeeGee/raffinance/pkg/log"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator"
+)
+
+func RegisterHandlers(apiRg *gin.RouterGroup, service Service, validate *validator.Validate, logger log.Logger) {
+	h := handler{service, logger, validate}
+
+	api := apiRg.Group("/digest")
+	{
+		api.GET("/preferences", h.getPreference)
+		api.PUT("/preferences", h.updatePreference)
+		api.POST("/test", h.sendTestDigest)
+	}
+}
+
+type handler struct {
+	service  Service
+	logger   log.Logger
+	validate *validator.Validate
+}
+
+func (h *handler) getPreference(c *gin.Context) {
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	preference, err := h.service.getPreference(*userId)
+	if err != nil {
+		errorutil.InternalServer(c, "something went wrong, we are working", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, preference)
+}
+
+func (h *handler) updatePreference(c *gin.Context) {
+	var input updatePreferenceDTO
+
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	if err := c.BindJSON(&input); err != nil {
+		errorutil.BadRequest(c, "your request looks incorrect", err.Error())
+		return
+	}
+
+	if err := h.validate.Struct(input); err != nil {
+		errorutil.BadRequest(c, "your request did not pass validation", err.Error())
+		return
+	}
+
+	preference, err := h.service.updatePreference(*userId, input)
+	if err != nil {
+		errorutil.InternalServer(c, "something went wrong, we are working", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, preference)
+}
+
+func (h *handler) sendTestDigest(c *gin.Context) {
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	var params testDigestParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	if params.Period == "" {
+		params.Period = WEEKLY
+	}
+
+	if err := h.service.sendTestDigest(*userId, params.Period); err != nil {
+		errorutil.InternalServer(c, "the digest could not be sent", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"ok": true,
+	})
+}
@@ -0,0 +1,72 @@
+package digest
+
+import "time"
+
+type Period string
+
+const (
+	WEEKLY  Period = "weekly"
+	MONTHLY Period = "monthly"
+)
+
+type preferenceResponse struct {
+	Weekly        bool       `json:"weekly"`
+	Monthly       bool       `json:"monthly"`
+	LastWeeklyAt  *time.Time `json:"lastWeeklyAt"`
+	LastMonthlyAt *time.Time `json:"lastMonthlyAt"`
+}
+
+type updatePreferenceDTO struct {
+	Weekly  *bool `json:"weekly" validate:"required"`
+	Monthly *bool `json:"monthly" validate:"required"`
+}
+
+type testDigestParams struct {
+	Period Period `form:"period" binding:"omitempty,oneof=weekly monthly"`
+}
+
+type recipient struct {
+	ID    uint
+	Name  string
+	Email string
+}
+
+type categoryAmount struct {
+	Name   string
+	Amount float64
+}
+
+type largeTransaction struct {
+	Date        time.Time
+	Description string
+	Category    string
+	Amount      float64
+}
+
+type accountBalance struct {
+	Name     string
+	Currency string
+	Balance  float64
+}
+
+// Digest is the content of a digest email, the range end is exclusive
+type Digest struct {
+	Recipient    recipient
+	Period       Period
+	Start        time.Time
+	End          time.Time
+	Income       float64
+	Expense      float64
+	Categories   []categoryAmount
+	Transactions []largeTransaction
+	Accounts     []accountBalance
+}
+
+// LastDay is the last day covered by the digest
+func (d Digest) LastDay() time.Time {
+	return d.End.AddDate(0, 0, -1)
+}
+
+func (d Digest) Net() float64 {
+	return d.Income - d.Expense
+}
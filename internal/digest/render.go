@@ -0,0 +1,50 @@
+package digest
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"strconv"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/emPeeGee/raffinance/pkg/mail"
+)
+
+//go:embed templates
+var templatesFS embed.FS
+
+var funcs = map[string]any{
+	"amount": func(value float64) string { return strconv.FormatFloat(value, 'f', 2, 64) },
+	"date":   func(t time.Time) string { return t.Format("02 Jan 2006") },
+}
+
+var (
+	htmlTemplate = htmltemplate.Must(htmltemplate.New("digest.html").Funcs(funcs).ParseFS(templatesFS, "templates/digest.html"))
+	textTemplate = texttemplate.Must(texttemplate.New("digest.txt").Funcs(funcs).ParseFS(templatesFS, "templates/digest.txt"))
+)
+
+// render builds the email of the digest, with both a text and an html body
+func render(digest *Digest) (mail.Message, error) {
+	var text, html bytes.Buffer
+
+	if err := textTemplate.Execute(&text, digest); err != nil {
+		return mail.Message{}, err
+	}
+
+	if err := htmlTemplate.Execute(&html, digest); err != nil {
+		return mail.Message{}, err
+	}
+
+	subject := "Your weekly Raffinance digest"
+	if digest.Period == MONTHLY {
+		subject = "Your " + digest.Start.Format("January 2006") + " Raffinance digest"
+	}
+
+	return mail.Message{
+		To:      []string{digest.Recipient.Email},
+		Subject: subject,
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
@@ -0,0 +1,196 @@
+package digest
+
+import (
+	"errors"
+	"time"
+
+	"github.com/emPeeGee/raffinance/internal/entity"
+	"github.com/emPeeGee/raffinance/internal/transaction"
+	"github.com/emPeeGee/raffinance/pkg/log"
+	"gorm.io/gorm"
+)
+
+// digestTopLimit is the number of categories and transactions listed in a digest
+const digestTopLimit = 5
+
+type Repository interface {
+	getPreference(userID uint) (*entity.DigestPreference, error)
+	savePreference(preference *entity.DigestPreference) error
+	markSent(userID uint, period Period, at time.Time) error
+	getRecipient(userID uint) (*recipient, error)
+	getDueRecipients(period Period, since time.Time) ([]recipient, error)
+
+	getTotals(userID uint, start, end time.Time) (income float64, expense float64, err error)
+	getTopCategories(userID uint, start, end time.Time) ([]categoryAmount, error)
+	getLargestTransactions(userID uint, start, end time.Time) ([]largeTransaction, error)
+	getAccountBalances(userID uint, at time.Time) ([]accountBalance, error)
+}
+
+type repository struct {
+	db     *gorm.DB
+	logger log.Logger
+}
+
+func NewDigestRepository(db *gorm.DB, logger log.Logger) *repository {
+	return &repository{db: db, logger: logger}
+}
+
+// getPreference returns the preference of the user, both digests are enabled when none is saved
+func (r *repository) getPreference(userID uint) (*entity.DigestPreference, error) {
+	preference := entity.DigestPreference{UserID: userID, Weekly: true, Monthly: true}
+
+	if err := r.db.Where("user_id = ?", userID).First(&preference).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	return &preference, nil
+}
+
+func (r *repository) savePreference(preference *entity.DigestPreference) error {
+	if preference.ID == 0 {
+		return r.createPreference(preference)
+	}
+
+	return r.db.Model(preference).Updates(map[string]interface{}{
+		"weekly":  preference.Weekly,
+		"monthly": preference.Monthly,
+	}).Error
+}
+
+func (r *repository) markSent(userID uint, period Period, at time.Time) error {
+	preference, err := r.getPreference(userID)
+	if err != nil {
+		return err
+	}
+
+	if period == WEEKLY {
+		preference.LastWeeklyAt = &at
+	} else {
+		preference.LastMonthlyAt = &at
+	}
+
+	if preference.ID == 0 {
+		return r.createPreference(preference)
+	}
+
+	return r.db.Save(preference).Error
+}
+
+// createPreference lists the columns, otherwise the false values would be skipped and get the default true
+func (r *repository) createPreference(preference *entity.DigestPreference) error {
+	return r.db.Select("UserID", "Weekly", "Monthly", "LastWeeklyAt", "LastMonthlyAt", "CreatedAt", "UpdatedAt").
+		Create(preference).Error
+}
+
+func (r *repository) getRecipient(userID uint) (*recipient, error) {
+	var user recipient
+
+	if err := r.db.Model(&entity.User{}).
+		Select("id, name, email").
+		Where("id = ?", userID).
+		First(&user).Error; err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// getDueRecipients returns the users who want the digest and did not receive it since the date
+func (r *repository) getDueRecipients(period Period, since time.Time) ([]recipient, error) {
+	var users []recipient
+
+	enabled, last := "digest_preferences.weekly", "digest_preferences.last_weekly_at"
+	if period == MONTHLY {
+		enabled, last = "digest_preferences.monthly", "digest_preferences.last_monthly_at"
+	}
+
+	if err := r.db.Model(&entity.User{}).
+		Select("users.id, users.name, users.email").
+		Joins("LEFT JOIN digest_preferences ON digest_preferences.user_id = users.id AND digest_preferences.deleted_at IS NULL").
+		Where("users.email <> ''").
+		Where("digest_preferences.id IS NULL OR ("+enabled+" AND ("+last+" IS NULL OR "+last+" < ?))", since).
+		Order("users.id ASC").
+		Scan(&users).Error; err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (r *repository) userTransactions(userID uint, start, end time.Time) *gorm.DB {
+	return r.db.Table("transactions").
+		Joins("JOIN accounts ON accounts.id = transactions.to_account_id").
+		Where("accounts.user_id = ? AND transactions.deleted_at IS NULL", userID).
+		Where("transactions.date >= ? AND transactions.date < ?", start, end)
+}
+
+func (r *repository) getTotals(userID uint, start, end time.Time) (float64, float64, error) {
+	var totals struct {
+		Income  float64
+		Expense float64
+	}
+
+	if err := r.userTransactions(userID, start, end).
+		Select(`COALESCE(SUM(transactions.amount) FILTER (WHERE transactions.transaction_type_id = ?), 0) AS income,
+			COALESCE(SUM(transactions.amount) FILTER (WHERE transactions.transaction_type_id = ?), 0) AS expense`,
+			transaction.INCOME, transaction.EXPENSE).
+		Scan(&totals).Error; err != nil {
+		return 0, 0, err
+	}
+
+	return totals.Income, totals.Expense, nil
+}
+
+func (r *repository) getTopCategories(userID uint, start, end time.Time) ([]categoryAmount, error) {
+	var categories []categoryAmount
+
+	if err := r.userTransactions(userID, start, end).
+		Joins("JOIN categories ON categories.id = transactions.category_id").
+		Select("categories.name, SUM(transactions.amount) AS amount").
+		Where("transactions.transaction_type_id = ?", transaction.EXPENSE).
+		Group("categories.id, categories.name").
+		Order("amount DESC").
+		Limit(digestTopLimit).
+		Scan(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	return categories, nil
+}
+
+func (r *repository) getLargestTransactions(userID uint, start, end time.Time) ([]largeTransaction, error) {
+	var transactions []largeTransaction
+
+	if err := r.userTransactions(userID, start, end).
+		Joins("JOIN categories ON categories.id = transactions.category_id").
+		Select("transactions.date, transactions.description, categories.name AS category, transactions.amount").
+		Where("transactions.transaction_type_id = ?", transaction.EXPENSE).
+		Order("transactions.amount DESC").
+		Limit(digestTopLimit).
+		Scan(&transactions).Error; err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+}
+
+// getAccountBalances returns the balance of every account of the user right before the date
+func (r *repository) getAccountBalances(userID uint, at time.Time) ([]accountBalance, error) {
+	var accounts []entity.Account
+
+	if err := r.db.Where("user_id = ?", userID).Order("name ASC").Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+
+	byAccount, err := transaction.Balances(r.db, transaction.BalanceFilter{UserID: &userID, Before: &at})
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make([]accountBalance, len(accounts))
+	for i, account := range accounts {
+		balances[i] = accountBalance{Name: account.Name, Currency: account.Currency, Balance: byAccount[account.ID]}
+	}
+
+	return balances, nil
+}
@@ -0,0 +1,39 @@
+package digest
+
+import (
+	"context"
+	"time"
+
+	"github.com/emPeeGee/raffinance/pkg/log"
+)
+
+// schedulerInterval is how often the due digests are looked for. The digests are sent once per period,
+// so the interval only delays them after the period ends
+const schedulerInterval = time.Hour
+
+type Scheduler struct {
+	service Service
+	logger  log.Logger
+}
+
+func NewScheduler(service Service, logger log.Logger) *Scheduler {
+	return &Scheduler{service: service, logger: logger}
+}
+
+// Run sends the due digests right away and then on every tick, until the context is done
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.service.SendDue(time.Now()); err != nil {
+			s.logger.Errorf("due digests could not be sent: %s", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
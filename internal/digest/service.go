@@ -0,0 +1,150 @@
+package digest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/emPeeGee/raffinance/pkg/log"
+	"github.com/emPeeGee/raffinance/pkg/mail"
+)
+
+type Service interface {
+	getPreference(userID uint) (*preferenceResponse, error)
+	updatePreference(userID uint, preference updatePreferenceDTO) (*preferenceResponse, error)
+	sendTestDigest(userID uint, period Period) error
+	// SendDue sends the digests of the last complete period to the users who did not receive them yet
+	SendDue(now time.Time) error
+}
+
+type service struct {
+	repo   Repository
+	sender mail.Sender
+	logger log.Logger
+}
+
+func NewDigestService(repo Repository, sender mail.Sender, logger log.Logger) *service {
+	return &service{repo: repo, sender: sender, logger: logger}
+}
+
+// periodRange returns the last complete week (monday to sunday) or month before now, the end is exclusive
+func periodRange(period Period, now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	if period == MONTHLY {
+		end := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return end.AddDate(0, -1, 0), end
+	}
+
+	// time.Weekday starts on sunday
+	end := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+	return end.AddDate(0, 0, -7), end
+}
+
+func (s *service) getPreference(userID uint) (*preferenceResponse, error) {
+	preference, err := s.repo.getPreference(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &preferenceResponse{
+		Weekly:        preference.Weekly,
+		Monthly:       preference.Monthly,
+		LastWeeklyAt:  preference.LastWeeklyAt,
+		LastMonthlyAt: preference.LastMonthlyAt,
+	}, nil
+}
+
+func (s *service) updatePreference(userID uint, input updatePreferenceDTO) (*preferenceResponse, error) {
+	preference, err := s.repo.getPreference(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	preference.Weekly = *input.Weekly
+	preference.Monthly = *input.Monthly
+
+	if err := s.repo.savePreference(preference); err != nil {
+		return nil, err
+	}
+
+	return s.getPreference(userID)
+}
+
+// sendTestDigest sends the digest of the last complete period right away, even when it is disabled,
+// and does not count as the scheduled one
+func (s *service) sendTestDigest(userID uint, period Period) error {
+	user, err := s.repo.getRecipient(userID)
+	if err != nil {
+		return err
+	}
+
+	if user.Email == "" {
+		return fmt.Errorf("user %d has no email", userID)
+	}
+
+	start, end := periodRange(period, time.Now())
+	return s.send(*user, period, start, end)
+}
+
+func (s *service) SendDue(now time.Time) error {
+	for _, period := range []Period{WEEKLY, MONTHLY} {
+		start, end := periodRange(period, now)
+
+		users, err := s.repo.getDueRecipients(period, end)
+		if err != nil {
+			return err
+		}
+
+		for _, user := range users {
+			// A failed digest is retried on the next run
+			if err := s.send(user, period, start, end); err != nil {
+				s.logger.Errorf("%s digest of user %d could not be sent: %s", period, user.ID, err.Error())
+				continue
+			}
+
+			if err := s.repo.markSent(user.ID, period, now); err != nil {
+				s.logger.Errorf("%s digest of user %d could not be marked as sent: %s", period, user.ID, err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *service) send(user recipient, period Period, start, end time.Time) error {
+	digest, err := s.build(user, period, start, end)
+	if err != nil {
+		return err
+	}
+
+	message, err := render(digest)
+	if err != nil {
+		return err
+	}
+
+	return s.sender.Send(message)
+}
+
+func (s *service) build(user recipient, period Period, start, end time.Time) (*Digest, error) {
+	digest := Digest{Recipient: user, Period: period, Start: start, End: end}
+
+	var err error
+	if digest.Income, digest.Expense, err = s.repo.getTotals(user.ID, start, end); err != nil {
+		return nil, err
+	}
+
+	if digest.Categories, err = s.repo.getTopCategories(user.ID, start, end); err != nil {
+		return nil, err
+	}
+
+	if digest.Transactions, err = s.repo.getLargestTransactions(user.ID, start, end); err != nil {
+		return nil, err
+	}
+
+	if digest.Accounts, err = s.repo.getAccountBalances(user.ID, end); err != nil {
+		return nil, err
+	}
+
+	return &digest, nil
+}
@@ -9,4 +9,8 @@ type Account struct {
 	Color    string `json:"color" gorm:"notNull;size:7"`
 	Icon     string `gorm:"notNull;size:128"`
 	Currency string `json:"currency" gorm:"notNull;size:10"`
+	// Investment accounts can hold securities besides cash
+	Investment bool `json:"investment" gorm:"notNull;default:false"`
+	// Liability accounts, like loans or credit cards, count negatively towards the net worth
+	Liability bool `json:"liability" gorm:"notNull;default:false"`
 }
@@ -0,0 +1,26 @@
+package entity
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Anomaly is an unusual spending found in the history of the user. A category spike is unique per
+// month, the anomalies of a transaction have no period so the index doesn't apply to them
+type Anomaly struct {
+	gorm.Model
+	UserID        uint  `gorm:"notNull;index;uniqueIndex:idx_anomaly_category_period,where:deleted_at IS NULL"`
+	TransactionID *uint `gorm:"index"`
+	CategoryID    *uint `gorm:"uniqueIndex:idx_anomaly_category_period"`
+
+	Kind   string `json:"kind" gorm:"notNull;size:32;uniqueIndex:idx_anomaly_category_period"`
+	Reason string `json:"reason" gorm:"notNull;size:512"`
+	// Period is the month of a category spike
+	Period *time.Time `json:"period" gorm:"uniqueIndex:idx_anomaly_category_period"`
+	// Amount is the flagged amount and Expected the usual amount it was compared with
+	Amount    float64 `json:"amount" gorm:"notNull"`
+	Expected  float64 `json:"expected" gorm:"notNull"`
+	Score     float64 `json:"score" gorm:"notNull"`
+	Dismissed bool    `json:"dismissed" gorm:"notNull;default:false"`
+}
@@ -0,0 +1,18 @@
+package entity
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DigestPreference tells which email digests the user receives, a user without one receives both
+type DigestPreference struct {
+	gorm.Model
+	UserID  uint `gorm:"notNull;uniqueIndex"`
+	Weekly  bool `json:"weekly" gorm:"notNull;default:true"`
+	Monthly bool `json:"monthly" gorm:"notNull;default:true"`
+
+	LastWeeklyAt  *time.Time `json:"lastWeeklyAt"`
+	LastMonthlyAt *time.Time `json:"lastMonthlyAt"`
+}
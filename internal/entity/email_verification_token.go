@@ -0,0 +1,15 @@
+package entity
+
+import "time"
+
+// EmailVerificationToken is emailed to the address it verifies, at sign up or when the user
+// changes the email. The address becomes the one of the user only once verified
+type EmailVerificationToken struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UserID    uint      `gorm:"notNull;index"`
+	Email     string    `gorm:"notNull;size:256"`
+	Hash      string    `gorm:"notNull;size:64;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"notNull"`
+	UsedAt    *time.Time
+}
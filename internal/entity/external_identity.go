@@ -0,0 +1,38 @@
+package entity
+
+import "time"
+
+// ExternalIdentity links a user to the subject of an OpenID Connect provider, the user signs in with it
+type ExternalIdentity struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"notNull;index"`
+	Provider  string `gorm:"notNull;size:64;uniqueIndex:idx_external_identity_subject"`
+	Subject   string `gorm:"notNull;size:256;uniqueIndex:idx_external_identity_subject"`
+	Email     string `gorm:"size:256"`
+}
+
+// ProviderSignIn is the sign in with a provider in progress, between the redirect and the callback. It
+// carries the nonce and the PKCE verifier, only the hashes of the state and of the browser cookie
+// are kept
+type ProviderSignIn struct {
+	ID          uint `gorm:"primaryKey"`
+	CreatedAt   time.Time
+	Provider    string    `gorm:"notNull;size:64"`
+	Hash        string    `gorm:"notNull;size:64;uniqueIndex"`
+	BrowserHash string    `gorm:"notNull;size:64;default:''"`
+	Nonce       string    `gorm:"notNull;size:64"`
+	Verifier    string    `gorm:"notNull;size:64"`
+	ExpiresAt   time.Time `gorm:"notNull;index"`
+}
+
+// LoginCode ends the sign in with a provider. The callback passes it to the client, which exchanges
+// it for the tokens, so the tokens are never in an URL
+type LoginCode struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UserID    uint      `gorm:"notNull;index"`
+	Hash      string    `gorm:"notNull;size:64;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"notNull;index"`
+	UsedAt    *time.Time
+}
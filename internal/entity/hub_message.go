@@ -0,0 +1,13 @@
+package entity
+
+import "time"
+
+// HubMessage is a real-time message on its way to the instances of the server. The instances are told
+// about it with a NOTIFY and load it, the id is the sequence of the message for every instance
+type HubMessage struct {
+	ID        uint64    `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"notNull;index"`
+	UserID    uint      `gorm:"notNull"`
+	Type      string    `gorm:"notNull;size:64"`
+	Data      string    `gorm:"notNull;type:text"`
+}
@@ -0,0 +1,38 @@
+package entity
+
+import "time"
+
+// TOTP is the authenticator app secret of a user. It guards the sign in once confirmed by a first
+// code, LastUsedStep keeps a code from being used twice. The wrong codes are counted for the user,
+// whatever the challenge, and lock the second factor for a while
+type TOTP struct {
+	ID           uint `gorm:"primaryKey"`
+	CreatedAt    time.Time
+	UserID       uint   `gorm:"notNull;uniqueIndex"`
+	Secret       string `gorm:"notNull;size:64"`
+	ConfirmedAt  *time.Time
+	LastUsedStep int64 `gorm:"notNull;default:0"`
+	Failures     int   `gorm:"notNull;default:0"`
+	LockedUntil  *time.Time
+}
+
+// RecoveryCode replaces a TOTP code once, when the authenticator app is lost. Only its hash is kept
+type RecoveryCode struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"notNull;index"`
+	Hash      string `gorm:"notNull;size:64;uniqueIndex"`
+	UsedAt    *time.Time
+}
+
+// MFAChallenge is given by the password of a user with TOTP, it is exchanged with a code for the
+// tokens. It expires quickly and allows a few attempts
+type MFAChallenge struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UserID    uint      `gorm:"notNull;index"`
+	Hash      string    `gorm:"notNull;size:64;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"notNull;index"`
+	Attempts  int       `gorm:"notNull;default:0"`
+	UsedAt    *time.Time
+}
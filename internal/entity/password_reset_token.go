@@ -0,0 +1,14 @@
+package entity
+
+import "time"
+
+// PasswordResetToken is sent by email to the user who forgot the password. Only its hash is kept,
+// it expires and can be used once
+type PasswordResetToken struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UserID    uint      `gorm:"notNull;index"`
+	Hash      string    `gorm:"notNull;size:64;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"notNull"`
+	UsedAt    *time.Time
+}
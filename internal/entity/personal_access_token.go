@@ -0,0 +1,22 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PersonalAccessToken lets a script call the API as the user, within its scopes. Only its hash is
+// kept, the prefix tells the tokens apart in the list
+type PersonalAccessToken struct {
+	ID         uint `gorm:"primaryKey"`
+	CreatedAt  time.Time
+	UserID     uint           `gorm:"notNull;index"`
+	Name       string         `gorm:"notNull;size:64"`
+	Prefix     string         `gorm:"notNull;size:16"`
+	Hash       string         `gorm:"notNull;size:64;uniqueIndex"`
+	Scopes     pq.StringArray `gorm:"type:varchar(64)[]"`
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
@@ -0,0 +1,12 @@
+package entity
+
+import "gorm.io/gorm"
+
+type Security struct {
+	gorm.Model
+	UserID   *uint
+	Symbol   string `json:"symbol" gorm:"notNull;size:32"`
+	Name     string `json:"name" gorm:"notNull;size:256"`
+	Currency string `json:"currency" gorm:"notNull;size:10"`
+	Prices   []SecurityPrice
+}
@@ -0,0 +1,29 @@
+package entity
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SecurityEvent is a buy, sell or dividend of a security held on an investment account
+type SecurityEvent struct {
+	gorm.Model
+	AccountID  uint `gorm:"notNull;index"`
+	SecurityID uint `gorm:"notNull;index"`
+	Security   Security
+
+	Type        string    `json:"type" gorm:"notNull;size:16"`
+	Date        time.Time `json:"date" gorm:"notNull"`
+	Quantity    float64   `json:"quantity" gorm:"notNull"`
+	UnitPrice   float64   `json:"unitPrice" gorm:"notNull"`
+	Fee         float64   `json:"fee" gorm:"notNull;default:0"`
+	Description string    `json:"description" gorm:"size:256"`
+
+	// LotMethod tells how a sell is matched to the acquisition lots: FIFO, LIFO or SPECIFIC
+	LotMethod string `json:"lotMethod" gorm:"size:16"`
+	// LotID is the buy event a SPECIFIC sell is taken from
+	LotID *uint `json:"lotId"`
+	// TransactionID is the cash the event moved on the account, nil when it moved none
+	TransactionID *uint `json:"transactionId"`
+}
@@ -0,0 +1,16 @@
+package entity
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type SecurityPrice struct {
+	gorm.Model
+	SecurityID uint      `gorm:"notNull;uniqueIndex:idx_security_price_date"`
+	Date       time.Time `json:"date" gorm:"notNull;type:date;uniqueIndex:idx_security_price_date"`
+	Price      float64   `json:"price" gorm:"notNull"`
+	// Source tells where the price came from: manual or csv
+	Source string `json:"source" gorm:"notNull;size:16"`
+}
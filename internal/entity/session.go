@@ -0,0 +1,25 @@
+package entity
+
+import "time"
+
+// Session is one sign in of a user, the family of the refresh tokens rotated from it. Revoking it
+// rejects the access tokens issued for it as well
+type Session struct {
+	ID            uint `gorm:"primaryKey"`
+	CreatedAt     time.Time
+	UserID        uint      `gorm:"notNull;index"`
+	LastUsedAt    time.Time `gorm:"notNull"`
+	RevokedAt     *time.Time
+	RefreshTokens []RefreshToken `gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// RefreshToken is used once, to get a new access token and the next refresh token of its session.
+// Only its hash is kept, the used ones stay to detect a stolen token being replayed
+type RefreshToken struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	SessionID uint      `gorm:"notNull;index"`
+	Hash      string    `gorm:"notNull;size:64;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"notNull"`
+	UsedAt    *time.Time
+}
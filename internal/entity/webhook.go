@@ -0,0 +1,39 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// Webhook is an url of the user which receives the events it is subscribed to
+type Webhook struct {
+	gorm.Model
+	UserID      uint           `gorm:"notNull;index"`
+	URL         string         `json:"url" gorm:"notNull;size:2048"`
+	Description string         `json:"description" gorm:"size:256"`
+	Events      pq.StringArray `json:"events" gorm:"type:varchar(64)[];notNull"`
+	// Secret signs the payloads, so the receiver can check they come from us
+	Secret string `json:"-" gorm:"notNull;size:128"`
+	Active bool   `json:"active" gorm:"notNull;default:true"`
+}
+
+// WebhookDelivery is one event sent to a webhook, with the state of its attempts
+type WebhookDelivery struct {
+	gorm.Model
+	WebhookID uint   `gorm:"notNull;index"`
+	Event     string `json:"event" gorm:"notNull;size:64"`
+	Payload   string `json:"payload" gorm:"notNull;type:text"`
+
+	Status         string     `json:"status" gorm:"notNull;size:16;index"`
+	Attempts       int        `json:"attempts" gorm:"notNull;default:0"`
+	NextAttemptAt  *time.Time `json:"nextAttemptAt" gorm:"index"`
+	LastStatusCode int        `json:"lastStatusCode"`
+	LastError      string     `json:"lastError" gorm:"size:512"`
+	DeliveredAt    *time.Time `json:"deliveredAt"`
+	// LockedUntil is when the claim of the worker sending it ends, another worker takes it over after
+	LockedUntil *time.Time `json:"-"`
+	// RedeliveryOf is the delivery this one repeats
+	RedeliveryOf *uint `json:"redeliveryOf"`
+}
@@ -0,0 +1,14 @@
+package entity
+
+import "time"
+
+// WebsocketTicket lets a browser open a websocket, it can't send the Authorization header on the
+// upgrade. Only the hash of the ticket is kept, it expires quickly and can be used once
+type WebsocketTicket struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UserID    uint      `gorm:"notNull;index"`
+	Hash      string    `gorm:"notNull;size:64;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"notNull;index"`
+	UsedAt    *time.Time
+}
@@ -0,0 +1,133 @@
+// Package event is the in-process bus of the domain events. The services publish what happened after
+// it is committed, the side effects subscribe to it, so they don't need to know each other.
+package event
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/emPeeGee/raffinance/pkg/log"
+)
+
+// Event is something which happened to the data of a user
+type Event interface {
+	// EventName is the dotted name of the event, like transaction.created
+	EventName() string
+	EventUserID() uint
+}
+
+type Mode int
+
+const (
+	// Sync handlers run before Publish returns, their error is returned to the publisher
+	Sync Mode = iota
+	// Async handlers run in their own goroutine, their error is only logged
+	Async
+)
+
+type Handler func(e Event) error
+
+// Publisher is what the services depend on, so a test can record the events instead
+type Publisher interface {
+	Publish(e Event) error
+}
+
+type subscription struct {
+	mode    Mode
+	handler Handler
+}
+
+type Bus struct {
+	mutex  sync.RWMutex
+	byName map[string][]subscription
+	// all are the subscriptions to every event
+	all    []subscription
+	logger log.Logger
+	wg     sync.WaitGroup
+}
+
+func NewBus(logger log.Logger) *Bus {
+	return &Bus{byName: make(map[string][]subscription), logger: logger}
+}
+
+// Subscribe calls the handler for the events with the name
+func (b *Bus) Subscribe(name string, mode Mode, handler Handler) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.byName[name] = append(b.byName[name], subscription{mode, handler})
+}
+
+// SubscribeAll calls the handler for every event
+func (b *Bus) SubscribeAll(mode Mode, handler Handler) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.all = append(b.all, subscription{mode, handler})
+}
+
+// On subscribes a handler of a single event type, the name is taken from the type
+func On[T Event](b *Bus, mode Mode, handler func(e T) error) {
+	var zero T
+	b.Subscribe(zero.EventName(), mode, func(e Event) error {
+		typed, ok := e.(T)
+		if !ok {
+			return fmt.Errorf("event %s is a %T, not a %T", e.EventName(), e, zero)
+		}
+
+		return handler(typed)
+	})
+}
+
+// Publish runs the sync handlers in the order they subscribed, stopping at the first error, then
+// starts the async ones. The async handlers are started only when the sync ones succeeded
+func (b *Bus) Publish(e Event) error {
+	b.mutex.RLock()
+	subscriptions := make([]subscription, 0, len(b.byName[e.EventName()])+len(b.all))
+	subscriptions = append(subscriptions, b.byName[e.EventName()]...)
+	subscriptions = append(subscriptions, b.all...)
+	b.mutex.RUnlock()
+
+	for _, sub := range subscriptions {
+		if sub.mode != Sync {
+			continue
+		}
+
+		if err := b.call(sub.handler, e); err != nil {
+			return err
+		}
+	}
+
+	for _, sub := range subscriptions {
+		if sub.mode != Async {
+			continue
+		}
+
+		b.wg.Add(1)
+		go func(handler Handler) {
+			defer b.wg.Done()
+
+			if err := b.call(handler, e); err != nil {
+				b.logger.Errorf("handler of %s event of user %d failed: %s", e.EventName(), e.EventUserID(), err.Error())
+			}
+		}(sub.handler)
+	}
+
+	return nil
+}
+
+// Wait blocks until the async handlers are done, so none is cut on shutdown
+func (b *Bus) Wait() {
+	b.wg.Wait()
+}
+
+// call turns a panic of a handler into an error, a faulty subscriber must not break the publisher
+func (b *Bus) call(handler Handler, e Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return handler(e)
+}
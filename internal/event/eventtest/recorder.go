@@ -0,0 +1,98 @@
+// Package eventtest helps the tests to assert the events a service published.
+package eventtest
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/emPeeGee/raffinance/internal/event"
+)
+
+// Recorder is a Publisher which keeps the events instead of delivering them
+type Recorder struct {
+	mutex  sync.Mutex
+	events []event.Event
+	// Err is returned by Publish, to simulate a failing sync subscriber
+	Err error
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) Publish(e event.Event) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.Err != nil {
+		return r.Err
+	}
+
+	r.events = append(r.events, e)
+	return nil
+}
+
+// Events returns a copy of the recorded events, in the order they were published
+func (r *Recorder) Events() []event.Event {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]event.Event(nil), r.events...)
+}
+
+// Names returns the names of the recorded events
+func (r *Recorder) Names() []string {
+	events := r.Events()
+	names := make([]string, len(events))
+	for i, e := range events {
+		names[i] = e.EventName()
+	}
+
+	return names
+}
+
+func (r *Recorder) Reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.events = nil
+}
+
+// Find returns the recorded events of the type
+func Find[T event.Event](r *Recorder) []T {
+	var found []T
+	for _, e := range r.Events() {
+		if typed, ok := e.(T); ok {
+			found = append(found, typed)
+		}
+	}
+
+	return found
+}
+
+// AssertPublished fails the test unless exactly these events were published, in this order
+func AssertPublished(t testing.TB, r *Recorder, names ...string) {
+	t.Helper()
+
+	published := r.Names()
+	if len(published) == 0 && len(names) == 0 {
+		return
+	}
+
+	if !reflect.DeepEqual(published, names) {
+		t.Errorf("published events %v, want %v", published, names)
+	}
+}
+
+// AssertNotPublished fails the test if an event with the name was published
+func AssertNotPublished(t testing.TB, r *Recorder, name string) {
+	t.Helper()
+
+	for _, published := range r.Names() {
+		if published == name {
+			t.Errorf("event %s was published", name)
+			return
+		}
+	}
+}
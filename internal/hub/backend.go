@@ -0,0 +1,76 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Message is what the backend fans out, Seq is given by the backend and is the same on every instance
+type Message struct {
+	Seq    uint64
+	UserID uint
+	Type   string
+	Time   time.Time
+	Data   json.RawMessage
+}
+
+// Receiver gets the messages of every instance, it is the hub
+type Receiver interface {
+	Receive(m Message)
+	// Reset is called when messages may have been lost, the replay can't be trusted anymore
+	Reset()
+}
+
+// Backend carries the messages between the instances of the server, so a message published on one
+// reaches the connections held by any of them
+type Backend interface {
+	// Publish sends the message to the receivers of every instance, this one included
+	Publish(userID uint, messageType string, data json.RawMessage) error
+	// Listen delivers the messages to the receiver until the context is done
+	Listen(ctx context.Context, receiver Receiver) error
+}
+
+// memoryBackend is for a single instance, the messages are delivered right away
+type memoryBackend struct {
+	mutex     sync.Mutex
+	seq       uint64
+	receivers map[Receiver]struct{}
+}
+
+func NewMemoryBackend() *memoryBackend {
+	return &memoryBackend{
+		// The sequence starts from the start time, so the sequences of a previous run are not
+		// mistaken for new ones by a client which resumes
+		seq:       uint64(time.Now().UnixMilli()) * 1000,
+		receivers: make(map[Receiver]struct{}),
+	}
+}
+
+func (b *memoryBackend) Publish(userID uint, messageType string, data json.RawMessage) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.seq++
+	m := Message{Seq: b.seq, UserID: userID, Type: messageType, Time: time.Now().UTC(), Data: data}
+	for receiver := range b.receivers {
+		receiver.Receive(m)
+	}
+
+	return nil
+}
+
+func (b *memoryBackend) Listen(ctx context.Context, receiver Receiver) error {
+	b.mutex.Lock()
+	b.receivers[receiver] = struct{}{}
+	b.mutex.Unlock()
+
+	<-ctx.Done()
+
+	b.mutex.Lock()
+	delete(b.receivers, receiver)
+	b.mutex.Unlock()
+
+	return nil
+}
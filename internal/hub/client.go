@@ -0,0 +1,74 @@
+package hub
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Client is one websocket connection. Only the write pump writes to the connection and only the read
+// pump reads from it, gorilla allows one of each at a time
+type Client struct {
+	hub      *Hub
+	listener *listener
+	conn     *websocket.Conn
+}
+
+func newClient(hub *Hub, listener *listener, conn *websocket.Conn) *Client {
+	return &Client{hub: hub, listener: listener, conn: conn}
+}
+
+// readPump reads until the connection fails. The clients don't send messages, but reading is needed to
+// process the pongs and to notice the connection is closed
+func (c *Client) readPump() {
+	defer func() {
+		c.hub.unsubscribe(c.listener)
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(maxMessageSize)
+	_ = c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		if _, _, err := c.conn.ReadMessage(); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				c.hub.logger.Debugf("websocket of user %d closed: %s", c.listener.userID, err.Error())
+			}
+
+			return
+		}
+	}
+}
+
+// writePump sends the queued messages and the pings
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case f, ok := <-c.listener.send:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				// The hub removed the client
+				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+
+			if err := c.conn.WriteMessage(websocket.TextMessage, f.payload); err != nil {
+				return
+			}
+		case <-ticker.C:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
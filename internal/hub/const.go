@@ -0,0 +1,34 @@
+package hub
+
+import "time"
+
+const (
+	// writeWait is the time allowed to write a message to the peer
+	writeWait = 10 * time.Second
+	// pongWait is the time allowed to read the next pong, the connection is dropped after it
+	pongWait = 60 * time.Second
+	// pingPeriod must be less than pongWait, so the pong has time to arrive
+	pingPeriod = pongWait * 9 / 10
+	// maxMessageSize limits what a client may send, the clients only answer pings
+	maxMessageSize = 4096
+
+	// sendBufferSize is how many messages may wait for a slow connection before it is dropped
+	sendBufferSize = 64
+	// replaySize is how many messages are kept per user for the clients which reconnect
+	replaySize = 100
+	// streamTTL is how long the messages of a user without connection are kept, to be resumed
+	streamTTL = 10 * time.Minute
+)
+
+// resyncType tells a client its last sequence is too old to be replayed, it should reload its data
+const resyncType = "resync"
+
+// ticketTTL is how long a websocket ticket may wait to be redeemed
+const ticketTTL = 30 * time.Second
+
+const (
+	// heartbeatPeriod is how often an idle event stream gets a comment, so the proxies keep it open
+	heartbeatPeriod = 15 * time.Second
+	// retryMillis tells EventSource how long to wait before it reconnects
+	retryMillis = 3000
+)
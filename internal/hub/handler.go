@@ -0,0 +1,135 @@
+package hub
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/emPeeGee/raffinance/internal/auth"
+	"github.com/emPeeGee/raffinance/pkg/errorutil"
+	"github.com/emPeeGee/raffinance/pkg/log"
+	"github.com/emPeeGee/raffinance/pkg/util"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// RegisterHandlers adds the ticket endpoint to the authenticated routes, the websocket and the event
+// stream to the public ones. They authenticate themselves, browsers can only pass a ticket to them
+func RegisterHandlers(
+	wsRg, apiRg *gin.RouterGroup,
+	service Service,
+	hub *Hub,
+	identity gin.HandlerFunc,
+	allowedOrigins []string,
+	logger log.Logger,
+) {
+	h := handler{
+		service:  service,
+		hub:      hub,
+		identity: identity,
+		logger:   logger,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			CheckOrigin: func(r *http.Request) bool {
+				// Clients which are not browsers don't send an origin
+				origin := r.Header.Get("Origin")
+				return origin == "" || util.Contains(allowedOrigins, origin)
+			},
+		},
+	}
+
+	apiRg.POST("/websocket/tickets", h.issueTicket)
+	wsRg.GET("/websocket", h.connect)
+	wsRg.GET("/events", h.events)
+}
+
+type handler struct {
+	service  Service
+	hub      *Hub
+	identity gin.HandlerFunc
+	logger   log.Logger
+	upgrader websocket.Upgrader
+}
+
+func (h *handler) issueTicket(c *gin.Context) {
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	ticket, err := h.service.issueTicket(*userId)
+	if err != nil {
+		errorutil.InternalServer(c, "something went wrong, we are working", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, ticket)
+}
+
+// connect authenticates, then upgrades the request to a websocket. A client which reconnects
+// passes the last sequence it got as lastSeq, to receive what it missed
+func (h *handler) connect(c *gin.Context) {
+	if !h.upgrader.CheckOrigin(c.Request) {
+		errorutil.Forbidden(c, "origin not allowed", "the origin is not allowed")
+		return
+	}
+
+	var lastSeq *uint64
+	if raw := c.Query("lastSeq"); raw != "" {
+		seq, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			errorutil.BadRequest(c, err.Error(), "lastSeq must be an integer")
+			return
+		}
+
+		lastSeq = &seq
+	}
+
+	// the websocket is for the apps, not for the scripts
+	userId, ok := h.authenticate(c, auth.RequireSession())
+	if !ok {
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader already answered with the error
+		h.logger.Debugf("websocket upgrade failed: %s", err.Error())
+		return
+	}
+
+	h.hub.Connect(userId, conn, lastSeq)
+}
+
+// authenticate redeems the ticket of the query or, for the clients which can set headers, checks the
+// bearer token against the require guard. It answers the request itself when it fails
+func (h *handler) authenticate(c *gin.Context, require gin.HandlerFunc) (uint, bool) {
+	if ticket := c.Query("ticket"); ticket != "" {
+		userId, err := h.service.redeemTicket(ticket)
+		if err != nil {
+			errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+			return 0, false
+		}
+
+		return userId, true
+	}
+
+	h.identity(c)
+	if c.IsAborted() {
+		return 0, false
+	}
+
+	require(c)
+	if c.IsAborted() {
+		return 0, false
+	}
+
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, "the user is unknown", "you are not authorized")
+		return 0, false
+	}
+
+	return *userId, true
+}
@@ -1,102 +1,281 @@
 package hub
 
 import (
-	"fmt"
+	"context"
+	"encoding/json"
 	"sync"
+	"time"
 
+	"github.com/emPeeGee/raffinance/pkg/log"
 	"github.com/gorilla/websocket"
 )
 
-type Client struct {
-	ID   uint
-	Conn *websocket.Conn
+// Envelope is every message sent to the clients. Seq is given by the backend, it grows with every
+// message, not by one for a user. A client resumes from the last sequence it got
+type Envelope struct {
+	Type string          `json:"type"`
+	Seq  uint64          `json:"seq"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data,omitempty"`
 }
 
-func NewClient(id uint, conn *websocket.Conn) *Client {
-	return &Client{
-		ID:   id,
-		Conn: conn,
+type frame struct {
+	seq     uint64
+	payload []byte
+}
+
+// listener is a connection of a user, over a websocket or Server-Sent Events
+type listener struct {
+	userID uint
+	// send is closed by the hub when the listener is removed
+	send chan frame
+}
+
+// stream is the state of a user, it outlives the connections so the reconnects can be resumed. It is
+// forgotten once it had no connection nor message for streamTTL
+type stream struct {
+	listeners map[*listener]struct{}
+	// last is the sequence of the last message
+	last uint64
+	// replay is a ring of the last messages, oldest first
+	replay []frame
+	// active is when the stream got its last message or lost its last connection
+	active time.Time
+}
+
+// idle tells whether the stream may be forgotten, nobody would resume from it anymore
+func (s *stream) idle(now time.Time) bool {
+	return len(s.listeners) == 0 && now.Sub(s.active) > streamTTL
+}
+
+func (s *stream) remember(f frame) {
+	if len(s.replay) == replaySize {
+		copy(s.replay, s.replay[1:])
+		s.replay = s.replay[:replaySize-1]
 	}
+
+	s.replay = append(s.replay, f)
 }
 
-func (c *Client) Send(message []byte) {
-	err := c.Conn.WriteMessage(websocket.TextMessage, message)
-	if err != nil {
-		fmt.Println("Error writing message to client:", err)
+// Hub keeps the connections of the users held by this instance, a user may be connected from several
+// devices. The messages go through the backend, so they reach the connections held by other instances
+type Hub struct {
+	mutex   sync.Mutex
+	backend Backend
+	streams map[uint]*stream
+	// closed refuses the new connections once the server is stopping
+	closed bool
+	logger log.Logger
+}
+
+func NewHub(backend Backend, logger log.Logger) *Hub {
+	return &Hub{
+		backend: backend,
+		streams: make(map[uint]*stream),
+		logger:  logger,
+	}
+}
+
+func (h *Hub) stream(userID uint) *stream {
+	s, ok := h.streams[userID]
+	if !ok {
+		s = &stream{listeners: make(map[*listener]struct{})}
+		h.streams[userID] = s
 	}
+
+	return s
+}
+
+// Run receives the messages of the backend until the context is done
+func (h *Hub) Run(ctx context.Context) error {
+	go h.sweep(ctx)
+
+	return h.backend.Listen(ctx, h)
 }
 
-func (c *Client) Listen(hub *Hub) {
-	defer func() {
-		hub.RemoveClient(c.ID)
-		c.Conn.Close()
-	}()
+// sweep forgets the idle streams, the ones of the users who got messages but never connected as well
+func (h *Hub) sweep(ctx context.Context) {
+	ticker := time.NewTicker(streamTTL)
+	defer ticker.Stop()
 
 	for {
-		messageType, message, err := c.Conn.ReadMessage()
-		fmt.Println("MSG TYPE", messageType)
-		if err != nil {
-			fmt.Println("Error reading message from client:", err)
+		select {
+		case <-ctx.Done():
 			return
+		case now := <-ticker.C:
+			h.mutex.Lock()
+			for userID, s := range h.streams {
+				if s.idle(now) {
+					delete(h.streams, userID)
+				}
+			}
+			h.mutex.Unlock()
 		}
+	}
+}
 
-		// Send message only to the sender
-		c.Send(message)
+// Publish sends a message to every connection of the user, on every instance
+func (h *Hub) Publish(userID uint, messageType string, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
 	}
 
-	// for {
-	// 	_, message, err := c.Conn.ReadMessage()
-	// 	if err != nil {
-	// 		fmt.Println("Error reading message:", err)
-	// 		break
-	// 	}
+	return h.backend.Publish(userID, messageType, raw)
+}
+
+// Receive queues a message of the backend on the connections of the user and keeps it for the replay.
+// It never blocks, a connection which can't keep up is dropped and resumes when it reconnects
+func (h *Hub) Receive(m Message) {
+	payload, err := json.Marshal(Envelope{Type: m.Type, Seq: m.Seq, Time: m.Time, Data: m.Data})
+	if err != nil {
+		h.logger.Errorf("hub message %d could not be encoded: %s", m.Seq, err.Error())
+		return
+	}
 
-	// 	fmt.Printf("Received message from client %s: %s\n", c.ID, message)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
 
-	// 	// Broadcast the message to all clients except the sender
-	// 	hub.Broadcast(message, c)
-	// }
+	s := h.stream(m.UserID)
+	s.last = m.Seq
+	s.active = time.Now()
+
+	f := frame{seq: m.Seq, payload: payload}
+	s.remember(f)
+
+	for l := range s.listeners {
+		h.deliver(s, l, f)
+	}
 }
 
-type Hub struct {
-	clients map[uint]*Client
-	mutex   sync.Mutex
+// Reset forgets the replay, the clients which resume get a resync
+func (h *Hub) Reset() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, s := range h.streams {
+		s.last = 0
+		s.replay = nil
+	}
 }
 
-func NewHub() *Hub {
-	return &Hub{
-		clients: make(map[uint]*Client),
+// deliver queues the frame on the connection, the caller holds the mutex
+func (h *Hub) deliver(s *stream, l *listener, f frame) {
+	select {
+	case l.send <- f:
+	default:
+		h.logger.Infof("connection of user %d is too slow, it is disconnected", l.userID)
+		delete(s.listeners, l)
+		close(l.send)
 	}
 }
 
-func (r *Hub) AddClient(client *Client) {
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
+// subscribe registers a connection of the user. When lastSeq is given, the messages after it are
+// queued first, or a resync message when they are not kept anymore. It returns nil once the hub is closed
+func (h *Hub) subscribe(userID uint, lastSeq *uint64) *listener {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
 
-	r.clients[client.ID] = client
-}
+	if h.closed {
+		return nil
+	}
+
+	l := &listener{userID: userID, send: make(chan frame, sendBufferSize)}
+	s := h.stream(userID)
+	s.listeners[l] = struct{}{}
 
-func (r *Hub) RemoveClient(userID uint) {
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
+	if lastSeq != nil {
+		h.replay(s, l, *lastSeq)
+	}
 
-	delete(r.clients, userID)
+	return l
 }
 
-func (r *Hub) GetClient(userID uint) *Client {
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
+// Connect registers the websocket of the user and starts its pumps
+func (h *Hub) Connect(userID uint, conn *websocket.Conn, lastSeq *uint64) {
+	l := h.subscribe(userID, lastSeq)
+	if l == nil {
+		_ = conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is stopping"), time.Now().Add(writeWait))
+		conn.Close()
+		return
+	}
 
-	return r.clients[userID]
+	client := newClient(h, l, conn)
+
+	go client.writePump()
+	go client.readPump()
 }
 
-func (r *Hub) Broadcast(message []byte, sender *Client) {
-	for userID, client := range r.clients {
-		if userID != sender.ID {
-			err := client.Conn.WriteMessage(websocket.TextMessage, message)
-			if err != nil {
-				fmt.Println("Error writing message to client:", err)
+// replay queues the messages received after lastSeq. They are found by position, the sequences of a
+// user are not contiguous and may arrive out of order from different instances
+func (h *Hub) replay(s *stream, l *listener, lastSeq uint64) {
+	if lastSeq == s.last {
+		return
+	}
+
+	for i, f := range s.replay {
+		if f.seq == lastSeq {
+			for _, next := range s.replay[i+1:] {
+				h.deliver(s, l, next)
 			}
+
+			return
+		}
+	}
+
+	// The message is not kept anymore, was never received by this instance or the replay was reset
+	payload, err := json.Marshal(Envelope{Type: resyncType, Seq: s.last, Time: time.Now().UTC()})
+	if err == nil {
+		h.deliver(s, l, frame{seq: s.last, payload: payload})
+	}
+}
+
+// unsubscribe forgets the connection, it is called once when the connection ends
+func (h *Hub) unsubscribe(l *listener) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	s, ok := h.streams[l.userID]
+	if !ok {
+		return
+	}
+
+	// The listener may already be removed because it was too slow or the hub is closed
+	if _, ok := s.listeners[l]; ok {
+		delete(s.listeners, l)
+		close(l.send)
+	}
+
+	// The user may reconnect and resume for a while, then the sweep forgets the stream
+	if len(s.listeners) == 0 {
+		s.active = time.Now()
+	}
+}
+
+// Close ends every connection and refuses the new ones, it is called when the server stops, so the
+// long lived connections don't hold the shutdown
+func (h *Hub) Close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.closed = true
+	for _, s := range h.streams {
+		for l := range s.listeners {
+			delete(s.listeners, l)
+			close(l.send)
 		}
 	}
 }
+
+// Connections returns how many connections the user has
+func (h *Hub) Connections(userID uint) int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if s, ok := h.streams[userID]; ok {
+		return len(s.listeners)
+	}
+
+	return 0
+}
@@ -0,0 +1,8 @@
+package hub
+
+import "time"
+
+type ticketResponse struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
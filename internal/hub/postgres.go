@@ -0,0 +1,102 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/emPeeGee/raffinance/internal/entity"
+	"github.com/emPeeGee/raffinance/pkg/log"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+const (
+	notifyChannel = "raffinance_hub"
+	// messageRetention is how long the messages stay in the table, the instances load them right away
+	messageRetention = time.Hour
+	cleanupInterval  = 10 * time.Minute
+
+	minReconnectInterval = time.Second
+	maxReconnectInterval = time.Minute
+)
+
+// postgresBackend fans the messages out through the database, with LISTEN/NOTIFY. A message is saved
+// and only its id is notified, the payload of a NOTIFY is limited to 8000 bytes
+type postgresBackend struct {
+	db     *gorm.DB
+	dsn    string
+	logger log.Logger
+}
+
+func NewPostgresBackend(db *gorm.DB, dsn string, logger log.Logger) *postgresBackend {
+	return &postgresBackend{db: db, dsn: dsn, logger: logger}
+}
+
+func (b *postgresBackend) Publish(userID uint, messageType string, data json.RawMessage) error {
+	// The insert and the notify are one statement, the notification is sent when it commits
+	return b.db.Exec(`
+		WITH message AS (
+			INSERT INTO hub_messages (created_at, user_id, type, data) VALUES (now(), ?, ?, ?) RETURNING id
+		)
+		SELECT pg_notify(?, id::text) FROM message`,
+		userID, messageType, string(data), notifyChannel).Error
+}
+
+func (b *postgresBackend) Listen(ctx context.Context, receiver Receiver) error {
+	listener := pq.NewListener(b.dsn, minReconnectInterval, maxReconnectInterval, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			b.logger.Errorf("hub listener: %s", err.Error())
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(notifyChannel); err != nil {
+		return err
+	}
+
+	cleanup := time.NewTicker(cleanupInterval)
+	defer cleanup.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case notification := <-listener.Notify:
+			// A nil notification follows a reconnect, what was notified meanwhile is lost
+			if notification == nil {
+				receiver.Reset()
+				continue
+			}
+
+			b.receive(receiver, notification.Extra)
+		case <-cleanup.C:
+			if err := b.db.Where("created_at < ?", time.Now().Add(-messageRetention)).Delete(&entity.HubMessage{}).Error; err != nil {
+				b.logger.Errorf("old hub messages could not be deleted: %s", err.Error())
+			}
+		}
+	}
+}
+
+func (b *postgresBackend) receive(receiver Receiver, id string) {
+	seq, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		b.logger.Errorf("hub notification %q is not an id", id)
+		return
+	}
+
+	var message entity.HubMessage
+	if err := b.db.First(&message, seq).Error; err != nil {
+		b.logger.Errorf("hub message %d could not be loaded: %s", seq, err.Error())
+		return
+	}
+
+	receiver.Receive(Message{
+		Seq:    message.ID,
+		UserID: message.UserID,
+		Type:   message.Type,
+		Time:   message.CreatedAt.UTC(),
+		Data:   json.RawMessage(message.Data),
+	})
+}
@@ -0,0 +1,53 @@
+package hub
+
+import (
+	"time"
+
+	"github.com/emPeeGee/raffinance/internal/entity"
+	"github.com/emPeeGee/raffinance/pkg/log"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Repository interface {
+	createTicket(ticket *entity.WebsocketTicket) error
+	redeemTicket(hash string, now time.Time) (*uint, error)
+	deleteExpiredTickets(before time.Time) error
+}
+
+type repository struct {
+	db     *gorm.DB
+	logger log.Logger
+}
+
+func NewTicketRepository(db *gorm.DB, logger log.Logger) *repository {
+	return &repository{db: db, logger: logger}
+}
+
+func (r *repository) createTicket(ticket *entity.WebsocketTicket) error {
+	return r.db.Create(ticket).Error
+}
+
+// redeemTicket marks the ticket used and returns its user, in one statement so a ticket can't be
+// redeemed twice by concurrent upgrades. It returns nil when the ticket is unknown, used or expired
+func (r *repository) redeemTicket(hash string, now time.Time) (*uint, error) {
+	var tickets []entity.WebsocketTicket
+
+	result := r.db.Model(&tickets).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "user_id"}}}).
+		Where("hash = ? AND used_at IS NULL AND expires_at > ?", hash, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if len(tickets) == 0 {
+		return nil, nil
+	}
+
+	return &tickets[0].UserID, nil
+}
+
+func (r *repository) deleteExpiredTickets(before time.Time) error {
+	return r.db.Where("expires_at < ?", before).Delete(&entity.WebsocketTicket{}).Error
+}
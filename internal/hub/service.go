@@ -0,0 +1,71 @@
+package hub
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/emPeeGee/raffinance/internal/entity"
+	"github.com/emPeeGee/raffinance/pkg/log"
+)
+
+type Service interface {
+	issueTicket(userID uint) (*ticketResponse, error)
+	redeemTicket(ticket string) (uint, error)
+}
+
+type service struct {
+	repo   Repository
+	logger log.Logger
+}
+
+func NewTicketService(repo Repository, logger log.Logger) *service {
+	return &service{repo: repo, logger: logger}
+}
+
+func hashTicket(ticket string) string {
+	sum := sha256.Sum256([]byte(ticket))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *service) issueTicket(userID uint) (*ticketResponse, error) {
+	now := time.Now()
+
+	// The old tickets are of no use anymore, they are cleaned while issuing new ones
+	if err := s.repo.deleteExpiredTickets(now); err != nil {
+		s.logger.Errorf("expired websocket tickets could not be deleted: %s", err.Error())
+	}
+
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return nil, err
+	}
+
+	ticket := hex.EncodeToString(bytes)
+	expiresAt := now.Add(ticketTTL)
+
+	if err := s.repo.createTicket(&entity.WebsocketTicket{
+		UserID:    userID,
+		Hash:      hashTicket(ticket),
+		ExpiresAt: expiresAt,
+	}); err != nil {
+		return nil, err
+	}
+
+	return &ticketResponse{Ticket: ticket, ExpiresAt: expiresAt}, nil
+}
+
+func (s *service) redeemTicket(ticket string) (uint, error) {
+	userID, err := s.repo.redeemTicket(hashTicket(ticket), time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	if userID == nil {
+		return 0, errors.New("the ticket is invalid, expired or already used")
+	}
+
+	return *userID, nil
+}
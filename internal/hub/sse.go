@@ -0,0 +1,106 @@
+package hub
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/emPeeGee/raffinance/internal/auth"
+	"github.com/emPeeGee/raffinance/pkg/errorutil"
+	"github.com/gin-gonic/gin"
+)
+
+// events streams the messages of the user as Server-Sent Events, the id of an event is its sequence.
+// The connection is hijacked like a websocket upgrade, so the write timeout of the server doesn't
+// end the stream
+func (h *handler) events(c *gin.Context) {
+	// EventSource sends the header on reconnect, the query is for the clients which can't set it
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("lastEventId")
+	}
+
+	var lastSeq *uint64
+	if lastEventID != "" {
+		seq, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			errorutil.BadRequest(c, err.Error(), "Last-Event-ID must be an integer")
+			return
+		}
+
+		lastSeq = &seq
+	}
+
+	// a script may follow the stream with a personal access token which can read the transactions
+	userId, ok := h.authenticate(c, auth.RequireScope("transactions"))
+	if !ok {
+		return
+	}
+
+	l := h.hub.subscribe(userId, lastSeq)
+	if l == nil {
+		errorutil.Error(c, http.StatusServiceUnavailable, "the server is stopping", "")
+		return
+	}
+	defer h.hub.unsubscribe(l)
+
+	conn, rw, err := c.Writer.Hijack()
+	if err != nil {
+		errorutil.InternalServer(c, "something went wrong, we are working", err.Error())
+		return
+	}
+	defer conn.Close()
+
+	// The deadlines of the server don't apply anymore, every write sets its own
+	_ = conn.SetDeadline(time.Time{})
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "close")
+	header.Set("X-Accel-Buffering", "no")
+
+	write := func(format string, args ...any) error {
+		_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+		fmt.Fprintf(rw, format, args...)
+		return rw.Flush()
+	}
+
+	fmt.Fprint(rw, "HTTP/1.1 200 OK\r\n")
+	_ = header.Write(rw)
+	if err := write("\r\nretry: %d\n\n", retryMillis); err != nil {
+		return
+	}
+
+	// The client never sends anything, a read returns when it goes away
+	gone := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, rw)
+		close(gone)
+	}()
+
+	heartbeat := time.NewTicker(heartbeatPeriod)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case f, ok := <-l.send:
+			if !ok {
+				// Removed by the hub, too slow or the server is stopping
+				return
+			}
+
+			if err := write("id: %d\ndata: %s\n\n", f.seq, f.payload); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := write(": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package hub
+
+import (
+	"github.com/emPeeGee/raffinance/internal/event"
+)
+
+// RegisterSubscribers pushes every event to the websockets of its user
+func RegisterSubscribers(bus *event.Bus, hub *Hub) {
+	bus.SubscribeAll(event.Async, func(e event.Event) error {
+		return hub.Publish(e.EventUserID(), e.EventName(), e)
+	})
+}
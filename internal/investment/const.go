@@ -0,0 +1,22 @@
+package investment
+
+type EventType string
+
+const (
+	BUY      EventType = "BUY"
+	SELL     EventType = "SELL"
+	DIVIDEND EventType = "DIVIDEND"
+)
+
+type LotMethod string
+
+const (
+	FIFO     LotMethod = "FIFO"
+	LIFO     LotMethod = "LIFO"
+	SPECIFIC LotMethod = "SPECIFIC"
+)
+
+const (
+	priceSourceManual = "manual"
+	priceSourceCSV    = "csv"
+)
@@ -0,0 +1,74 @@
+package investment
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var priceDateLayouts = []string{"2006-01-02", time.RFC3339}
+
+// parsePricesCSV reads `date,price` rows. A header row is allowed and skipped
+func parsePricesCSV(reader io.Reader) ([]createPriceDTO, error) {
+	r := csv.NewReader(reader)
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+
+	var prices []createPriceDTO
+	// lines by date, the prices are upserted by date and a batch can't update a row twice
+	lines := make(map[time.Time]int)
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid csv: %w", err)
+		}
+
+		date, dateErr := parsePriceDate(record[0])
+		if dateErr != nil && line == 1 {
+			// header
+			continue
+		}
+
+		if dateErr != nil {
+			return nil, fmt.Errorf("line %d: %w", line, dateErr)
+		}
+
+		price, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err != nil || price <= 0 {
+			return nil, fmt.Errorf("line %d: invalid price %q", line, record[1])
+		}
+
+		day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+		if previous, ok := lines[day]; ok {
+			return nil, fmt.Errorf("line %d: date %s is already on line %d", line, day.Format("2006-01-02"), previous)
+		}
+		lines[day] = line
+
+		prices = append(prices, createPriceDTO{Date: date, Price: price})
+	}
+
+	if len(prices) == 0 {
+		return nil, errors.New("the csv does not contain any price")
+	}
+
+	return prices, nil
+}
+
+func parsePriceDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range priceDateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", value)
+}
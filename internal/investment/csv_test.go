@@ -0,0 +1,107 @@
+package investment
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePricesCSV(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name   string
+		csv    string
+		prices []createPriceDTO
+		err    string
+	}{
+		{
+			name:   "header and rows",
+			csv:    "date,price\n2024-01-02,10.5\n2024-01-03, 11\n",
+			prices: []createPriceDTO{{Date: day(2), Price: 10.5}, {Date: day(3), Price: 11}},
+		},
+		{
+			name:   "without header",
+			csv:    "2024-01-02,10.5\n",
+			prices: []createPriceDTO{{Date: day(2), Price: 10.5}},
+		},
+		{
+			name:   "RFC3339 date",
+			csv:    "2024-01-02T00:00:00Z,10.5\n",
+			prices: []createPriceDTO{{Date: day(2), Price: 10.5}},
+		},
+		{
+			name: "duplicate date",
+			csv:  "date,price\n2024-01-02,10.5\n2024-01-03,11\n2024-01-02,12\n",
+			err:  "line 4: date 2024-01-02 is already on line 2",
+		},
+		{
+			name: "duplicate day at another time",
+			csv:  "2024-01-02,10.5\n2024-01-02T15:30:00Z,12\n",
+			err:  "line 2: date 2024-01-02 is already on line 1",
+		},
+		{
+			name: "invalid date after the header",
+			csv:  "date,price\n02/01/2024,10.5\n",
+			err:  `line 2: invalid date "02/01/2024"`,
+		},
+		{
+			name: "invalid price",
+			csv:  "2024-01-02,ten\n",
+			err:  `line 1: invalid price "ten"`,
+		},
+		{
+			name: "zero price",
+			csv:  "2024-01-02,0\n",
+			err:  `line 1: invalid price "0"`,
+		},
+		{
+			name: "negative price",
+			csv:  "2024-01-02,-1\n",
+			err:  `line 1: invalid price "-1"`,
+		},
+		{
+			name: "missing column",
+			csv:  "2024-01-02,10.5\n2024-01-03\n",
+			err:  "invalid csv",
+		},
+		{
+			name: "only a header",
+			csv:  "date,price\n",
+			err:  "the csv does not contain any price",
+		},
+		{
+			name: "empty",
+			csv:  "",
+			err:  "the csv does not contain any price",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			prices, err := parsePricesCSV(strings.NewReader(test.csv))
+
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got error %v, expected %q", err, test.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(prices) != len(test.prices) {
+				t.Fatalf("got %d prices, expected %d", len(prices), len(test.prices))
+			}
+
+			for i, price := range prices {
+				if !price.Date.Equal(test.prices[i].Date) || price.Price != test.prices[i].Price {
+					t.Errorf("price %d is %v, expected %v", i, price, test.prices[i])
+				}
+			}
+		})
+	}
+}
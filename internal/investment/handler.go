@@ -0,0 +1,370 @@
+package investment
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/emPeeGee/raffinance/internal/auth"
+	"github.com/emPeeGee/raffinance/pkg/errorutil"
+	"github.com/emPeeGee/raffinance/pkg/log"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator"
+)
+
+const maxImportSize = 5 << 20 // 5 MB
+
+func RegisterHandlers(apiRg *gin.RouterGroup, service Service, validate *validator.Validate, logger log.Logger) {
+	h := handler{service, logger, validate}
+
+	securities := apiRg.Group("/securities")
+	{
+		securities.GET("", h.getSecurities)
+		securities.POST("", h.createSecurity)
+		securities.PUT("/:id", h.updateSecurity)
+		securities.DELETE("/:id", h.deleteSecurity)
+
+		securities.GET("/:id/prices", h.getPrices)
+		securities.POST("/:id/prices", h.createPrice)
+		securities.POST("/:id/prices/import", h.importPrices)
+	}
+
+	accounts := apiRg.Group("/accounts")
+	{
+		accounts.GET("/:id/holdings", h.getHoldings)
+		accounts.GET("/:id/lots", h.getOpenLots)
+		accounts.GET("/:id/events", h.getEvents)
+		accounts.POST("/:id/events", h.createEvent)
+	}
+
+	events := apiRg.Group("/securityEvents")
+	{
+		events.DELETE("/:id", h.deleteEvent)
+	}
+}
+
+type handler struct {
+	service  Service
+	logger   log.Logger
+	validate *validator.Validate
+}
+
+func (h *handler) getSecurities(c *gin.Context) {
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	securities, err := h.service.getSecurities(*userId)
+	if err != nil {
+		errorutil.InternalServer(c, "something went wrong, we are working", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, securities)
+}
+
+func (h *handler) createSecurity(c *gin.Context) {
+	var input createSecurityDTO
+
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	if err := c.BindJSON(&input); err != nil {
+		errorutil.BadRequest(c, "your request looks incorrect", err.Error())
+		return
+	}
+
+	if err := h.validate.Struct(input); err != nil {
+		errorutil.BadRequest(c, "your request did not pass validation", err.Error())
+		return
+	}
+
+	createdSecurity, err := h.service.createSecurity(*userId, input)
+	if err != nil {
+		errorutil.InternalServer(c, "It looks like symbol is already used", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, createdSecurity)
+}
+
+func (h *handler) updateSecurity(c *gin.Context) {
+	var input updateSecurityDTO
+
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	securityId, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		errorutil.BadRequest(c, "wrong security id", err.Error())
+		return
+	}
+
+	if err := c.BindJSON(&input); err != nil {
+		errorutil.BadRequest(c, "your request looks incorrect", err.Error())
+		return
+	}
+
+	if err := h.validate.Struct(input); err != nil {
+		errorutil.BadRequest(c, "your request did not pass validation", err.Error())
+		return
+	}
+
+	updatedSecurity, err := h.service.updateSecurity(*userId, uint(securityId), input)
+	if err != nil {
+		errorutil.BadRequest(c, "error", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, updatedSecurity)
+}
+
+func (h *handler) deleteSecurity(c *gin.Context) {
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	securityId, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		errorutil.BadRequest(c, err.Error(), "the id must be an integer")
+		return
+	}
+
+	if err := h.service.deleteSecurity(*userId, uint(securityId)); err != nil {
+		h.logger.Info(err.Error())
+		errorutil.NotFound(c, err.Error(), "Not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"ok": true,
+	})
+}
+
+func (h *handler) getPrices(c *gin.Context) {
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	securityId, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		errorutil.BadRequest(c, err.Error(), "the id must be an integer")
+		return
+	}
+
+	prices, err := h.service.getPrices(*userId, uint(securityId))
+	if err != nil {
+		errorutil.NotFound(c, err.Error(), "Not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, prices)
+}
+
+func (h *handler) createPrice(c *gin.Context) {
+	var input createPriceDTO
+
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	securityId, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		errorutil.BadRequest(c, err.Error(), "the id must be an integer")
+		return
+	}
+
+	if err := c.BindJSON(&input); err != nil {
+		errorutil.BadRequest(c, "your request looks incorrect", err.Error())
+		return
+	}
+
+	if err := h.validate.Struct(input); err != nil {
+		errorutil.BadRequest(c, "your request did not pass validation", err.Error())
+		return
+	}
+
+	if err := h.service.createPrice(*userId, uint(securityId), input); err != nil {
+		errorutil.BadRequest(c, "error", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"ok": true,
+	})
+}
+
+// importPrices accepts the csv either as a multipart `file` field or as the raw request body
+func (h *handler) importPrices(c *gin.Context) {
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	securityId, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		errorutil.BadRequest(c, err.Error(), "the id must be an integer")
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+
+	var csv io.Reader = c.Request.Body
+	if file, err := c.FormFile("file"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			errorutil.BadRequest(c, "could not read the uploaded file", err.Error())
+			return
+		}
+		defer f.Close()
+
+		csv = f
+	}
+
+	imported, err := h.service.importPrices(*userId, uint(securityId), csv)
+	if err != nil {
+		errorutil.BadRequest(c, "the prices could not be imported", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, importResultResponse{Imported: imported})
+}
+
+func (h *handler) getEvents(c *gin.Context) {
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	accountId, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		errorutil.BadRequest(c, err.Error(), "the id must be an integer")
+		return
+	}
+
+	events, err := h.service.getEvents(*userId, uint(accountId))
+	if err != nil {
+		errorutil.NotFound(c, err.Error(), "Not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
+
+func (h *handler) createEvent(c *gin.Context) {
+	var input createEventDTO
+
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	accountId, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		errorutil.BadRequest(c, err.Error(), "the id must be an integer")
+		return
+	}
+
+	if err := c.BindJSON(&input); err != nil {
+		errorutil.BadRequest(c, "your request looks incorrect", err.Error())
+		return
+	}
+
+	if err := h.validate.Struct(input); err != nil {
+		errorutil.BadRequest(c, "your request did not pass validation", err.Error())
+		return
+	}
+
+	createdEvent, err := h.service.createEvent(*userId, uint(accountId), input)
+	if err != nil {
+		errorutil.BadRequest(c, "error", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, createdEvent)
+}
+
+func (h *handler) deleteEvent(c *gin.Context) {
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	eventId, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		errorutil.BadRequest(c, err.Error(), "the id must be an integer")
+		return
+	}
+
+	if err := h.service.deleteEvent(*userId, uint(eventId)); err != nil {
+		h.logger.Info(err.Error())
+		errorutil.NotFound(c, err.Error(), "Not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"ok": true,
+	})
+}
+
+func (h *handler) getHoldings(c *gin.Context) {
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	accountId, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		errorutil.BadRequest(c, err.Error(), "the id must be an integer")
+		return
+	}
+
+	holdings, err := h.service.getHoldings(*userId, uint(accountId))
+	if err != nil {
+		errorutil.NotFound(c, err.Error(), "Not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, holdings)
+}
+
+func (h *handler) getOpenLots(c *gin.Context) {
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	accountId, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		errorutil.BadRequest(c, err.Error(), "the id must be an integer")
+		return
+	}
+
+	lots, err := h.service.getOpenLots(*userId, uint(accountId))
+	if err != nil {
+		errorutil.NotFound(c, err.Error(), "Not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, lots)
+}
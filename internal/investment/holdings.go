@@ -0,0 +1,118 @@
+package investment
+
+import (
+	"sort"
+	"time"
+
+	"github.com/emPeeGee/raffinance/internal/entity"
+)
+
+// position accumulates the events of a single security on a single account
+type position struct {
+	security  SecurityShortResponse
+	ledger    *lotLedger
+	dividends float64
+	// lastTradePrice is used as market price when the security has no price history
+	lastTradePrice float64
+	lastTradeDate  time.Time
+}
+
+func (p *position) quantity() float64 {
+	return p.ledger.held()
+}
+
+// costBasis is the cost of the lots which are still open
+func (p *position) costBasis() float64 {
+	var cost float64
+	for _, lot := range p.ledger.lots {
+		cost += lot.Remaining * lot.UnitCost
+	}
+
+	return cost
+}
+
+// quantityEpsilon absorbs float rounding when a position is sold entirely
+const quantityEpsilon = 1e-9
+
+type positionKey struct {
+	accountID  uint
+	securityID uint
+}
+
+// buildPositions replays the events, which must be sorted by date, and returns the positions.
+// It fails when a sell exceeds the quantity held at its date
+func buildPositions(events []entity.SecurityEvent) (map[positionKey]*position, error) {
+	ledgers, err := matchLots(events)
+	if err != nil {
+		return nil, err
+	}
+
+	positions := make(map[positionKey]*position)
+	for _, event := range events {
+		key := positionKey{event.AccountID, event.SecurityID}
+		p, ok := positions[key]
+		if !ok {
+			p = &position{security: EventToResponse(&event).Security, ledger: ledgers[key]}
+			positions[key] = p
+		}
+
+		switch EventType(event.Type) {
+		case BUY, SELL:
+			p.lastTradePrice, p.lastTradeDate = event.UnitPrice, event.Date
+		case DIVIDEND:
+			p.dividends += event.Quantity*event.UnitPrice - event.Fee
+		}
+	}
+
+	return positions, nil
+}
+
+// computeHoldings values every position of the events with the latest known prices, grouped by account
+func computeHoldings(events []entity.SecurityEvent, prices map[uint]entity.SecurityPrice) (map[uint][]Holding, error) {
+	positions, err := buildPositions(events)
+	if err != nil {
+		return nil, err
+	}
+
+	holdings := make(map[uint][]Holding)
+	for key, p := range positions {
+		quantity := p.quantity()
+		if quantity < quantityEpsilon && p.dividends == 0 {
+			continue
+		}
+
+		holding := Holding{
+			Security:  p.security,
+			Quantity:  quantity,
+			CostBasis: p.costBasis(),
+			Price:     p.lastTradePrice,
+			Dividends: p.dividends,
+		}
+
+		if price, ok := prices[key.securityID]; ok && !price.Date.Before(truncateToDay(p.lastTradeDate)) {
+			priceDate := price.Date
+			holding.Price = price.Price
+			holding.PriceDate = &priceDate
+		} else if !p.lastTradeDate.IsZero() {
+			tradeDate := p.lastTradeDate
+			holding.PriceDate = &tradeDate
+		}
+
+		holding.MarketValue = holding.Quantity * holding.Price
+		holding.UnrealizedGain = holding.MarketValue - holding.CostBasis
+
+		holdings[key.accountID] = append(holdings[key.accountID], holding)
+	}
+
+	for _, accountHoldings := range holdings {
+		sort.Slice(accountHoldings, func(i, j int) bool {
+			return accountHoldings[i].Security.Symbol < accountHoldings[j].Security.Symbol
+		})
+	}
+
+	return holdings, nil
+}
+
+func truncateToDay(date time.Time) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,165 @@
+package investment
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/emPeeGee/raffinance/internal/entity"
+	"gorm.io/gorm"
+)
+
+var (
+	testAAPL = entity.Security{Model: gorm.Model{ID: 1}, Symbol: "AAPL", Name: "Apple", Currency: "USD"}
+	testMSFT = entity.Security{Model: gorm.Model{ID: 2}, Symbol: "MSFT", Name: "Microsoft", Currency: "USD"}
+)
+
+// testEvent is an event of account 1, its id is its position in the list
+func testEvent(id uint, security entity.Security, eventType EventType, date string, quantity, unitPrice, fee float64) entity.SecurityEvent {
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		panic(err)
+	}
+
+	return entity.SecurityEvent{
+		Model:      gorm.Model{ID: id},
+		AccountID:  1,
+		SecurityID: security.ID,
+		Security:   security,
+		Type:       string(eventType),
+		Date:       day,
+		Quantity:   quantity,
+		UnitPrice:  unitPrice,
+		Fee:        fee,
+	}
+}
+
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestComputeHoldings(t *testing.T) {
+	pricedAt := func(date string, price float64) entity.SecurityPrice {
+		day, _ := time.Parse("2006-01-02", date)
+		return entity.SecurityPrice{Date: day, Price: price}
+	}
+
+	type expected struct {
+		symbol      string
+		quantity    float64
+		costBasis   float64
+		price       float64
+		marketValue float64
+		dividends   float64
+	}
+
+	tests := []struct {
+		name     string
+		events   []entity.SecurityEvent
+		prices   map[uint]entity.SecurityPrice
+		holdings []expected
+	}{
+		{
+			name: "a buy valued at the last trade without prices",
+			events: []entity.SecurityEvent{
+				testEvent(1, testAAPL, BUY, "2024-01-10", 10, 100, 10),
+			},
+			holdings: []expected{{symbol: "AAPL", quantity: 10, costBasis: 1010, price: 100, marketValue: 1000}},
+		},
+		{
+			name: "a partial sell keeps the cost of the open quantity",
+			events: []entity.SecurityEvent{
+				testEvent(1, testAAPL, BUY, "2024-01-10", 10, 100, 10),
+				testEvent(2, testAAPL, SELL, "2024-02-10", 4, 120, 0),
+			},
+			holdings: []expected{{symbol: "AAPL", quantity: 6, costBasis: 606, price: 120, marketValue: 720}},
+		},
+		{
+			name: "the latest price after the last trade values the holding",
+			events: []entity.SecurityEvent{
+				testEvent(1, testAAPL, BUY, "2024-01-10", 10, 100, 0),
+			},
+			prices:   map[uint]entity.SecurityPrice{testAAPL.ID: pricedAt("2024-03-01", 150)},
+			holdings: []expected{{symbol: "AAPL", quantity: 10, costBasis: 1000, price: 150, marketValue: 1500}},
+		},
+		{
+			name: "a price older than the last trade is ignored",
+			events: []entity.SecurityEvent{
+				testEvent(1, testAAPL, BUY, "2024-01-10", 10, 100, 0),
+				testEvent(2, testAAPL, BUY, "2024-03-10", 10, 110, 0),
+			},
+			prices:   map[uint]entity.SecurityPrice{testAAPL.ID: pricedAt("2024-03-01", 150)},
+			holdings: []expected{{symbol: "AAPL", quantity: 20, costBasis: 2100, price: 110, marketValue: 2200}},
+		},
+		{
+			name: "a position sold entirely is left out",
+			events: []entity.SecurityEvent{
+				testEvent(1, testAAPL, BUY, "2024-01-10", 10, 100, 0),
+				testEvent(2, testMSFT, BUY, "2024-01-10", 5, 300, 0),
+				testEvent(3, testAAPL, SELL, "2024-02-10", 10, 120, 0),
+			},
+			holdings: []expected{{symbol: "MSFT", quantity: 5, costBasis: 1500, price: 300, marketValue: 1500}},
+		},
+		{
+			name: "a position sold entirely with dividends is kept",
+			events: []entity.SecurityEvent{
+				testEvent(1, testAAPL, BUY, "2024-01-10", 10, 100, 0),
+				testEvent(2, testAAPL, DIVIDEND, "2024-01-20", 10, 0.5, 1),
+				testEvent(3, testAAPL, SELL, "2024-02-10", 10, 120, 0),
+			},
+			holdings: []expected{{symbol: "AAPL", quantity: 0, costBasis: 0, price: 120, marketValue: 0, dividends: 4}},
+		},
+		{
+			name: "the holdings are sorted by symbol",
+			events: []entity.SecurityEvent{
+				testEvent(1, testMSFT, BUY, "2024-01-10", 1, 300, 0),
+				testEvent(2, testAAPL, BUY, "2024-01-11", 2, 100, 0),
+			},
+			holdings: []expected{
+				{symbol: "AAPL", quantity: 2, costBasis: 200, price: 100, marketValue: 200},
+				{symbol: "MSFT", quantity: 1, costBasis: 300, price: 300, marketValue: 300},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			holdings, err := computeHoldings(test.events, test.prices)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := holdings[1]
+			if len(got) != len(test.holdings) {
+				t.Fatalf("got %d holdings, expected %d", len(got), len(test.holdings))
+			}
+
+			for i, want := range test.holdings {
+				h := got[i]
+				if h.Security.Symbol != want.symbol ||
+					!closeTo(h.Quantity, want.quantity) ||
+					!closeTo(h.CostBasis, want.costBasis) ||
+					!closeTo(h.Price, want.price) ||
+					!closeTo(h.MarketValue, want.marketValue) ||
+					!closeTo(h.Dividends, want.dividends) {
+					t.Errorf("holding %d is %+v, expected %+v", i, h, want)
+				}
+
+				if !closeTo(h.UnrealizedGain, h.MarketValue-h.CostBasis) {
+					t.Errorf("unrealized gain of %s is %f, expected %f", h.Security.Symbol, h.UnrealizedGain, h.MarketValue-h.CostBasis)
+				}
+			}
+		})
+	}
+}
+
+func TestComputeHoldingsRejectsOverSell(t *testing.T) {
+	events := []entity.SecurityEvent{
+		testEvent(1, testAAPL, BUY, "2024-01-10", 10, 100, 0),
+		testEvent(2, testAAPL, SELL, "2024-02-10", 11, 120, 0),
+	}
+
+	if _, err := computeHoldings(events, nil); err == nil {
+		t.Error("selling more than held was accepted")
+	}
+}
@@ -0,0 +1,188 @@
+package investment
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/emPeeGee/raffinance/internal/entity"
+)
+
+// Lot is the quantity acquired by a single buy event
+type Lot struct {
+	// ID is the id of the buy event which opened the lot
+	ID         uint                  `json:"id"`
+	AccountID  uint                  `json:"accountId"`
+	Security   SecurityShortResponse `json:"security"`
+	AcquiredAt time.Time             `json:"acquiredAt"`
+	Quantity   float64               `json:"quantity"`
+	Remaining  float64               `json:"remaining"`
+	// UnitCost includes the buy fee spread over the quantity
+	UnitCost float64 `json:"unitCost"`
+}
+
+// LotSale is the part of a sell event matched against a single lot
+type LotSale struct {
+	SaleID      uint                  `json:"saleId"`
+	LotID       uint                  `json:"lotId"`
+	AccountID   uint                  `json:"accountId"`
+	Security    SecurityShortResponse `json:"security"`
+	LotMethod   LotMethod             `json:"lotMethod"`
+	AcquiredAt  time.Time             `json:"acquiredAt"`
+	SoldAt      time.Time             `json:"soldAt"`
+	Quantity    float64               `json:"quantity"`
+	CostBasis   float64               `json:"costBasis"`
+	Proceeds    float64               `json:"proceeds"`
+	Gain        float64               `json:"gain"`
+	HoldingDays int                   `json:"holdingDays"`
+}
+
+// lotLedger replays the events of one security on one account
+type lotLedger struct {
+	lots  []*Lot
+	sales []LotSale
+}
+
+func (l *lotLedger) open(event entity.SecurityEvent, security SecurityShortResponse) {
+	l.lots = append(l.lots, &Lot{
+		ID:         event.ID,
+		AccountID:  event.AccountID,
+		Security:   security,
+		AcquiredAt: event.Date,
+		Quantity:   event.Quantity,
+		Remaining:  event.Quantity,
+		UnitCost:   event.UnitPrice + event.Fee/event.Quantity,
+	})
+}
+
+func (l *lotLedger) held() float64 {
+	var quantity float64
+	for _, lot := range l.lots {
+		quantity += lot.Remaining
+	}
+
+	return quantity
+}
+
+// candidates returns the open lots in the order the method consumes them
+func (l *lotLedger) candidates(event entity.SecurityEvent, method LotMethod) ([]*Lot, error) {
+	var open []*Lot
+	for _, lot := range l.lots {
+		if lot.Remaining > quantityEpsilon {
+			open = append(open, lot)
+		}
+	}
+
+	switch method {
+	case FIFO:
+		return open, nil
+	case LIFO:
+		reversed := make([]*Lot, 0, len(open))
+		for i := len(open) - 1; i >= 0; i-- {
+			reversed = append(reversed, open[i])
+		}
+
+		return reversed, nil
+	case SPECIFIC:
+		if event.LotID == nil {
+			return nil, fmt.Errorf("sell %d uses specific lot matching but has no lot", event.ID)
+		}
+
+		for _, lot := range open {
+			if lot.ID == *event.LotID {
+				return []*Lot{lot}, nil
+			}
+		}
+
+		return nil, fmt.Errorf("lot %d is not open on %s", *event.LotID, event.Date.Format("2006-01-02"))
+	}
+
+	return nil, fmt.Errorf("unknown lot method %s", method)
+}
+
+func (l *lotLedger) sell(event entity.SecurityEvent, security SecurityShortResponse) error {
+	method := LotMethod(event.LotMethod)
+	if method == "" {
+		method = FIFO
+	}
+
+	lots, err := l.candidates(event, method)
+	if err != nil {
+		return err
+	}
+
+	var available float64
+	for _, lot := range lots {
+		available += lot.Remaining
+	}
+
+	if event.Quantity > available+quantityEpsilon {
+		return fmt.Errorf("cannot sell %f of %s on %s, only %f available",
+			event.Quantity, security.Symbol, event.Date.Format("2006-01-02"), available)
+	}
+
+	left := event.Quantity
+	for _, lot := range lots {
+		if left <= quantityEpsilon {
+			break
+		}
+
+		quantity := lot.Remaining
+		if left < quantity {
+			quantity = left
+		}
+
+		// The sell fee is shared by the matched lots in proportion to the quantity
+		proceeds := quantity*event.UnitPrice - event.Fee*(quantity/event.Quantity)
+		costBasis := quantity * lot.UnitCost
+
+		l.sales = append(l.sales, LotSale{
+			SaleID:      event.ID,
+			LotID:       lot.ID,
+			AccountID:   event.AccountID,
+			Security:    security,
+			LotMethod:   method,
+			AcquiredAt:  lot.AcquiredAt,
+			SoldAt:      event.Date,
+			Quantity:    quantity,
+			CostBasis:   costBasis,
+			Proceeds:    proceeds,
+			Gain:        proceeds - costBasis,
+			HoldingDays: int(event.Date.Sub(lot.AcquiredAt).Hours() / 24),
+		})
+
+		lot.Remaining -= quantity
+		if lot.Remaining < quantityEpsilon {
+			lot.Remaining = 0
+		}
+		left -= quantity
+	}
+
+	return nil
+}
+
+// matchLots replays the events, which must be sorted by date, and matches every sell to the lots it consumes
+func matchLots(events []entity.SecurityEvent) (map[positionKey]*lotLedger, error) {
+	ledgers := make(map[positionKey]*lotLedger)
+
+	for _, event := range events {
+		key := positionKey{event.AccountID, event.SecurityID}
+		ledger, ok := ledgers[key]
+		if !ok {
+			ledger = &lotLedger{}
+			ledgers[key] = ledger
+		}
+
+		security := EventToResponse(&event).Security
+
+		switch EventType(event.Type) {
+		case BUY:
+			ledger.open(event, security)
+		case SELL:
+			if err := ledger.sell(event, security); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return ledgers, nil
+}
@@ -0,0 +1,257 @@
+package investment
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/emPeeGee/raffinance/internal/entity"
+)
+
+// sellWith is a sell of the method, from the lot when given
+func sellWith(event entity.SecurityEvent, method LotMethod, lotID *uint) entity.SecurityEvent {
+	event.LotMethod = string(method)
+	event.LotID = lotID
+	return event
+}
+
+func lotID(id uint) *uint {
+	return &id
+}
+
+// testLots buys AAPL three times: 10 at 100, 10 at 110 and 10 at 120
+func testLots() []entity.SecurityEvent {
+	return []entity.SecurityEvent{
+		testEvent(1, testAAPL, BUY, "2023-01-10", 10, 100, 0),
+		testEvent(2, testAAPL, BUY, "2023-02-10", 10, 110, 0),
+		testEvent(3, testAAPL, BUY, "2023-03-10", 10, 120, 0),
+	}
+}
+
+func TestMatchLots(t *testing.T) {
+	type match struct {
+		lot       uint
+		quantity  float64
+		costBasis float64
+	}
+
+	tests := []struct {
+		name      string
+		sell      entity.SecurityEvent
+		matches   []match
+		remaining map[uint]float64
+	}{
+		{
+			name:      "FIFO takes the oldest lots first",
+			sell:      sellWith(testEvent(4, testAAPL, SELL, "2023-04-10", 15, 130, 0), FIFO, nil),
+			matches:   []match{{lot: 1, quantity: 10, costBasis: 1000}, {lot: 2, quantity: 5, costBasis: 550}},
+			remaining: map[uint]float64{1: 0, 2: 5, 3: 10},
+		},
+		{
+			name:      "FIFO is the default",
+			sell:      testEvent(4, testAAPL, SELL, "2023-04-10", 5, 130, 0),
+			matches:   []match{{lot: 1, quantity: 5, costBasis: 500}},
+			remaining: map[uint]float64{1: 5, 2: 10, 3: 10},
+		},
+		{
+			name:      "LIFO takes the newest lots first",
+			sell:      sellWith(testEvent(4, testAAPL, SELL, "2023-04-10", 15, 130, 0), LIFO, nil),
+			matches:   []match{{lot: 3, quantity: 10, costBasis: 1200}, {lot: 2, quantity: 5, costBasis: 550}},
+			remaining: map[uint]float64{1: 10, 2: 5, 3: 0},
+		},
+		{
+			name:      "SPECIFIC takes the lot given",
+			sell:      sellWith(testEvent(4, testAAPL, SELL, "2023-04-10", 4, 130, 0), SPECIFIC, lotID(2)),
+			matches:   []match{{lot: 2, quantity: 4, costBasis: 440}},
+			remaining: map[uint]float64{1: 10, 2: 6, 3: 10},
+		},
+		{
+			name:      "a whole position",
+			sell:      sellWith(testEvent(4, testAAPL, SELL, "2023-04-10", 30, 130, 0), LIFO, nil),
+			matches:   []match{{lot: 3, quantity: 10, costBasis: 1200}, {lot: 2, quantity: 10, costBasis: 1100}, {lot: 1, quantity: 10, costBasis: 1000}},
+			remaining: map[uint]float64{1: 0, 2: 0, 3: 0},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledgers, err := matchLots(append(testLots(), test.sell))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ledger := ledgers[positionKey{1, testAAPL.ID}]
+			if len(ledger.sales) != len(test.matches) {
+				t.Fatalf("the sell matched %d lots, expected %d", len(ledger.sales), len(test.matches))
+			}
+
+			for i, want := range test.matches {
+				sale := ledger.sales[i]
+				if sale.LotID != want.lot || !closeTo(sale.Quantity, want.quantity) || !closeTo(sale.CostBasis, want.costBasis) {
+					t.Errorf("match %d took %f of lot %d for %f, expected %f of lot %d for %f",
+						i, sale.Quantity, sale.LotID, sale.CostBasis, want.quantity, want.lot, want.costBasis)
+				}
+
+				if !closeTo(sale.Proceeds, sale.Quantity*130) || !closeTo(sale.Gain, sale.Proceeds-sale.CostBasis) {
+					t.Errorf("match %d has proceeds %f and gain %f", i, sale.Proceeds, sale.Gain)
+				}
+			}
+
+			for _, lot := range ledger.lots {
+				if !closeTo(lot.Remaining, test.remaining[lot.ID]) {
+					t.Errorf("lot %d has %f left, expected %f", lot.ID, lot.Remaining, test.remaining[lot.ID])
+				}
+			}
+		})
+	}
+}
+
+func TestMatchLotsPartialLotsAcrossSells(t *testing.T) {
+	events := append(testLots(),
+		testEvent(4, testAAPL, SELL, "2023-04-10", 7, 130, 0),
+		testEvent(5, testAAPL, SELL, "2023-05-10", 7, 140, 0),
+	)
+
+	ledgers, err := matchLots(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ledger := ledgers[positionKey{1, testAAPL.ID}]
+
+	// The second sell finishes the first lot, then starts the second one
+	want := []struct {
+		sale, lot uint
+		quantity  float64
+	}{{4, 1, 7}, {5, 1, 3}, {5, 2, 4}}
+
+	if len(ledger.sales) != len(want) {
+		t.Fatalf("got %d lot sales, expected %d", len(ledger.sales), len(want))
+	}
+
+	for i, w := range want {
+		sale := ledger.sales[i]
+		if sale.SaleID != w.sale || sale.LotID != w.lot || !closeTo(sale.Quantity, w.quantity) {
+			t.Errorf("lot sale %d is %f of lot %d by sale %d, expected %f of lot %d by sale %d",
+				i, sale.Quantity, sale.LotID, sale.SaleID, w.quantity, w.lot, w.sale)
+		}
+	}
+
+	if held := ledger.held(); !closeTo(held, 16) {
+		t.Errorf("%f are held, expected 16", held)
+	}
+}
+
+func TestMatchLotsErrors(t *testing.T) {
+	msftLot := testEvent(10, testMSFT, BUY, "2023-01-15", 5, 300, 0)
+
+	tests := []struct {
+		name   string
+		events []entity.SecurityEvent
+		err    string
+	}{
+		{
+			name:   "over-sell",
+			events: append(testLots(), testEvent(4, testAAPL, SELL, "2023-04-10", 31, 130, 0)),
+			err:    "cannot sell 31.000000 of AAPL on 2023-04-10, only 30.000000 available",
+		},
+		{
+			name:   "sell before the buy",
+			events: []entity.SecurityEvent{testEvent(1, testAAPL, SELL, "2023-01-01", 1, 100, 0)},
+			err:    "only 0.000000 available",
+		},
+		{
+			name:   "over-sell of a specific lot",
+			events: append(testLots(), sellWith(testEvent(4, testAAPL, SELL, "2023-04-10", 11, 130, 0), SPECIFIC, lotID(2))),
+			err:    "only 10.000000 available",
+		},
+		{
+			name:   "specific lot of another security",
+			events: append(append(testLots(), msftLot), sellWith(testEvent(4, testAAPL, SELL, "2023-04-10", 1, 130, 0), SPECIFIC, lotID(10))),
+			err:    "lot 10 is not open on 2023-04-10",
+		},
+		{
+			name: "specific lot already sold",
+			events: append(testLots(),
+				sellWith(testEvent(4, testAAPL, SELL, "2023-04-10", 10, 130, 0), SPECIFIC, lotID(1)),
+				sellWith(testEvent(5, testAAPL, SELL, "2023-05-10", 1, 130, 0), SPECIFIC, lotID(1)),
+			),
+			err: "lot 1 is not open on 2023-05-10",
+		},
+		{
+			name:   "specific without a lot",
+			events: append(testLots(), sellWith(testEvent(4, testAAPL, SELL, "2023-04-10", 1, 130, 0), SPECIFIC, nil)),
+			err:    "sell 4 uses specific lot matching but has no lot",
+		},
+		{
+			name:   "unknown method",
+			events: append(testLots(), sellWith(testEvent(4, testAAPL, SELL, "2023-04-10", 1, 130, 0), "HIFO", nil)),
+			err:    "unknown lot method HIFO",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := matchLots(test.events)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, expected %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestMatchLotsProratesFees(t *testing.T) {
+	events := []entity.SecurityEvent{
+		// The buy fee is part of the cost: 100 + 20/10 = 102 per share
+		testEvent(1, testAAPL, BUY, "2023-01-10", 10, 100, 20),
+		testEvent(2, testAAPL, BUY, "2023-02-10", 10, 110, 0),
+		// The sell fee is shared in proportion to the quantity taken from each lot
+		testEvent(3, testAAPL, SELL, "2023-03-10", 15, 130, 30),
+	}
+
+	ledgers, err := matchLots(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ledger := ledgers[positionKey{1, testAAPL.ID}]
+	if !closeTo(ledger.lots[0].UnitCost, 102) {
+		t.Errorf("unit cost is %f, expected 102", ledger.lots[0].UnitCost)
+	}
+
+	want := []struct {
+		costBasis float64
+		proceeds  float64
+	}{
+		{costBasis: 1020, proceeds: 10*130 - 20},
+		{costBasis: 550, proceeds: 5*130 - 10},
+	}
+
+	for i, w := range want {
+		sale := ledger.sales[i]
+		if !closeTo(sale.CostBasis, w.costBasis) || !closeTo(sale.Proceeds, w.proceeds) || !closeTo(sale.Gain, w.proceeds-w.costBasis) {
+			t.Errorf("lot sale %d has cost %f, proceeds %f and gain %f, expected %f, %f and %f",
+				i, sale.CostBasis, sale.Proceeds, sale.Gain, w.costBasis, w.proceeds, w.proceeds-w.costBasis)
+		}
+	}
+}
+
+func TestMatchLotsHoldingDays(t *testing.T) {
+	events := []entity.SecurityEvent{
+		testEvent(1, testAAPL, BUY, "2022-12-20", 10, 100, 0),
+		testEvent(2, testAAPL, SELL, "2024-01-05", 10, 130, 0),
+	}
+
+	ledgers, err := matchLots(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sale := ledgers[positionKey{1, testAAPL.ID}].sales[0]
+	if sale.HoldingDays != 381 {
+		t.Errorf("held %d days, expected 381", sale.HoldingDays)
+	}
+
+	if sale.AcquiredAt.Year() != 2022 || sale.SoldAt.Year() != 2024 {
+		t.Errorf("acquired on %s and sold on %s", sale.AcquiredAt, sale.SoldAt)
+	}
+}
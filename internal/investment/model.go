@@ -0,0 +1,98 @@
+package investment
+
+import (
+	"time"
+)
+
+type securityResponse struct {
+	ID        uint      `json:"id"`
+	Symbol    string    `json:"symbol"`
+	Name      string    `json:"name"`
+	Currency  string    `json:"currency"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type SecurityShortResponse struct {
+	ID       uint   `json:"id"`
+	Symbol   string `json:"symbol"`
+	Name     string `json:"name"`
+	Currency string `json:"currency"`
+}
+
+type createSecurityDTO struct {
+	Symbol   string `json:"symbol" validate:"required,min=1,max=32"`
+	Name     string `json:"name" validate:"required,min=1,max=256"`
+	Currency string `json:"currency" validate:"required,currency,min=2,max=10"`
+}
+
+type updateSecurityDTO struct {
+	Symbol   string `json:"symbol" validate:"required,min=1,max=32"`
+	Name     string `json:"name" validate:"required,min=1,max=256"`
+	Currency string `json:"currency" validate:"required,currency,min=2,max=10"`
+}
+
+type priceResponse struct {
+	Date   time.Time `json:"date"`
+	Price  float64   `json:"price"`
+	Source string    `json:"source"`
+}
+
+type createPriceDTO struct {
+	Date  time.Time `json:"date" validate:"required"`
+	Price float64   `json:"price" validate:"required,gt=0"`
+}
+
+type EventResponse struct {
+	ID          uint                  `json:"id"`
+	AccountID   uint                  `json:"accountId"`
+	Security    SecurityShortResponse `json:"security"`
+	Type        EventType             `json:"type"`
+	Date        time.Time             `json:"date"`
+	Quantity    float64               `json:"quantity"`
+	UnitPrice   float64               `json:"unitPrice"`
+	Fee         float64               `json:"fee"`
+	Description string                `json:"description"`
+	LotMethod   LotMethod             `json:"lotMethod,omitempty"`
+	LotID       *uint                 `json:"lotId,omitempty"`
+	// TransactionID is the transaction which moved the cash of the event
+	TransactionID *uint     `json:"transactionId,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+type createEventDTO struct {
+	SecurityID  uint      `json:"securityId" validate:"required,numeric"`
+	Type        EventType `json:"type" validate:"required,oneof=BUY SELL DIVIDEND"`
+	Date        time.Time `json:"date" validate:"required"`
+	Quantity    float64   `json:"quantity" validate:"required,gt=0"`
+	UnitPrice   float64   `json:"unitPrice" validate:"required,gt=0"`
+	Fee         float64   `json:"fee" validate:"gte=0"`
+	Description string    `json:"description" validate:"omitempty,max=256"`
+	// LotMethod and LotID are used only by sells, FIFO is the default
+	LotMethod LotMethod `json:"lotMethod" validate:"omitempty,oneof=FIFO LIFO SPECIFIC"`
+	LotID     *uint     `json:"lotId" validate:"omitempty,numeric,gt=0"`
+}
+
+type Holding struct {
+	Security       SecurityShortResponse `json:"security"`
+	Quantity       float64               `json:"quantity"`
+	CostBasis      float64               `json:"costBasis"`
+	Price          float64               `json:"price"`
+	PriceDate      *time.Time            `json:"priceDate"`
+	MarketValue    float64               `json:"marketValue"`
+	UnrealizedGain float64               `json:"unrealizedGain"`
+	Dividends      float64               `json:"dividends"`
+}
+
+type holdingsResponse struct {
+	AccountID      uint      `json:"accountId"`
+	Holdings       []Holding `json:"holdings"`
+	CostBasis      float64   `json:"costBasis"`
+	MarketValue    float64   `json:"marketValue"`
+	UnrealizedGain float64   `json:"unrealizedGain"`
+}
+
+type importResultResponse struct {
+	Imported int `json:"imported"`
+}
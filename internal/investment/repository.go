@@ -0,0 +1,352 @@
+package investment
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/emPeeGee/raffinance/internal/entity"
+	"github.com/emPeeGee/raffinance/pkg/log"
+	"github.com/emPeeGee/raffinance/pkg/util"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Repository interface {
+	getSecurities(userId uint) ([]securityResponse, error)
+	createSecurity(userId uint, security createSecurityDTO) (*securityResponse, error)
+	updateSecurity(userId, securityId uint, security updateSecurityDTO) (*securityResponse, error)
+	deleteSecurity(userId, id uint) error
+	securityExistsAndBelongsToUser(userId, id uint, symbol string) (bool, error)
+	symbolIsUsed(userId, exceptId uint, symbol string) (bool, error)
+	securityIsUsed(securityId uint) error
+
+	getPrices(securityId uint) ([]priceResponse, error)
+	savePrices(securityId uint, prices []createPriceDTO, source string) error
+	getLatestPrices(securityIds []uint) (map[uint]entity.SecurityPrice, error)
+
+	getEvents(accountIds []uint) ([]entity.SecurityEvent, error)
+	getEvent(id uint) (*entity.SecurityEvent, error)
+	createEvent(accountId uint, event createEventDTO) (*EventResponse, error)
+	setEventTransaction(id, transactionId uint) error
+	deleteEvent(id uint) error
+	eventExistsAndBelongsToUser(userId, id uint) (bool, error)
+
+	investmentAccountExistsAndBelongsToUser(userId, accountId uint) (bool, error)
+	getInvestmentAccountIds(userId uint) ([]uint, error)
+}
+
+type repository struct {
+	db     *gorm.DB
+	logger log.Logger
+}
+
+func NewInvestmentRepository(db *gorm.DB, logger log.Logger) *repository {
+	return &repository{db: db, logger: logger}
+}
+
+func (r *repository) getSecurities(userId uint) ([]securityResponse, error) {
+	var securities = make([]securityResponse, 0)
+
+	if err := r.db.Model(&entity.Security{}).
+		Where("user_id = ?", userId).
+		Order("symbol ASC").
+		Find(&securities).Error; err != nil {
+		return nil, err
+	}
+
+	return securities, nil
+}
+
+func (r *repository) createSecurity(userId uint, security createSecurityDTO) (*securityResponse, error) {
+	newSecurity := entity.Security{
+		Symbol:   security.Symbol,
+		Name:     security.Name,
+		Currency: security.Currency,
+		UserID:   &userId,
+	}
+
+	if err := r.db.Create(&newSecurity).Error; err != nil {
+		return nil, err
+	}
+
+	r.logger.Info("new security, ", util.StringifyAny(newSecurity))
+	createdSecurity := &securityResponse{
+		ID:        newSecurity.ID,
+		Symbol:    newSecurity.Symbol,
+		Name:      newSecurity.Name,
+		Currency:  newSecurity.Currency,
+		CreatedAt: newSecurity.CreatedAt,
+		UpdatedAt: newSecurity.UpdatedAt,
+	}
+
+	return createdSecurity, nil
+}
+
+func (r *repository) updateSecurity(userId, securityId uint, security updateSecurityDTO) (*securityResponse, error) {
+	if err := r.db.Model(&entity.Security{}).Where("id = ?", securityId).Updates(map[string]interface{}{
+		"symbol":   security.Symbol,
+		"name":     security.Name,
+		"currency": security.Currency,
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	var updatedSecurity securityResponse
+	if err := r.db.Model(&entity.Security{}).First(&updatedSecurity, securityId).Error; err != nil {
+		return nil, err
+	}
+
+	return &updatedSecurity, nil
+}
+
+func (r *repository) deleteSecurity(userId, id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("security_id = ?", id).Delete(&entity.SecurityPrice{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&entity.Security{}, id).Error
+	})
+}
+
+// if id is 0, will search by symbol
+func (r *repository) securityExistsAndBelongsToUser(userId, id uint, symbol string) (bool, error) {
+	var count int64
+	var whereClause string
+	var values []interface{}
+
+	whereClause = "user_id = ?"
+	values = append(values, userId)
+
+	if id > 0 {
+		whereClause += " AND id = ?"
+		values = append(values, id)
+	} else if symbol != "" {
+		whereClause += " AND symbol = ?"
+		values = append(values, symbol)
+	} else {
+		return false, errors.New("id or symbol parameter is required")
+	}
+
+	if err := r.db.Model(&entity.Security{}).
+		Where(whereClause, values...).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// symbolIsUsed tells whether another security of the user has the symbol
+func (r *repository) symbolIsUsed(userId, exceptId uint, symbol string) (bool, error) {
+	var count int64
+
+	if err := r.db.Model(&entity.Security{}).
+		Where("user_id = ? AND symbol = ? AND id <> ?", userId, symbol, exceptId).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (r *repository) securityIsUsed(securityId uint) error {
+	var count int64
+
+	if err := r.db.Model(&entity.SecurityEvent{}).
+		Where("security_id = ?", securityId).
+		Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return fmt.Errorf("cannot delete security %d that is used in %d events", securityId, count)
+	}
+
+	return nil
+}
+
+func (r *repository) getPrices(securityId uint) ([]priceResponse, error) {
+	var prices = make([]priceResponse, 0)
+
+	if err := r.db.Model(&entity.SecurityPrice{}).
+		Where("security_id = ?", securityId).
+		Order("date DESC").
+		Find(&prices).Error; err != nil {
+		return nil, err
+	}
+
+	return prices, nil
+}
+
+// savePrices inserts the prices, a price for an already known date overwrites the old one
+func (r *repository) savePrices(securityId uint, prices []createPriceDTO, source string) error {
+	if len(prices) == 0 {
+		return nil
+	}
+
+	newPrices := make([]entity.SecurityPrice, 0, len(prices))
+	for _, p := range prices {
+		newPrices = append(newPrices, entity.SecurityPrice{
+			SecurityID: securityId,
+			Date:       time.Date(p.Date.Year(), p.Date.Month(), p.Date.Day(), 0, 0, 0, 0, time.UTC),
+			Price:      p.Price,
+			Source:     source,
+		})
+	}
+
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "security_id"}, {Name: "date"}},
+		DoUpdates: clause.AssignmentColumns([]string{"price", "source", "updated_at", "deleted_at"}),
+	}).Create(&newPrices).Error
+}
+
+// getLatestPrices returns the most recent known price of each security, keyed by security id
+func (r *repository) getLatestPrices(securityIds []uint) (map[uint]entity.SecurityPrice, error) {
+	latest := make(map[uint]entity.SecurityPrice)
+	if len(securityIds) == 0 {
+		return latest, nil
+	}
+
+	var prices []entity.SecurityPrice
+	if err := r.db.Raw(`
+		SELECT DISTINCT ON (security_id) *
+		FROM security_prices
+		WHERE deleted_at IS NULL AND security_id IN (?) AND date <= ?
+		ORDER BY security_id, date DESC
+	`, securityIds, time.Now()).Scan(&prices).Error; err != nil {
+		return nil, err
+	}
+
+	for _, p := range prices {
+		latest[p.SecurityID] = p
+	}
+
+	return latest, nil
+}
+
+// getEvents returns the events of the accounts, in the order they happened
+func (r *repository) getEvents(accountIds []uint) ([]entity.SecurityEvent, error) {
+	var events []entity.SecurityEvent
+	if len(accountIds) == 0 {
+		return events, nil
+	}
+
+	if err := r.db.
+		Preload("Security").
+		Where("account_id IN (?)", accountIds).
+		Order("date ASC, id ASC").
+		Find(&events).Error; err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (r *repository) getEvent(id uint) (*entity.SecurityEvent, error) {
+	var event entity.SecurityEvent
+
+	if err := r.db.First(&event, id).Error; err != nil {
+		return nil, err
+	}
+
+	return &event, nil
+}
+
+func (r *repository) createEvent(accountId uint, event createEventDTO) (*EventResponse, error) {
+	newEvent := entity.SecurityEvent{
+		AccountID:   accountId,
+		SecurityID:  event.SecurityID,
+		Type:        string(event.Type),
+		Date:        event.Date,
+		Quantity:    event.Quantity,
+		UnitPrice:   event.UnitPrice,
+		Fee:         event.Fee,
+		Description: event.Description,
+		LotMethod:   string(event.LotMethod),
+		LotID:       event.LotID,
+	}
+
+	if err := r.db.Create(&newEvent).Error; err != nil {
+		return nil, err
+	}
+
+	if err := r.db.Preload("Security").First(&newEvent, newEvent.ID).Error; err != nil {
+		return nil, err
+	}
+
+	response := EventToResponse(&newEvent)
+	return &response, nil
+}
+
+func (r *repository) setEventTransaction(id, transactionId uint) error {
+	return r.db.Model(&entity.SecurityEvent{}).Where("id = ?", id).Update("transaction_id", transactionId).Error
+}
+
+func (r *repository) deleteEvent(id uint) error {
+	return r.db.Delete(&entity.SecurityEvent{}, id).Error
+}
+
+func (r *repository) eventExistsAndBelongsToUser(userId, id uint) (bool, error) {
+	var count int64
+
+	if err := r.db.
+		Model(&entity.SecurityEvent{}).
+		Joins("INNER JOIN accounts ON security_events.account_id = accounts.id").
+		Where("security_events.id = ? AND accounts.user_id = ?", id, userId).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (r *repository) investmentAccountExistsAndBelongsToUser(userId, accountId uint) (bool, error) {
+	var count int64
+
+	if err := r.db.Model(&entity.Account{}).
+		Where("id = ? AND user_id = ? AND investment = ?", accountId, userId, true).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (r *repository) getInvestmentAccountIds(userId uint) ([]uint, error) {
+	var ids []uint
+
+	if err := r.db.Model(&entity.Account{}).
+		Where("user_id = ? AND investment = ?", userId, true).
+		Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func EventToResponse(event *entity.SecurityEvent) EventResponse {
+	return EventResponse{
+		ID:        event.ID,
+		AccountID: event.AccountID,
+		Security: SecurityShortResponse{
+			ID:       event.Security.ID,
+			Symbol:   event.Security.Symbol,
+			Name:     event.Security.Name,
+			Currency: event.Security.Currency,
+		},
+		Type:          EventType(event.Type),
+		Date:          event.Date,
+		Quantity:      event.Quantity,
+		UnitPrice:     event.UnitPrice,
+		Fee:           event.Fee,
+		Description:   event.Description,
+		LotMethod:     LotMethod(event.LotMethod),
+		LotID:         event.LotID,
+		TransactionID: event.TransactionID,
+		CreatedAt:     event.CreatedAt,
+		UpdatedAt:     event.UpdatedAt,
+	}
+}
@@ -0,0 +1,444 @@
+package investment
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/emPeeGee/raffinance/internal/entity"
+	"github.com/emPeeGee/raffinance/internal/transaction"
+	"github.com/emPeeGee/raffinance/pkg/log"
+)
+
+type Service interface {
+	getSecurities(userId uint) ([]securityResponse, error)
+	createSecurity(userId uint, security createSecurityDTO) (*securityResponse, error)
+	updateSecurity(userId, securityId uint, security updateSecurityDTO) (*securityResponse, error)
+	deleteSecurity(userId, id uint) error
+
+	getPrices(userId, securityId uint) ([]priceResponse, error)
+	createPrice(userId, securityId uint, price createPriceDTO) error
+	importPrices(userId, securityId uint, csv io.Reader) (int, error)
+
+	getEvents(userId, accountId uint) ([]EventResponse, error)
+	createEvent(userId, accountId uint, event createEventDTO) (*EventResponse, error)
+	deleteEvent(userId, id uint) error
+
+	getHoldings(userId, accountId uint) (*holdingsResponse, error)
+	getOpenLots(userId, accountId uint) ([]Lot, error)
+	// GetMarketValues returns the market value of the holdings of every investment account of the user
+	GetMarketValues(userId uint) (map[uint]float64, error)
+	// GetLotSales returns the sells of the user matched to the lots they consumed, optionally for a single account
+	GetLotSales(userId uint, accountId *uint) ([]LotSale, error)
+}
+
+type service struct {
+	repo Repository
+	// NOTE: the events move the cash of the account with transactions
+	transactionService transaction.Service
+	logger             log.Logger
+}
+
+func NewInvestmentService(repo Repository, transactionService transaction.Service, logger log.Logger) *service {
+	return &service{repo: repo, transactionService: transactionService, logger: logger}
+}
+
+func (s *service) getSecurities(userId uint) ([]securityResponse, error) {
+	return s.repo.getSecurities(userId)
+}
+
+func (s *service) createSecurity(userId uint, security createSecurityDTO) (*securityResponse, error) {
+	// symbol should be unique per user
+	exists, err := s.repo.securityExistsAndBelongsToUser(userId, 0, security.Symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		return nil, fmt.Errorf("security with symbol %s exists", security.Symbol)
+	}
+
+	return s.repo.createSecurity(userId, security)
+}
+
+func (s *service) updateSecurity(userId, securityId uint, security updateSecurityDTO) (*securityResponse, error) {
+	if err := s.checkSecurity(userId, securityId); err != nil {
+		return nil, err
+	}
+
+	// symbol should stay unique per user
+	used, err := s.repo.symbolIsUsed(userId, securityId, security.Symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	if used {
+		return nil, fmt.Errorf("security with symbol %s exists", security.Symbol)
+	}
+
+	return s.repo.updateSecurity(userId, securityId, security)
+}
+
+func (s *service) deleteSecurity(userId, id uint) error {
+	if err := s.checkSecurity(userId, id); err != nil {
+		return err
+	}
+
+	if err := s.repo.securityIsUsed(id); err != nil {
+		return err
+	}
+
+	return s.repo.deleteSecurity(userId, id)
+}
+
+func (s *service) getPrices(userId, securityId uint) ([]priceResponse, error) {
+	if err := s.checkSecurity(userId, securityId); err != nil {
+		return nil, err
+	}
+
+	return s.repo.getPrices(securityId)
+}
+
+func (s *service) createPrice(userId, securityId uint, price createPriceDTO) error {
+	if err := s.checkSecurity(userId, securityId); err != nil {
+		return err
+	}
+
+	return s.repo.savePrices(securityId, []createPriceDTO{price}, priceSourceManual)
+}
+
+func (s *service) importPrices(userId, securityId uint, csv io.Reader) (int, error) {
+	if err := s.checkSecurity(userId, securityId); err != nil {
+		return 0, err
+	}
+
+	prices, err := parsePricesCSV(csv)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.repo.savePrices(securityId, prices, priceSourceCSV); err != nil {
+		return 0, err
+	}
+
+	return len(prices), nil
+}
+
+func (s *service) getEvents(userId, accountId uint) ([]EventResponse, error) {
+	if err := s.checkAccount(userId, accountId); err != nil {
+		return nil, err
+	}
+
+	events, err := s.repo.getEvents([]uint{accountId})
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]EventResponse, len(events))
+	for i, e := range events {
+		response[i] = EventToResponse(&e)
+	}
+
+	return response, nil
+}
+
+func (s *service) createEvent(userId, accountId uint, event createEventDTO) (*EventResponse, error) {
+	if err := s.checkAccount(userId, accountId); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkSecurity(userId, event.SecurityID); err != nil {
+		return nil, err
+	}
+
+	if event.Type == SELL && event.LotMethod == "" {
+		event.LotMethod = FIFO
+	}
+
+	if event.Type != SELL && (event.LotMethod != "" || event.LotID != nil) {
+		return nil, fmt.Errorf("lot method and lot are allowed only for %s events", SELL)
+	}
+
+	if event.LotMethod == SPECIFIC && event.LotID == nil {
+		return nil, fmt.Errorf("lotId is required for %s lot method", SPECIFIC)
+	}
+
+	if event.LotMethod != SPECIFIC && event.LotID != nil {
+		return nil, fmt.Errorf("lotId is allowed only for %s lot method", SPECIFIC)
+	}
+
+	// Replay the account history with the new event, a sell can't exceed the quantity held at that date
+	events, err := s.repo.getEvents([]uint{accountId})
+	if err != nil {
+		return nil, err
+	}
+
+	events = append(events, entity.SecurityEvent{
+		AccountID:  accountId,
+		SecurityID: event.SecurityID,
+		Type:       string(event.Type),
+		Date:       event.Date,
+		Quantity:   event.Quantity,
+		UnitPrice:  event.UnitPrice,
+		Fee:        event.Fee,
+		LotMethod:  string(event.LotMethod),
+		LotID:      event.LotID,
+	})
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Date.Before(events[j].Date)
+	})
+
+	if _, err := buildPositions(events); err != nil {
+		return nil, err
+	}
+
+	created, err := s.repo.createEvent(accountId, event)
+	if err != nil {
+		return nil, err
+	}
+
+	// The cash moves with the securities, a buy is paid from the account and a sell is paid to it
+	amount := cashAmount(event.Type, event.Quantity, event.UnitPrice, event.Fee)
+	if amount == 0 {
+		return created, nil
+	}
+
+	description := fmt.Sprintf("%s %g %s", event.Type, event.Quantity, created.Security.Symbol)
+	cash, err := s.transactionService.CreateCashTransaction(userId, accountId, event.Date, amount, description)
+	if err != nil {
+		if err := s.repo.deleteEvent(created.ID); err != nil {
+			s.logger.Errorf("could not delete event %d without its cash: %s", created.ID, err.Error())
+		}
+
+		return nil, fmt.Errorf("could not book the cash of the event: %w", err)
+	}
+
+	if err := s.repo.setEventTransaction(created.ID, cash.ID); err != nil {
+		return nil, err
+	}
+
+	created.TransactionID = &cash.ID
+	return created, nil
+}
+
+// cashAmount is what the event moves on the cash of the account: a buy costs its price and fee,
+// a sell and a dividend bring their amount less the fee
+func cashAmount(eventType EventType, quantity, unitPrice, fee float64) float64 {
+	if eventType == BUY {
+		return -(quantity*unitPrice + fee)
+	}
+
+	return quantity*unitPrice - fee
+}
+
+func (s *service) deleteEvent(userId, id uint) error {
+	ok, err := s.repo.eventExistsAndBelongsToUser(userId, id)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("event with ID %d does not exist or belong to user with ID %d", id, userId)
+	}
+
+	event, err := s.repo.getEvent(id)
+	if err != nil {
+		return err
+	}
+
+	// Removing a buy must not leave a later sell without the quantity it sold
+	events, err := s.repo.getEvents([]uint{event.AccountID})
+	if err != nil {
+		return err
+	}
+
+	remaining := make([]entity.SecurityEvent, 0, len(events))
+	for _, e := range events {
+		if e.ID != id {
+			remaining = append(remaining, e)
+		}
+	}
+
+	if _, err := buildPositions(remaining); err != nil {
+		return fmt.Errorf("event %d cannot be deleted: %w", id, err)
+	}
+
+	if err := s.repo.deleteEvent(id); err != nil {
+		return err
+	}
+
+	// The cash may have been deleted on its own, it doesn't keep the event
+	if event.TransactionID != nil {
+		if err := s.transactionService.DeleteTransaction(userId, *event.TransactionID); err != nil {
+			s.logger.Errorf("could not delete the cash of event %d: %s", id, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (s *service) getHoldings(userId, accountId uint) (*holdingsResponse, error) {
+	if err := s.checkAccount(userId, accountId); err != nil {
+		return nil, err
+	}
+
+	holdings, err := s.holdings([]uint{accountId})
+	if err != nil {
+		return nil, err
+	}
+
+	response := &holdingsResponse{AccountID: accountId, Holdings: make([]Holding, 0)}
+	for _, h := range holdings[accountId] {
+		response.Holdings = append(response.Holdings, h)
+		response.CostBasis += h.CostBasis
+		response.MarketValue += h.MarketValue
+		response.UnrealizedGain += h.UnrealizedGain
+	}
+
+	return response, nil
+}
+
+func (s *service) getOpenLots(userId, accountId uint) ([]Lot, error) {
+	if err := s.checkAccount(userId, accountId); err != nil {
+		return nil, err
+	}
+
+	events, err := s.repo.getEvents([]uint{accountId})
+	if err != nil {
+		return nil, err
+	}
+
+	ledgers, err := matchLots(events)
+	if err != nil {
+		return nil, err
+	}
+
+	lots := make([]Lot, 0)
+	for _, ledger := range ledgers {
+		for _, lot := range ledger.lots {
+			if lot.Remaining > 0 {
+				lots = append(lots, *lot)
+			}
+		}
+	}
+
+	sort.Slice(lots, func(i, j int) bool {
+		return lots[i].AcquiredAt.Before(lots[j].AcquiredAt)
+	})
+
+	return lots, nil
+}
+
+func (s *service) GetLotSales(userId uint, accountId *uint) ([]LotSale, error) {
+	accountIds := []uint{}
+	if accountId != nil {
+		if err := s.checkAccount(userId, *accountId); err != nil {
+			return nil, err
+		}
+
+		accountIds = append(accountIds, *accountId)
+	} else {
+		ids, err := s.repo.getInvestmentAccountIds(userId)
+		if err != nil {
+			return nil, err
+		}
+
+		accountIds = ids
+	}
+
+	events, err := s.repo.getEvents(accountIds)
+	if err != nil {
+		return nil, err
+	}
+
+	ledgers, err := matchLots(events)
+	if err != nil {
+		return nil, err
+	}
+
+	sales := make([]LotSale, 0)
+	for _, ledger := range ledgers {
+		sales = append(sales, ledger.sales...)
+	}
+
+	sort.SliceStable(sales, func(i, j int) bool {
+		if sales[i].SoldAt.Equal(sales[j].SoldAt) {
+			return sales[i].SaleID < sales[j].SaleID
+		}
+
+		return sales[i].SoldAt.Before(sales[j].SoldAt)
+	})
+
+	return sales, nil
+}
+
+func (s *service) GetMarketValues(userId uint) (map[uint]float64, error) {
+	accountIds, err := s.repo.getInvestmentAccountIds(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	holdings, err := s.holdings(accountIds)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[uint]float64, len(accountIds))
+	for _, id := range accountIds {
+		values[id] = 0
+		for _, h := range holdings[id] {
+			values[id] += h.MarketValue
+		}
+	}
+
+	return values, nil
+}
+
+func (s *service) holdings(accountIds []uint) (map[uint][]Holding, error) {
+	events, err := s.repo.getEvents(accountIds)
+	if err != nil {
+		return nil, err
+	}
+
+	var securityIds []uint
+	seen := make(map[uint]bool)
+	for _, e := range events {
+		if !seen[e.SecurityID] {
+			seen[e.SecurityID] = true
+			securityIds = append(securityIds, e.SecurityID)
+		}
+	}
+
+	prices, err := s.repo.getLatestPrices(securityIds)
+	if err != nil {
+		return nil, err
+	}
+
+	return computeHoldings(events, prices)
+}
+
+func (s *service) checkSecurity(userId, securityId uint) error {
+	ok, err := s.repo.securityExistsAndBelongsToUser(userId, securityId, "")
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("security with ID %d does not exist or belong to user with ID %d", securityId, userId)
+	}
+
+	return nil
+}
+
+func (s *service) checkAccount(userId, accountId uint) error {
+	ok, err := s.repo.investmentAccountExistsAndBelongsToUser(userId, accountId)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("investment account with ID %d does not exist or belong to user with ID %d", accountId, userId)
+	}
+
+	return nil
+}
@@ -0,0 +1,311 @@
+package investment
+
+import (
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/emPeeGee/raffinance/internal/entity"
+	"github.com/emPeeGee/raffinance/internal/transaction"
+	"github.com/emPeeGee/raffinance/pkg/log"
+	"gorm.io/gorm"
+)
+
+// fakeRepository keeps the securities and the events of a single user in memory, account 1 is the
+// only investment account
+type fakeRepository struct {
+	securities map[uint]entity.Security
+	events     map[uint]entity.SecurityEvent
+	nextID     uint
+}
+
+func newFakeRepository() *fakeRepository {
+	return &fakeRepository{securities: map[uint]entity.Security{}, events: map[uint]entity.SecurityEvent{}, nextID: 1}
+}
+
+func (r *fakeRepository) id() uint {
+	r.nextID++
+	return r.nextID - 1
+}
+
+func (r *fakeRepository) getSecurities(uint) ([]securityResponse, error) { return nil, nil }
+
+func (r *fakeRepository) createSecurity(_ uint, security createSecurityDTO) (*securityResponse, error) {
+	created := entity.Security{Model: gorm.Model{ID: r.id()}, Symbol: security.Symbol, Name: security.Name, Currency: security.Currency}
+	r.securities[created.ID] = created
+
+	return &securityResponse{ID: created.ID, Symbol: created.Symbol, Name: created.Name, Currency: created.Currency}, nil
+}
+
+func (r *fakeRepository) updateSecurity(_, id uint, security updateSecurityDTO) (*securityResponse, error) {
+	updated := r.securities[id]
+	updated.Symbol, updated.Name, updated.Currency = security.Symbol, security.Name, security.Currency
+	r.securities[id] = updated
+
+	return &securityResponse{ID: id, Symbol: updated.Symbol, Name: updated.Name, Currency: updated.Currency}, nil
+}
+
+func (r *fakeRepository) deleteSecurity(_, id uint) error {
+	delete(r.securities, id)
+	return nil
+}
+
+func (r *fakeRepository) securityExistsAndBelongsToUser(_, id uint, symbol string) (bool, error) {
+	if id > 0 {
+		_, ok := r.securities[id]
+		return ok, nil
+	}
+
+	return r.symbolIsUsed(0, 0, symbol)
+}
+
+func (r *fakeRepository) symbolIsUsed(_, exceptId uint, symbol string) (bool, error) {
+	for _, s := range r.securities {
+		if s.ID != exceptId && s.Symbol == symbol {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (r *fakeRepository) securityIsUsed(uint) error { return nil }
+
+func (r *fakeRepository) getPrices(uint) ([]priceResponse, error)         { return nil, nil }
+func (r *fakeRepository) savePrices(uint, []createPriceDTO, string) error { return nil }
+func (r *fakeRepository) getLatestPrices([]uint) (map[uint]entity.SecurityPrice, error) {
+	return map[uint]entity.SecurityPrice{}, nil
+}
+
+func (r *fakeRepository) getEvents(accountIds []uint) ([]entity.SecurityEvent, error) {
+	var events []entity.SecurityEvent
+	for _, e := range r.events {
+		for _, id := range accountIds {
+			if e.AccountID == id {
+				events = append(events, e)
+			}
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Date.Equal(events[j].Date) {
+			return events[i].ID < events[j].ID
+		}
+
+		return events[i].Date.Before(events[j].Date)
+	})
+
+	return events, nil
+}
+
+func (r *fakeRepository) getEvent(id uint) (*entity.SecurityEvent, error) {
+	e, ok := r.events[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return &e, nil
+}
+
+func (r *fakeRepository) createEvent(accountId uint, event createEventDTO) (*EventResponse, error) {
+	created := entity.SecurityEvent{
+		Model:      gorm.Model{ID: r.id()},
+		AccountID:  accountId,
+		SecurityID: event.SecurityID,
+		Security:   r.securities[event.SecurityID],
+		Type:       string(event.Type),
+		Date:       event.Date,
+		Quantity:   event.Quantity,
+		UnitPrice:  event.UnitPrice,
+		Fee:        event.Fee,
+		LotMethod:  string(event.LotMethod),
+		LotID:      event.LotID,
+	}
+	r.events[created.ID] = created
+
+	response := EventToResponse(&created)
+	return &response, nil
+}
+
+func (r *fakeRepository) setEventTransaction(id, transactionId uint) error {
+	e := r.events[id]
+	e.TransactionID = &transactionId
+	r.events[id] = e
+
+	return nil
+}
+
+func (r *fakeRepository) deleteEvent(id uint) error {
+	delete(r.events, id)
+	return nil
+}
+
+func (r *fakeRepository) eventExistsAndBelongsToUser(_, id uint) (bool, error) {
+	_, ok := r.events[id]
+	return ok, nil
+}
+
+func (r *fakeRepository) investmentAccountExistsAndBelongsToUser(_, accountId uint) (bool, error) {
+	return accountId == 1, nil
+}
+
+func (r *fakeRepository) getInvestmentAccountIds(uint) ([]uint, error) { return []uint{1}, nil }
+
+// fakeTransactionService keeps the cash transactions the events booked
+type fakeTransactionService struct {
+	transaction.Service
+	cash   map[uint]float64
+	nextID uint
+	err    error
+}
+
+func (s *fakeTransactionService) CreateCashTransaction(
+	_, _ uint,
+	_ time.Time,
+	amount float64,
+	_ string,
+) (*transaction.TransactionResponse, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	s.nextID++
+	s.cash[s.nextID] = amount
+
+	return &transaction.TransactionResponse{ID: s.nextID}, nil
+}
+
+func (s *fakeTransactionService) DeleteTransaction(_, id uint) error {
+	delete(s.cash, id)
+	return nil
+}
+
+// balance is the cash of the account
+func (s *fakeTransactionService) balance() float64 {
+	var balance float64
+	for _, amount := range s.cash {
+		balance += amount
+	}
+
+	return balance
+}
+
+func newTestService() (*service, *fakeRepository, *fakeTransactionService) {
+	repo := newFakeRepository()
+	transactionService := &fakeTransactionService{cash: map[uint]float64{}}
+
+	return NewInvestmentService(repo, transactionService, log.New()), repo, transactionService
+}
+
+func TestUpdateSecurityKeepsSymbolsUnique(t *testing.T) {
+	s, _, _ := newTestService()
+
+	apple, err := s.createSecurity(1, createSecurityDTO{Symbol: "AAPL", Name: "Apple", Currency: "USD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msft, err := s.createSecurity(1, createSecurityDTO{Symbol: "MSFT", Name: "Microsoft", Currency: "USD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		symbol string
+		valid  bool
+	}{
+		{name: "symbol of another security", symbol: "MSFT", valid: false},
+		{name: "own symbol", symbol: "AAPL", valid: true},
+		{name: "new symbol", symbol: "APPL", valid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := s.updateSecurity(1, apple.ID, updateSecurityDTO{Symbol: test.symbol, Name: "Apple", Currency: "USD"})
+			if (err == nil) != test.valid {
+				t.Errorf("renaming to %s returned %v, expected valid %t", test.symbol, err, test.valid)
+			}
+		})
+	}
+
+	if _, err := s.createSecurity(1, createSecurityDTO{Symbol: msft.Symbol, Name: "Again", Currency: "USD"}); err == nil {
+		t.Error("created a second security with the symbol MSFT")
+	}
+}
+
+func TestEventsMoveTheCash(t *testing.T) {
+	s, repo, transactionService := newTestService()
+
+	security, err := s.createSecurity(1, createSecurityDTO{Symbol: "VWCE", Name: "Vanguard All-World", Currency: "EUR"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	events := []struct {
+		event createEventDTO
+		cash  float64
+	}{
+		{event: createEventDTO{Type: BUY, Date: day, Quantity: 10, UnitPrice: 100, Fee: 5}, cash: -1005},
+		{event: createEventDTO{Type: SELL, Date: day.AddDate(0, 1, 0), Quantity: 4, UnitPrice: 120, Fee: 2}, cash: -1005 + 478},
+		{event: createEventDTO{Type: DIVIDEND, Date: day.AddDate(0, 2, 0), Quantity: 6, UnitPrice: 0.5}, cash: -1005 + 478 + 3},
+	}
+
+	var created []*EventResponse
+	for _, e := range events {
+		e.event.SecurityID = security.ID
+		event, err := s.createEvent(1, 1, e.event)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if event.TransactionID == nil || repo.events[event.ID].TransactionID == nil {
+			t.Fatalf("the %s event has no cash transaction", e.event.Type)
+		}
+
+		if balance := transactionService.balance(); balance != e.cash {
+			t.Errorf("after the %s the cash is %.2f, expected %.2f", e.event.Type, balance, e.cash)
+		}
+
+		created = append(created, event)
+	}
+
+	// The market value is the 6 shares left, at the price of the last trade
+	values, err := s.GetMarketValues(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if values[1] != 720 {
+		t.Errorf("market value is %.2f, expected 720", values[1])
+	}
+
+	if err := s.deleteEvent(1, created[2].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if balance := transactionService.balance(); balance != -1005+478 {
+		t.Errorf("after deleting the dividend the cash is %.2f, expected %.2f", balance, -1005.0+478)
+	}
+}
+
+func TestCreateEventWithoutCashIsUndone(t *testing.T) {
+	s, repo, transactionService := newTestService()
+	transactionService.err = errors.New("database is down")
+
+	security, err := s.createSecurity(1, createSecurityDTO{Symbol: "VWCE", Name: "Vanguard All-World", Currency: "EUR"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := createEventDTO{SecurityID: security.ID, Type: BUY, Date: time.Now(), Quantity: 1, UnitPrice: 100}
+	if _, err := s.createEvent(1, 1, event); err == nil {
+		t.Fatal("the event was created without its cash")
+	}
+
+	if len(repo.events) != 0 {
+		t.Errorf("%d events are left without their cash", len(repo.events))
+	}
+}
@@ -0,0 +1,36 @@
+package transaction
+
+type TransactionCreated struct {
+	UserID      uint                `json:"-"`
+	Transaction TransactionResponse `json:"transaction"`
+}
+
+func (e TransactionCreated) EventName() string { return "transaction.created" }
+func (e TransactionCreated) EventUserID() uint { return e.UserID }
+
+// TransactionUpdated carries the transaction before the update too, its accounts may have changed
+type TransactionUpdated struct {
+	UserID      uint                `json:"-"`
+	Previous    TransactionResponse `json:"previous"`
+	Transaction TransactionResponse `json:"transaction"`
+}
+
+func (e TransactionUpdated) EventName() string { return "transaction.updated" }
+func (e TransactionUpdated) EventUserID() uint { return e.UserID }
+
+type TransactionDeleted struct {
+	UserID      uint                `json:"-"`
+	Transaction TransactionResponse `json:"transaction"`
+}
+
+func (e TransactionDeleted) EventName() string { return "transaction.deleted" }
+func (e TransactionDeleted) EventUserID() uint { return e.UserID }
+
+// Accounts returns the accounts the transaction moves money on
+func (t TransactionResponse) Accounts() []uint {
+	if t.FromAccountID != nil {
+		return []uint{t.ToAccountID, *t.FromAccountID}
+	}
+
+	return []uint{t.ToAccountID}
+}
@@ -111,7 +111,7 @@ func (h *handler) deleteTransaction(c *gin.Context) {
 		return
 	}
 
-	if err := h.service.deleteTransaction(*userID, uint(transactionId)); err != nil {
+	if err := h.service.DeleteTransaction(*userID, uint(transactionId)); err != nil {
 		h.logger.Info(err.Error())
 		errorutil.NotFound(c, err.Error(), "Not found")
 		return
@@ -0,0 +1,89 @@
+package transaction
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AccountLegsQuery selects every movement of money on an account, one row per account touched.
+// Income and expense move their to_account_id, a transfer moves money out of from_account_id
+// and into to_account_id, so it yields two legs. The amount is signed from the account point of view.
+//
+// Columns: id, account_id, date, category_id, transaction_type_id, amount
+var AccountLegsQuery = fmt.Sprintf(`
+	SELECT t.id, t.to_account_id AS account_id, t.date, t.category_id, t.transaction_type_id,
+		CASE WHEN t.transaction_type_id = %[1]d THEN -t.amount ELSE t.amount END AS amount
+	FROM transactions AS t
+	WHERE t.deleted_at IS NULL
+	UNION ALL
+	SELECT t.id, t.from_account_id AS account_id, t.date, t.category_id, t.transaction_type_id, -t.amount AS amount
+	FROM transactions AS t
+	WHERE t.deleted_at IS NULL AND t.transaction_type_id = %[2]d AND t.from_account_id IS NOT NULL`,
+	EXPENSE, TRANSFER)
+
+// BalanceFilter narrows the legs a balance sums. The zero values don't filter, Before is exclusive
+type BalanceFilter struct {
+	// UserID keeps the accounts of the user which are not deleted
+	UserID     *uint
+	AccountIDs []uint
+	From       *time.Time
+	Before     *time.Time
+}
+
+// Balance sums the signed legs of the filter, the balance of an account or of all the accounts of a
+// user. It is the one balance query, every package reads the balances through it
+func Balance(db *gorm.DB, filter BalanceFilter) (float64, error) {
+	var balance float64
+
+	err := balanceLegs(db, filter).
+		Select("COALESCE(SUM(legs.amount), 0)").
+		Row().Scan(&balance)
+
+	return balance, err
+}
+
+// Balances is Balance per account, the accounts without legs are left out
+func Balances(db *gorm.DB, filter BalanceFilter) (map[uint]float64, error) {
+	var rows []struct {
+		AccountID uint
+		Balance   float64
+	}
+
+	if err := balanceLegs(db, filter).
+		Select("legs.account_id, SUM(legs.amount) AS balance").
+		Group("legs.account_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	balances := make(map[uint]float64, len(rows))
+	for _, row := range rows {
+		balances[row.AccountID] = row.Balance
+	}
+
+	return balances, nil
+}
+
+func balanceLegs(db *gorm.DB, filter BalanceFilter) *gorm.DB {
+	query := db.Table("(?) AS legs", gorm.Expr(AccountLegsQuery))
+
+	if filter.UserID != nil {
+		query = query.Where("legs.account_id IN (SELECT id FROM accounts WHERE user_id = ? AND deleted_at IS NULL)", *filter.UserID)
+	}
+
+	if filter.AccountIDs != nil {
+		query = query.Where("legs.account_id IN ?", filter.AccountIDs)
+	}
+
+	if filter.From != nil {
+		query = query.Where("legs.date >= ?", *filter.From)
+	}
+
+	if filter.Before != nil {
+		query = query.Where("legs.date < ?", *filter.Before)
+	}
+
+	return query
+}
@@ -0,0 +1,70 @@
+package transaction
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestBalanceFilter(t *testing.T) {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID := uint(3)
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	before := from.AddDate(0, 1, 0)
+
+	tests := []struct {
+		name    string
+		filter  BalanceFilter
+		clauses []string
+		absent  []string
+	}{
+		{
+			name:    "accounts of the user",
+			filter:  BalanceFilter{UserID: &userID},
+			clauses: []string{"legs.account_id IN (SELECT id FROM accounts WHERE user_id = $1 AND deleted_at IS NULL)"},
+			absent:  []string{"legs.date"},
+		},
+		{
+			name:    "an account in a month",
+			filter:  BalanceFilter{AccountIDs: []uint{7}, From: &from, Before: &before},
+			clauses: []string{"legs.account_id IN ($1)", "legs.date >= $2", "legs.date < $3"},
+			absent:  []string{"user_id"},
+		},
+		{
+			name:    "no account",
+			filter:  BalanceFilter{AccountIDs: []uint{}},
+			clauses: []string{"legs.account_id IN (NULL)"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var balance []float64
+			sql := balanceLegs(db, test.filter).Select("SUM(legs.amount)").Find(&balance).Statement.SQL.String()
+
+			where := sql[strings.LastIndex(sql, ") AS legs"):]
+			for _, clause := range test.clauses {
+				if !strings.Contains(where, clause) {
+					t.Errorf("%s\ndoes not contain %s", where, clause)
+				}
+			}
+
+			for _, clause := range test.absent {
+				if strings.Contains(where, clause) {
+					t.Errorf("%s\ncontains %s", where, clause)
+				}
+			}
+		})
+	}
+}
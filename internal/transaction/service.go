@@ -2,10 +2,11 @@ package transaction
 
 import (
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/emPeeGee/raffinance/internal/category"
-	"github.com/emPeeGee/raffinance/internal/hub"
+	"github.com/emPeeGee/raffinance/internal/event"
 	"github.com/emPeeGee/raffinance/pkg/log"
 )
 
@@ -14,7 +15,8 @@ type Service interface {
 	// TODO: They are not validated, validation is in handler
 	CreateAdjustmentTransaction(userId, accountId uint, amount float64, trType TransactionType) (*TransactionResponse, error)
 	CreateInitialTransaction(userId, accountId uint, amount float64) (*TransactionResponse, error)
-	deleteTransaction(userId, id uint) error
+	CreateCashTransaction(userId, accountId uint, date time.Time, amount float64, description string) (*TransactionResponse, error)
+	DeleteTransaction(userId, id uint) error
 	updateTransaction(usedId, transactionId uint, transaction UpdateTransactionDTO) (*TransactionResponse, error)
 	getTransaction(userID, txnId uint) (*TransactionResponse, error)
 	GetAccountTransactionsByMonth(accountId uint, year int, month time.Month) ([]TransactionResponse, error)
@@ -25,11 +27,11 @@ type Service interface {
 type service struct {
 	repo   Repository
 	logger log.Logger
-	hub    *hub.Hub
+	events event.Publisher
 }
 
-func NewTransactionService(repo Repository, logger log.Logger, hub *hub.Hub) *service {
-	return &service{repo: repo, logger: logger, hub: hub}
+func NewTransactionService(repo Repository, logger log.Logger, events event.Publisher) *service {
+	return &service{repo: repo, logger: logger, events: events}
 }
 
 func (s *service) createTransaction(userId uint, transaction CreateTransactionDTO) (*TransactionResponse, error) {
@@ -58,18 +60,44 @@ func (s *service) createTransaction(userId uint, transaction CreateTransactionDT
 		return nil, fmt.Errorf("not all tags belong to user or do not exist %v", transaction.TagIDs)
 	}
 
-	return s.repo.createTransaction(userId, transaction)
+	createdTransaction, err := s.repo.createTransaction(userId, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.events.Publish(TransactionCreated{UserID: userId, Transaction: *createdTransaction}); err != nil {
+		return nil, err
+	}
+
+	return createdTransaction, nil
 }
 
+// CreateInitialTransaction books the opening balance, a negative one is a debt and is booked as an expense
 func (s *service) CreateInitialTransaction(userId, accountId uint, amount float64) (*TransactionResponse, error) {
+	return s.CreateCashTransaction(userId, accountId, time.Now(), amount, "Initial balance")
+}
+
+// CreateCashTransaction books a system transaction of the signed amount, an income when it is
+// positive, an expense otherwise
+func (s *service) CreateCashTransaction(
+	userId, accountId uint,
+	date time.Time,
+	amount float64,
+	description string,
+) (*TransactionResponse, error) {
+	trType := INCOME
+	if amount < 0 {
+		trType = EXPENSE
+	}
+
 	transaction := CreateTransactionDTO{
-		Date:              time.Now(),
-		Amount:            amount,
-		Description:       "Initial balance",
+		Date:              date,
+		Amount:            math.Abs(amount),
+		Description:       description,
 		Location:          "",
 		ToAccountID:       accountId,
 		CategoryID:        category.SystemCategoryID,
-		TransactionTypeID: byte(INCOME),
+		TransactionTypeID: byte(trType),
 	}
 
 	return s.createTransaction(userId, transaction)
@@ -89,7 +117,7 @@ func (s *service) CreateAdjustmentTransaction(userId, accountId uint, amount flo
 	return s.createTransaction(userId, transaction)
 }
 
-func (s *service) deleteTransaction(userId, id uint) error {
+func (s *service) DeleteTransaction(userId, id uint) error {
 	ok, err := s.repo.transactionExistsAndBelongsToUser(userId, id)
 	if err != nil {
 		return err
@@ -99,7 +127,17 @@ func (s *service) deleteTransaction(userId, id uint) error {
 		return fmt.Errorf("transaction with ID %d does not exist or belong to user with ID %d", id, userId)
 	}
 
-	return s.repo.deleteTransaction(userId, id)
+	// It is read before, so the event carries what was deleted
+	deleted, err := s.repo.getTransaction(id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.deleteTransaction(userId, id); err != nil {
+		return err
+	}
+
+	return s.events.Publish(TransactionDeleted{UserID: userId, Transaction: *deleted})
 }
 
 func (s *service) getTransactions(userId uint) ([]TransactionResponse, error) {
@@ -116,11 +154,6 @@ func (s *service) getTransaction(userID, txnId uint) (*TransactionResponse, erro
 		return nil, fmt.Errorf("transaction with ID %d does not exist or belong to user with ID %d", txnId, userID)
 	}
 
-	client := s.hub.GetClient(userID)
-	if client != nil {
-		client.Send([]byte("Here is a string...."))
-	}
-
 	return s.repo.getTransaction(txnId)
 }
 
@@ -174,5 +207,20 @@ func (s *service) updateTransaction(userId, transactionId uint, transaction Upda
 		return nil, fmt.Errorf("not all tags belong to user or do not exist %v", transaction.TagIDs)
 	}
 
-	return s.repo.updateTransaction(transactionId, transaction)
+	// The event carries the previous state, the transaction may have moved to other accounts
+	previous, err := s.repo.getTransaction(transactionId)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := s.repo.updateTransaction(transactionId, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.events.Publish(TransactionUpdated{UserID: userId, Previous: *previous, Transaction: *updated}); err != nil {
+		return nil, err
+	}
+
+	return updated, nil
 }
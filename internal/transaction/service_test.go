@@ -0,0 +1,76 @@
+package transaction
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emPeeGee/raffinance/internal/event/eventtest"
+	"github.com/emPeeGee/raffinance/pkg/log"
+)
+
+// fakeRepository keeps the created transactions, every account, category and tag belongs to the user
+type fakeRepository struct {
+	created []CreateTransactionDTO
+}
+
+func (r *fakeRepository) getTransactions(uint) ([]TransactionResponse, error) { return nil, nil }
+func (r *fakeRepository) getTransaction(uint) (*TransactionResponse, error)   { return nil, nil }
+func (r *fakeRepository) findByFilter(TransactionFilter) ([]TransactionResponse, error) {
+	return nil, nil
+}
+
+func (r *fakeRepository) getAccountTransactionsByMonth(uint, int, time.Month) ([]TransactionResponse, error) {
+	return nil, nil
+}
+
+func (r *fakeRepository) createTransaction(_ uint, transaction CreateTransactionDTO) (*TransactionResponse, error) {
+	r.created = append(r.created, transaction)
+
+	return &TransactionResponse{
+		ID:                uint(len(r.created)),
+		Amount:            transaction.Amount,
+		ToAccountID:       transaction.ToAccountID,
+		TransactionTypeID: transaction.TransactionTypeID,
+	}, nil
+}
+
+func (r *fakeRepository) updateTransaction(uint, UpdateTransactionDTO) (*TransactionResponse, error) {
+	return nil, nil
+}
+
+func (r *fakeRepository) deleteTransaction(uint, uint) error { return nil }
+func (r *fakeRepository) transactionExistsAndBelongsToUser(uint, uint) (bool, error) {
+	return true, nil
+}
+func (r *fakeRepository) accountExistsAndBelongsToUser(uint, uint) (bool, error)  { return true, nil }
+func (r *fakeRepository) categoryExistsAndBelongsToUser(uint, uint) (bool, error) { return true, nil }
+func (r *fakeRepository) tagsExistsAndBelongsToUser(uint, []uint) (bool, error)   { return true, nil }
+
+func TestCreateInitialTransaction(t *testing.T) {
+	tests := []struct {
+		name    string
+		balance float64
+		amount  float64
+		trType  TransactionType
+	}{
+		{name: "savings", balance: 2500, amount: 2500, trType: INCOME},
+		{name: "opening debt of a liability", balance: -10000, amount: 10000, trType: EXPENSE},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := &fakeRepository{}
+			s := NewTransactionService(repo, log.New(), eventtest.NewRecorder())
+
+			created, err := s.CreateInitialTransaction(1, 7, test.balance)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if created.Amount != test.amount || created.TransactionTypeID != byte(test.trType) || created.ToAccountID != 7 {
+				t.Errorf("booked %.2f of type %d on account %d, expected %.2f of type %d on account 7",
+					created.Amount, created.TransactionTypeID, created.ToAccountID, test.amount, test.trType)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package webhook
+
+import "time"
+
+type Event string
+
+const (
+	TRANSACTION_CREATED     Event = "transaction.created"
+	TRANSACTION_UPDATED     Event = "transaction.updated"
+	TRANSACTION_DELETED     Event = "transaction.deleted"
+	ACCOUNT_BALANCE_CHANGED Event = "account.balance_changed"
+	CATEGORY_CREATED        Event = "category.created"
+	CATEGORY_UPDATED        Event = "category.updated"
+	CATEGORY_DELETED        Event = "category.deleted"
+)
+
+type DeliveryStatus string
+
+const (
+	PENDING   DeliveryStatus = "pending"
+	SUCCEEDED DeliveryStatus = "succeeded"
+	FAILED    DeliveryStatus = "failed"
+)
+
+const (
+	// maxAttempts is the number of tries before a delivery is given up
+	maxAttempts = 6
+	// the retries wait 30s, 1m, 2m, 4m, 8m, never more than maxBackoff
+	baseBackoff = 30 * time.Second
+	maxBackoff  = time.Hour
+
+	deliveryTimeout = 10 * time.Second
+	// pollInterval is how often the due retries are looked for, new deliveries wake the worker up
+	pollInterval = 15 * time.Second
+	batchSize    = 20
+	// claimTimeout is how long a worker holds a batch, longer than sending it. A batch of a worker
+	// which stopped is sent by another once the claim ended
+	claimTimeout = 5 * time.Minute
+
+	signatureHeader = "X-Raffinance-Signature"
+	eventHeader     = "X-Raffinance-Event"
+	deliveryHeader  = "X-Raffinance-Delivery"
+)
@@ -0,0 +1,51 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+var errBlockedAddress = errors.New("the address of the webhook is not public")
+
+// sharedAddressSpace is the carrier grade NAT range, not routable on the internet either
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicIP tells whether an address may be called by a webhook, the internal services of the
+// server's network, the cloud metadata included, are not
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || sharedAddressSpace.Contains(ip))
+}
+
+// newClient returns the client posting the deliveries. The address is checked when it is dialed,
+// after the name is resolved, so a name resolving to an internal address, or rebound to one after the
+// webhook was saved, is refused as well. The redirects are dialed through the same check
+func newClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: deliveryTimeout}
+
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return fmt.Errorf("%w: %s", errBlockedAddress, host)
+			}
+
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would be dialed instead of the webhook, bypassing the check
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{Timeout: deliveryTimeout, Transport: transport}
+}
@@ -0,0 +1,176 @@
+package webhook
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/emPeeGee/raffinance/internal/auth"
+	"github.com/emPeeGee/raffinance/pkg/errorutil"
+	"github.com/emPeeGee/raffinance/pkg/log"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator"
+)
+
+func RegisterHandlers(apiRg *gin.RouterGroup, service Service, validate *validator.Validate, logger log.Logger) {
+	h := handler{service, logger, validate}
+
+	api := apiRg.Group("/webhooks")
+	{
+		api.GET("", h.getWebhooks)
+		api.POST("", h.createWebhook)
+		api.PUT("/:id", h.updateWebhook)
+		api.DELETE("/:id", h.deleteWebhook)
+		api.GET("/:id/deliveries", h.getDeliveries)
+	}
+
+	apiRg.POST("/webhookDeliveries/:id/redeliver", h.redeliver)
+}
+
+type handler struct {
+	service  Service
+	logger   log.Logger
+	validate *validator.Validate
+}
+
+func (h *handler) getWebhooks(c *gin.Context) {
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	webhooks, err := h.service.getWebhooks(*userId)
+	if err != nil {
+		errorutil.InternalServer(c, "something went wrong, we are working", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, webhooks)
+}
+
+func (h *handler) createWebhook(c *gin.Context) {
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	var input createWebhookDTO
+	if err := c.BindJSON(&input); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	if err := h.validate.Struct(input); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	webhook, err := h.service.createWebhook(*userId, input)
+	if err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	c.JSON(http.StatusCreated, webhook)
+}
+
+func (h *handler) updateWebhook(c *gin.Context) {
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	webhookId, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		errorutil.BadRequest(c, err.Error(), "the id must be an integer")
+		return
+	}
+
+	var input updateWebhookDTO
+	if err := c.BindJSON(&input); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	if err := h.validate.Struct(input); err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	webhook, err := h.service.updateWebhook(*userId, uint(webhookId), input)
+	if err != nil {
+		errorutil.BadRequest(c, err.Error(), "")
+		return
+	}
+
+	c.JSON(http.StatusOK, webhook)
+}
+
+func (h *handler) deleteWebhook(c *gin.Context) {
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	webhookId, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		errorutil.BadRequest(c, err.Error(), "the id must be an integer")
+		return
+	}
+
+	if err := h.service.deleteWebhook(*userId, uint(webhookId)); err != nil {
+		errorutil.NotFound(c, err.Error(), "Not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"ok": true,
+	})
+}
+
+func (h *handler) getDeliveries(c *gin.Context) {
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	webhookId, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		errorutil.BadRequest(c, err.Error(), "the id must be an integer")
+		return
+	}
+
+	deliveries, err := h.service.getDeliveries(*userId, uint(webhookId))
+	if err != nil {
+		errorutil.NotFound(c, err.Error(), "Not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+func (h *handler) redeliver(c *gin.Context) {
+	userId, err := auth.GetUserId(c)
+	if err != nil || userId == nil {
+		errorutil.Unauthorized(c, err.Error(), "you are not authorized")
+		return
+	}
+
+	deliveryId, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		errorutil.BadRequest(c, err.Error(), "the id must be an integer")
+		return
+	}
+
+	delivery, err := h.service.redeliver(*userId, uint(deliveryId))
+	if err != nil {
+		errorutil.NotFound(c, err.Error(), "Not found")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, delivery)
+}
@@ -0,0 +1,62 @@
+package webhook
+
+import "time"
+
+type webhookResponse struct {
+	ID          uint      `json:"id"`
+	URL         string    `json:"url"`
+	Description string    `json:"description"`
+	Events      []string  `json:"events"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// createdWebhookResponse is the only response with the secret, it can't be read later
+type createdWebhookResponse struct {
+	webhookResponse
+	Secret string `json:"secret"`
+}
+
+type createWebhookDTO struct {
+	URL         string   `json:"url" validate:"required,url,max=2048"`
+	Description string   `json:"description" validate:"omitempty,max=256"`
+	Events      []string `json:"events" validate:"required,min=1,unique,dive,webhookevent"`
+}
+
+type updateWebhookDTO struct {
+	URL         string   `json:"url" validate:"required,url,max=2048"`
+	Description string   `json:"description" validate:"omitempty,max=256"`
+	Events      []string `json:"events" validate:"required,min=1,unique,dive,webhookevent"`
+	Active      bool     `json:"active"`
+}
+
+type deliveryResponse struct {
+	ID             uint       `json:"id"`
+	WebhookID      uint       `json:"webhookId"`
+	Event          string     `json:"event"`
+	Payload        string     `json:"payload"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"nextAttemptAt"`
+	LastStatusCode int        `json:"lastStatusCode"`
+	LastError      string     `json:"lastError"`
+	DeliveredAt    *time.Time `json:"deliveredAt"`
+	RedeliveryOf   *uint      `json:"redeliveryOf"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+// payload is the body posted to the webhooks
+type payload struct {
+	ID        string    `json:"id"`
+	Event     Event     `json:"event"`
+	CreatedAt time.Time `json:"createdAt"`
+	Data      any       `json:"data"`
+}
+
+// balanceChanged is the data of the account.balance_changed event
+type balanceChanged struct {
+	AccountID     uint    `json:"accountId"`
+	Balance       float64 `json:"balance"`
+	TransactionID uint    `json:"transactionId"`
+}
@@ -0,0 +1,179 @@
+package webhook
+
+import (
+	"errors"
+	"time"
+
+	"github.com/emPeeGee/raffinance/internal/entity"
+	"github.com/emPeeGee/raffinance/internal/transaction"
+	"github.com/emPeeGee/raffinance/pkg/log"
+	"gorm.io/gorm"
+)
+
+type Repository interface {
+	getWebhooks(userID uint) ([]entity.Webhook, error)
+	getWebhook(userID, id uint) (*entity.Webhook, error)
+	createWebhook(webhook *entity.Webhook) error
+	updateWebhook(webhook *entity.Webhook) error
+	deleteWebhook(userID, id uint) error
+	getSubscribedWebhooks(userID uint, event Event) ([]entity.Webhook, error)
+
+	createDeliveries(deliveries []entity.WebhookDelivery) error
+	getDeliveries(webhookID uint) ([]entity.WebhookDelivery, error)
+	getDelivery(userID, id uint) (*entity.WebhookDelivery, error)
+	claimDueDeliveries(now time.Time, limit int) ([]entity.WebhookDelivery, error)
+	getWebhookByID(id uint) (*entity.Webhook, error)
+	saveDelivery(delivery *entity.WebhookDelivery) error
+
+	getAccountBalance(accountID uint) (float64, error)
+}
+
+type repository struct {
+	db     *gorm.DB
+	logger log.Logger
+}
+
+func NewWebhookRepository(db *gorm.DB, logger log.Logger) *repository {
+	return &repository{db: db, logger: logger}
+}
+
+func (r *repository) getWebhooks(userID uint) ([]entity.Webhook, error) {
+	var webhooks []entity.Webhook
+
+	if err := r.db.Where("user_id = ?", userID).Order("id ASC").Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (r *repository) getWebhook(userID, id uint) (*entity.Webhook, error) {
+	var webhook entity.Webhook
+
+	if err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&webhook).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("webhook not found")
+		}
+
+		return nil, err
+	}
+
+	return &webhook, nil
+}
+
+func (r *repository) getWebhookByID(id uint) (*entity.Webhook, error) {
+	var webhook entity.Webhook
+
+	if err := r.db.First(&webhook, id).Error; err != nil {
+		return nil, err
+	}
+
+	return &webhook, nil
+}
+
+func (r *repository) createWebhook(webhook *entity.Webhook) error {
+	return r.db.Create(webhook).Error
+}
+
+func (r *repository) updateWebhook(webhook *entity.Webhook) error {
+	return r.db.Model(webhook).Updates(map[string]interface{}{
+		"url":         webhook.URL,
+		"description": webhook.Description,
+		"events":      webhook.Events,
+		"active":      webhook.Active,
+	}).Error
+}
+
+func (r *repository) deleteWebhook(userID, id uint) error {
+	result := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&entity.Webhook{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("webhook not found")
+	}
+
+	return nil
+}
+
+func (r *repository) getSubscribedWebhooks(userID uint, event Event) ([]entity.Webhook, error) {
+	var webhooks []entity.Webhook
+
+	if err := r.db.Where("user_id = ? AND active AND ? = ANY(events)", userID, string(event)).
+		Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (r *repository) createDeliveries(deliveries []entity.WebhookDelivery) error {
+	return r.db.Create(&deliveries).Error
+}
+
+func (r *repository) getDeliveries(webhookID uint) ([]entity.WebhookDelivery, error) {
+	var deliveries []entity.WebhookDelivery
+
+	if err := r.db.Where("webhook_id = ?", webhookID).
+		Order("id DESC").
+		Limit(100).
+		Find(&deliveries).Error; err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (r *repository) getDelivery(userID, id uint) (*entity.WebhookDelivery, error) {
+	var delivery entity.WebhookDelivery
+
+	if err := r.db.Joins("JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id").
+		Where("webhook_deliveries.id = ? AND webhooks.user_id = ? AND webhooks.deleted_at IS NULL", id, userID).
+		First(&delivery).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("delivery not found")
+		}
+
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+// claimDueDeliveries locks the due deliveries for the worker and returns them, in one statement. The
+// rows claimed by another instance are skipped, so each delivery is sent by a single worker
+func (r *repository) claimDueDeliveries(now time.Time, limit int) ([]entity.WebhookDelivery, error) {
+	var deliveries []entity.WebhookDelivery
+
+	query := `
+		UPDATE webhook_deliveries SET locked_until = @lockedUntil
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = @status AND next_attempt_at <= @now AND deleted_at IS NULL
+				AND (locked_until IS NULL OR locked_until <= @now)
+			ORDER BY next_attempt_at ASC
+			LIMIT @limit
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`
+
+	if err := r.db.Raw(query, map[string]interface{}{
+		"lockedUntil": now.Add(claimTimeout),
+		"status":      PENDING,
+		"now":         now,
+		"limit":       limit,
+	}).Scan(&deliveries).Error; err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (r *repository) saveDelivery(delivery *entity.WebhookDelivery) error {
+	return r.db.Save(delivery).Error
+}
+
+func (r *repository) getAccountBalance(accountID uint) (float64, error) {
+	return transaction.Balance(r.db, transaction.BalanceFilter{AccountIDs: []uint{accountID}})
+}
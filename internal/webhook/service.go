@@ -0,0 +1,402 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/emPeeGee/raffinance/internal/config"
+	"github.com/emPeeGee/raffinance/internal/entity"
+	"github.com/emPeeGee/raffinance/pkg/log"
+	"github.com/lib/pq"
+)
+
+type Service interface {
+	// Dispatch queues the event for the webhooks of the user subscribed to it. The deliveries are
+	// saved before it returns and sent in the background
+	Dispatch(userID uint, event Event, data any) error
+	dispatchBalances(userID, transactionID uint, accountIDs []uint) error
+
+	getWebhooks(userID uint) ([]webhookResponse, error)
+	createWebhook(userID uint, webhook createWebhookDTO) (*createdWebhookResponse, error)
+	updateWebhook(userID, id uint, webhook updateWebhookDTO) (*webhookResponse, error)
+	deleteWebhook(userID, id uint) error
+	getDeliveries(userID, webhookID uint) ([]deliveryResponse, error)
+	redeliver(userID, deliveryID uint) (*deliveryResponse, error)
+	// Run sends the due deliveries until the context is done
+	Run(ctx context.Context)
+}
+
+type service struct {
+	repo   Repository
+	logger log.Logger
+	client *http.Client
+	// allowPrivate lets the webhooks call the internal addresses, for development only
+	allowPrivate bool
+	// wake tells the worker new deliveries are ready
+	wake chan struct{}
+}
+
+func NewWebhookService(repo Repository, cfg config.Webhook, logger log.Logger) *service {
+	return &service{
+		repo:         repo,
+		logger:       logger,
+		client:       newClient(cfg.AllowPrivateURLs),
+		allowPrivate: cfg.AllowPrivateURLs,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// validURL refuses early an address which is not public. A name is only checked when a delivery
+// dials it, it may resolve differently by then
+func (s *service) validURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("the url must use http or https")
+	}
+
+	if ip := net.ParseIP(parsed.Hostname()); ip != nil && !s.allowPrivate && !publicIP(ip) {
+		return errBlockedAddress
+	}
+
+	return nil
+}
+
+func (s *service) getWebhooks(userID uint) ([]webhookResponse, error) {
+	webhooks, err := s.repo.getWebhooks(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]webhookResponse, len(webhooks))
+	for i := range webhooks {
+		response[i] = entityToResponse(&webhooks[i])
+	}
+
+	return response, nil
+}
+
+func (s *service) createWebhook(userID uint, input createWebhookDTO) (*createdWebhookResponse, error) {
+	if err := s.validURL(input.URL); err != nil {
+		return nil, err
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook := entity.Webhook{
+		UserID:      userID,
+		URL:         input.URL,
+		Description: input.Description,
+		Events:      pq.StringArray(input.Events),
+		Secret:      secret,
+		Active:      true,
+	}
+
+	if err := s.repo.createWebhook(&webhook); err != nil {
+		return nil, err
+	}
+
+	return &createdWebhookResponse{webhookResponse: entityToResponse(&webhook), Secret: secret}, nil
+}
+
+func (s *service) updateWebhook(userID, id uint, input updateWebhookDTO) (*webhookResponse, error) {
+	if err := s.validURL(input.URL); err != nil {
+		return nil, err
+	}
+
+	webhook, err := s.repo.getWebhook(userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook.URL = input.URL
+	webhook.Description = input.Description
+	webhook.Events = pq.StringArray(input.Events)
+	webhook.Active = input.Active
+
+	if err := s.repo.updateWebhook(webhook); err != nil {
+		return nil, err
+	}
+
+	response := entityToResponse(webhook)
+	return &response, nil
+}
+
+func (s *service) deleteWebhook(userID, id uint) error {
+	return s.repo.deleteWebhook(userID, id)
+}
+
+func (s *service) getDeliveries(userID, webhookID uint) ([]deliveryResponse, error) {
+	if _, err := s.repo.getWebhook(userID, webhookID); err != nil {
+		return nil, err
+	}
+
+	deliveries, err := s.repo.getDeliveries(webhookID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]deliveryResponse, len(deliveries))
+	for i := range deliveries {
+		response[i] = deliveryToResponse(&deliveries[i])
+	}
+
+	return response, nil
+}
+
+// redeliver queues a copy of the delivery with the same payload, the log of the original is kept
+func (s *service) redeliver(userID, deliveryID uint) (*deliveryResponse, error) {
+	original, err := s.repo.getDelivery(userID, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	deliveries := []entity.WebhookDelivery{{
+		WebhookID:     original.WebhookID,
+		Event:         original.Event,
+		Payload:       original.Payload,
+		Status:        string(PENDING),
+		NextAttemptAt: &now,
+		RedeliveryOf:  &original.ID,
+	}}
+
+	if err := s.repo.createDeliveries(deliveries); err != nil {
+		return nil, err
+	}
+
+	s.notify()
+
+	response := deliveryToResponse(&deliveries[0])
+	return &response, nil
+}
+
+func (s *service) Dispatch(userID uint, event Event, data any) error {
+	return s.enqueue(userID, event, data)
+}
+
+// dispatchBalances sends the current balance of the accounts touched by a transaction
+func (s *service) dispatchBalances(userID, transactionID uint, accountIDs []uint) error {
+	seen := make(map[uint]bool, len(accountIDs))
+	for _, accountID := range accountIDs {
+		if seen[accountID] {
+			continue
+		}
+		seen[accountID] = true
+
+		balance, err := s.repo.getAccountBalance(accountID)
+		if err != nil {
+			return err
+		}
+
+		data := balanceChanged{AccountID: accountID, Balance: balance, TransactionID: transactionID}
+		if err := s.enqueue(userID, ACCOUNT_BALANCE_CHANGED, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *service) enqueue(userID uint, event Event, data any) error {
+	webhooks, err := s.repo.getSubscribedWebhooks(userID, event)
+	if err != nil || len(webhooks) == 0 {
+		return err
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(payload{ID: id, Event: event, CreatedAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	deliveries := make([]entity.WebhookDelivery, len(webhooks))
+	for i, webhook := range webhooks {
+		deliveries[i] = entity.WebhookDelivery{
+			WebhookID:     webhook.ID,
+			Event:         string(event),
+			Payload:       string(body),
+			Status:        string(PENDING),
+			NextAttemptAt: &now,
+		}
+	}
+
+	if err := s.repo.createDeliveries(deliveries); err != nil {
+		return err
+	}
+
+	s.notify()
+	return nil
+}
+
+// notify wakes the worker up without blocking, one pending signal is enough
+func (s *service) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *service) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.deliverDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+func (s *service) deliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		deliveries, err := s.repo.claimDueDeliveries(time.Now(), batchSize)
+		if err != nil {
+			s.logger.Errorf("due webhook deliveries could not be loaded: %s", err.Error())
+			return
+		}
+
+		for i := range deliveries {
+			s.attempt(ctx, &deliveries[i])
+		}
+
+		if len(deliveries) < batchSize {
+			return
+		}
+	}
+}
+
+// attempt posts the delivery once and schedules the next attempt when it fails
+func (s *service) attempt(ctx context.Context, delivery *entity.WebhookDelivery) {
+	webhook, err := s.repo.getWebhookByID(delivery.WebhookID)
+	if err != nil {
+		// The webhook was deleted in the meantime
+		delivery.Status = string(FAILED)
+		delivery.LastError = "webhook not found"
+		delivery.NextAttemptAt = nil
+		s.save(delivery)
+		return
+	}
+
+	delivery.Attempts++
+	statusCode, err := s.post(ctx, webhook, delivery)
+	delivery.LastStatusCode = statusCode
+
+	switch {
+	case err == nil:
+		now := time.Now()
+		delivery.Status = string(SUCCEEDED)
+		delivery.DeliveredAt = &now
+		delivery.NextAttemptAt = nil
+		delivery.LastError = ""
+	case delivery.Attempts >= maxAttempts:
+		delivery.Status = string(FAILED)
+		delivery.NextAttemptAt = nil
+		delivery.LastError = truncate(err.Error())
+	default:
+		next := time.Now().Add(backoff(delivery.Attempts))
+		delivery.NextAttemptAt = &next
+		delivery.LastError = truncate(err.Error())
+	}
+
+	s.save(delivery)
+}
+
+func (s *service) post(ctx context.Context, webhook *entity.Webhook, delivery *entity.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "Raffinance-Webhooks/1.0")
+	request.Header.Set(eventHeader, delivery.Event)
+	request.Header.Set(deliveryHeader, fmt.Sprint(delivery.ID))
+	request.Header.Set(signatureHeader, sign(webhook.Secret, body, time.Now()))
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	// The body is drained, so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("the webhook answered %s", response.Status)
+	}
+
+	return response.StatusCode, nil
+}
+
+// save records the attempt and releases the claim of the worker
+func (s *service) save(delivery *entity.WebhookDelivery) {
+	delivery.LockedUntil = nil
+
+	if err := s.repo.saveDelivery(delivery); err != nil {
+		s.logger.Errorf("webhook delivery %d could not be saved: %s", delivery.ID, err.Error())
+	}
+}
+
+func truncate(message string) string {
+	if len(message) > 512 {
+		return message[:512]
+	}
+
+	return message
+}
+
+func entityToResponse(webhook *entity.Webhook) webhookResponse {
+	return webhookResponse{
+		ID:          webhook.ID,
+		URL:         webhook.URL,
+		Description: webhook.Description,
+		Events:      webhook.Events,
+		Active:      webhook.Active,
+		CreatedAt:   webhook.CreatedAt,
+		UpdatedAt:   webhook.UpdatedAt,
+	}
+}
+
+func deliveryToResponse(delivery *entity.WebhookDelivery) deliveryResponse {
+	return deliveryResponse{
+		ID:             delivery.ID,
+		WebhookID:      delivery.WebhookID,
+		Event:          delivery.Event,
+		Payload:        delivery.Payload,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		NextAttemptAt:  delivery.NextAttemptAt,
+		LastStatusCode: delivery.LastStatusCode,
+		LastError:      delivery.LastError,
+		DeliveredAt:    delivery.DeliveredAt,
+		RedeliveryOf:   delivery.RedeliveryOf,
+		CreatedAt:      delivery.CreatedAt,
+	}
+}
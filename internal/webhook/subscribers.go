@@ -0,0 +1,49 @@
+package webhook
+
+import (
+	"github.com/emPeeGee/raffinance/internal/category"
+	"github.com/emPeeGee/raffinance/internal/event"
+	"github.com/emPeeGee/raffinance/internal/transaction"
+)
+
+// RegisterSubscribers forwards the domain events to the webhooks. The payloads are the resources, not
+// the events, so they don't change when the events do
+func RegisterSubscribers(bus *event.Bus, service Service) {
+	event.On(bus, event.Async, func(e transaction.TransactionCreated) error {
+		if err := service.Dispatch(e.UserID, TRANSACTION_CREATED, e.Transaction); err != nil {
+			return err
+		}
+
+		return service.dispatchBalances(e.UserID, e.Transaction.ID, e.Transaction.Accounts())
+	})
+
+	event.On(bus, event.Async, func(e transaction.TransactionUpdated) error {
+		if err := service.Dispatch(e.UserID, TRANSACTION_UPDATED, e.Transaction); err != nil {
+			return err
+		}
+
+		// The accounts it left change balance too
+		accounts := append(e.Previous.Accounts(), e.Transaction.Accounts()...)
+		return service.dispatchBalances(e.UserID, e.Transaction.ID, accounts)
+	})
+
+	event.On(bus, event.Async, func(e transaction.TransactionDeleted) error {
+		if err := service.Dispatch(e.UserID, TRANSACTION_DELETED, e.Transaction); err != nil {
+			return err
+		}
+
+		return service.dispatchBalances(e.UserID, e.Transaction.ID, e.Transaction.Accounts())
+	})
+
+	event.On(bus, event.Async, func(e category.CategoryCreated) error {
+		return service.Dispatch(e.UserID, CATEGORY_CREATED, e.Category)
+	})
+
+	event.On(bus, event.Async, func(e category.CategoryUpdated) error {
+		return service.Dispatch(e.UserID, CATEGORY_UPDATED, e.Category)
+	})
+
+	event.On(bus, event.Async, func(e category.CategoryDeleted) error {
+		return service.Dispatch(e.UserID, CATEGORY_DELETED, map[string]uint{"id": e.CategoryID})
+	})
+}
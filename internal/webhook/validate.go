@@ -0,0 +1,21 @@
+package webhook
+
+import (
+	"github.com/emPeeGee/raffinance/pkg/util"
+	"github.com/go-playground/validator"
+)
+
+// Events lists the events a webhook can subscribe to
+var Events = []Event{
+	TRANSACTION_CREATED,
+	TRANSACTION_UPDATED,
+	TRANSACTION_DELETED,
+	ACCOUNT_BALANCE_CHANGED,
+	CATEGORY_CREATED,
+	CATEGORY_UPDATED,
+	CATEGORY_DELETED,
+}
+
+func ValidateEvent(fl validator.FieldLevel) bool {
+	return util.Contains(Events, Event(fl.Field().String()))
+}
@@ -35,3 +35,7 @@ func NotFound(c *gin.Context, message, details string) {
 func Unauthorized(c *gin.Context, message, details string) {
 	c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{Status: http.StatusUnauthorized, Message: message, Details: details})
 }
+
+func Forbidden(c *gin.Context, message, details string) {
+	c.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Status: http.StatusForbidden, Message: message, Details: details})
+}
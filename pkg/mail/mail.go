@@ -0,0 +1,149 @@
+// Package mail sends emails, over SMTP or to the log when no server is configured.
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/emPeeGee/raffinance/pkg/log"
+)
+
+// Message is an email with a plain text body and an optional html alternative
+type Message struct {
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type Sender interface {
+	Send(message Message) error
+}
+
+// SMTPSender delivers the messages to an SMTP server. The connection is upgraded with STARTTLS
+// when the server offers it, so it works both with a real relay and a local catcher like MailHog
+type SMTPSender struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+}
+
+func NewSMTPSender(host, port, username, password, from string) *SMTPSender {
+	return &SMTPSender{
+		addr:     net.JoinHostPort(host, port),
+		host:     host,
+		username: username,
+		password: password,
+		from:     from,
+	}
+}
+
+func (s *SMTPSender) Send(message Message) error {
+	if len(message.To) == 0 {
+		return fmt.Errorf("the message %q has no recipient", message.Subject)
+	}
+
+	var auth smtp.Auth
+	if s.username != "" {
+		auth = smtp.PlainAuth("", s.username, s.password, s.host)
+	}
+
+	body, err := build(s.from, message)
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(s.addr, auth, s.from, message.To, body)
+}
+
+// LogSender only logs the messages, it is used when no SMTP server is configured
+type LogSender struct {
+	logger log.Logger
+}
+
+func NewLogSender(logger log.Logger) *LogSender {
+	return &LogSender{logger: logger}
+}
+
+func (s *LogSender) Send(message Message) error {
+	s.logger.Infof("mail to %s: %s\n%s", strings.Join(message.To, ", "), message.Subject, message.Text)
+	return nil
+}
+
+// build writes the message in the MIME format, as multipart/alternative when it has an html body
+func build(from string, message Message) ([]byte, error) {
+	var buf bytes.Buffer
+
+	header := func(key, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+	}
+
+	header("From", from)
+	header("To", strings.Join(message.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", message.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+
+	if message.HTML == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+
+		if err := writeQuotedPrintable(&buf, message.Text); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	}
+
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	boundary := "raffinance-" + hex.EncodeToString(random)
+
+	header("Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", boundary))
+	buf.WriteString("\r\n")
+
+	for _, part := range []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", message.Text},
+		{"text/html; charset=utf-8", message.HTML},
+	} {
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		header("Content-Type", part.contentType)
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+
+		if err := writeQuotedPrintable(&buf, part.body); err != nil {
+			return nil, err
+		}
+
+		buf.WriteString("\r\n")
+	}
+
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(buf *bytes.Buffer, text string) error {
+	writer := quotedprintable.NewWriter(buf)
+	if _, err := writer.Write([]byte(text)); err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
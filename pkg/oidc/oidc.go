@@ -0,0 +1,305 @@
+// Package oidc signs users in with an OpenID Connect provider, by the authorization code flow with
+// PKCE. The provider is discovered from its issuer, the ID tokens are verified by its published keys
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	httpTimeout = 10 * time.Second
+	// keysRefreshInterval limits how often the keys are fetched again for an unknown kid
+	keysRefreshInterval = time.Minute
+)
+
+// signingMethods are the ID token algorithms accepted, never none nor a HMAC one
+var signingMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Claims are the claims of the ID token used to identify the user
+type Claims struct {
+	jwt.RegisteredClaims
+	Nonce             string `json:"nonce"`
+	Email             string `json:"email"`
+	EmailVerified     flag   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// flag is a boolean some providers send as a string
+type flag bool
+
+func (f *flag) UnmarshalJSON(data []byte) error {
+	value := strings.Trim(string(data), `"`)
+	*f = flag(value == "true")
+	return nil
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu          sync.Mutex
+	discovery   *discovery
+	keys        map[string]interface{}
+	keysFetched time.Time
+}
+
+// NewProvider returns the provider, it is discovered on first use so a provider down doesn't stop
+// the server from starting
+func NewProvider(cfg Config) *Provider {
+	return &Provider{cfg: cfg, client: &http.Client{Timeout: httpTimeout}}
+}
+
+// RandomString returns a random URL safe string, for the state, the nonce and the PKCE verifier
+func RandomString() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// AuthURL is the page of the provider the user is sent to, the PKCE challenge is derived from the verifier
+func (p *Provider) AuthURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.cfg.ClientID)
+	query.Set("redirect_uri", p.cfg.RedirectURL)
+	query.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return d.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange redeems the authorization code and returns the claims of the verified ID token
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	// A confidential client authenticates with basic auth, a public one only names itself
+	if p.cfg.ClientSecret == "" {
+		form.Set("client_id", p.cfg.ClientID)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+
+	if p.cfg.ClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	if err := p.do(request, &token); err != nil {
+		return nil, err
+	}
+
+	if token.Error != "" {
+		return nil, fmt.Errorf("the provider refused the code: %s %s", token.Error, token.ErrorDescription)
+	}
+
+	if token.IDToken == "" {
+		return nil, errors.New("the provider returned no ID token, is the openid scope asked?")
+	}
+
+	return p.verify(ctx, d, token.IDToken, nonce)
+}
+
+// verify checks the signature of the ID token, its issuer, audience, expiry and nonce
+func (p *Provider) verify(ctx context.Context, d *discovery, idToken, nonce string) (*Claims, error) {
+	parser := jwt.NewParser(jwt.WithValidMethods(signingMethods))
+
+	token, err := parser.ParseWithClaims(idToken, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, d, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok {
+		return nil, errors.New("the ID token claims are invalid")
+	}
+
+	if !claims.VerifyIssuer(d.Issuer, true) {
+		return nil, fmt.Errorf("the ID token is issued by %q, not %q", claims.Issuer, d.Issuer)
+	}
+
+	if !claims.VerifyAudience(p.cfg.ClientID, true) {
+		return nil, errors.New("the ID token is not for this client")
+	}
+
+	if !claims.VerifyExpiresAt(time.Now(), true) {
+		return nil, errors.New("the ID token is expired")
+	}
+
+	if claims.Nonce != nonce {
+		return nil, errors.New("the ID token nonce does not match")
+	}
+
+	if claims.Subject == "" {
+		return nil, errors.New("the ID token has no subject")
+	}
+
+	return claims, nil
+}
+
+// discover fetches the configuration of the provider once, it is retried while it fails
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	endpoint := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var d discovery
+	if err := p.do(request, &d); err != nil {
+		return nil, fmt.Errorf("discovery of %s failed: %w", p.cfg.Issuer, err)
+	}
+
+	if d.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("the provider names itself %q, not %q", d.Issuer, p.cfg.Issuer)
+	}
+
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("the configuration of %s misses endpoints", p.cfg.Issuer)
+	}
+
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// key returns the key of the kid. An unknown kid fetches the keys again, the provider may have rotated
+func (p *Provider) key(ctx context.Context, d *discovery, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.lookup(kid); ok {
+		return key, nil
+	}
+
+	if time.Since(p.keysFetched) < keysRefreshInterval {
+		return nil, fmt.Errorf("key %q of the provider is unknown", kid)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, d.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var set jwks
+	if err := p.do(request, &set); err != nil {
+		return nil, fmt.Errorf("the keys of the provider could not be fetched: %w", err)
+	}
+
+	p.keys = set.publicKeys()
+	p.keysFetched = time.Now()
+
+	if key, ok := p.lookup(kid); ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("key %q of the provider is unknown", kid)
+}
+
+// lookup finds the key of the kid, a token without kid is accepted when the provider has one key
+func (p *Provider) lookup(kid string) (interface{}, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func (p *Provider) do(request *http.Request, target interface{}) error {
+	response, err := p.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	// The token endpoint answers its errors with 400 and a JSON body, which is read
+	if response.StatusCode >= 300 && response.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("%s answered %d", request.URL.Host, response.StatusCode)
+	}
+
+	return json.Unmarshal(body, target)
+}
@@ -0,0 +1,202 @@
+package oidc
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emPeeGee/raffinance/pkg/oidc/oidctest"
+)
+
+const (
+	testClientID    = "raffinance"
+	testRedirectURL = "https://raffinance.test/auth/oidc/test/callback"
+)
+
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Provider) {
+	t.Helper()
+
+	idp := oidctest.NewProvider(t, testClientID)
+	p := NewProvider(Config{
+		Issuer:      idp.Issuer(),
+		ClientID:    testClientID,
+		RedirectURL: testRedirectURL,
+		Scopes:      []string{"openid", "email"},
+	})
+
+	return p, idp
+}
+
+// signIn goes through the authorization and redeems the code with the verifier and the nonce given
+func signIn(t *testing.T, p *Provider, idp *oidctest.Provider, claims map[string]interface{}, verifier, nonce string) (*Claims, error) {
+	t.Helper()
+
+	location, err := p.AuthURL(context.Background(), "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, state := idp.Authorize(t, location, claims)
+	if state != "state" {
+		t.Fatalf("the provider redirected back with the state %q", state)
+	}
+
+	return p.Exchange(context.Background(), code, verifier, nonce)
+}
+
+func TestAuthURL(t *testing.T) {
+	p, idp := newTestProvider(t)
+
+	location, err := p.AuthURL(context.Background(), "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := parsed.Query()
+	want := map[string]string{
+		"response_type": "code",
+		"client_id":     testClientID,
+		"redirect_uri":  testRedirectURL,
+		"scope":         "openid email",
+		"state":         "state",
+		"nonce":         "nonce",
+		// base64url of the SHA-256 of "verifier"
+		"code_challenge":        "iMnq5o6zALKXGivsnlom_0F5_WYda32GHkxlV7mq7hQ",
+		"code_challenge_method": "S256",
+	}
+
+	for name, value := range want {
+		if query.Get(name) != value {
+			t.Errorf("%s is %q, expected %q", name, query.Get(name), value)
+		}
+	}
+
+	if !strings.HasPrefix(location, idp.URL+"/authorize?") {
+		t.Errorf("the authorization is at %s", location)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	p, idp := newTestProvider(t)
+
+	claims, err := signIn(t, p, idp, map[string]interface{}{
+		"sub":            "subject",
+		"email":          "ana@example.com",
+		"email_verified": "true",
+		"name":           "Ana",
+	}, "verifier", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Subject != "subject" || claims.Email != "ana@example.com" || !bool(claims.EmailVerified) || claims.Name != "Ana" {
+		t.Errorf("got the claims %+v", claims)
+	}
+}
+
+func TestExchangeAsConfidentialClient(t *testing.T) {
+	p, idp := newTestProvider(t)
+	idp.ClientSecret = "secret&more"
+	p.cfg.ClientSecret = "secret&more"
+
+	if _, err := signIn(t, p, idp, map[string]interface{}{"sub": "subject"}, "verifier", "nonce"); err != nil {
+		t.Fatal(err)
+	}
+
+	p.cfg.ClientSecret = "wrong"
+	if _, err := signIn(t, p, idp, map[string]interface{}{"sub": "subject"}, "verifier", "nonce"); err == nil {
+		t.Error("a wrong client secret was accepted")
+	}
+}
+
+func TestExchangeChecksTheVerifier(t *testing.T) {
+	p, idp := newTestProvider(t)
+
+	_, err := signIn(t, p, idp, map[string]interface{}{"sub": "subject"}, "another verifier", "nonce")
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("got error %v, expected the provider to refuse the verifier", err)
+	}
+}
+
+func TestExchangeVerifiesTheIDToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		nonce  string
+		err    string
+	}{
+		{
+			name:  "another nonce",
+			nonce: "another nonce",
+			err:   "nonce does not match",
+		},
+		{
+			name:   "another issuer",
+			claims: map[string]interface{}{"iss": "https://evil.test"},
+			err:    `issued by "https://evil.test"`,
+		},
+		{
+			name:   "another audience",
+			claims: map[string]interface{}{"aud": "another client"},
+			err:    "not for this client",
+		},
+		{
+			name:   "an audience list without the client",
+			claims: map[string]interface{}{"aud": []string{"another client", "a third one"}},
+			err:    "not for this client",
+		},
+		{
+			name:   "expired",
+			claims: map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()},
+			err:    "expired",
+		},
+		{
+			name:   "without expiry",
+			claims: map[string]interface{}{"exp": nil},
+			err:    "expired",
+		},
+		{
+			name:   "without subject",
+			claims: map[string]interface{}{"sub": ""},
+			err:    "no subject",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, idp := newTestProvider(t)
+
+			claims := map[string]interface{}{"sub": "subject"}
+			for name, value := range test.claims {
+				claims[name] = value
+			}
+
+			nonce := test.nonce
+			if nonce == "" {
+				nonce = "nonce"
+			}
+
+			_, err := signIn(t, p, idp, claims, "verifier", nonce)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, expected %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestDiscoveryChecksTheIssuer(t *testing.T) {
+	idp := oidctest.NewProvider(t, testClientID)
+	p := NewProvider(Config{Issuer: idp.Issuer() + "/", ClientID: testClientID, RedirectURL: testRedirectURL})
+
+	_, err := p.AuthURL(context.Background(), "state", "nonce", "verifier")
+	if err == nil || !strings.Contains(err.Error(), "names itself") {
+		t.Errorf("got error %v, expected the issuer to be refused", err)
+	}
+}
@@ -0,0 +1,218 @@
+// Package oidctest is an OpenID Connect provider for the tests. It serves the discovery, the keys and
+// the token endpoint, the tests play the user signing in at it by Authorize.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const keyID = "test-key"
+
+// Provider signs the ID tokens with an RSA key it publishes. The token endpoint checks the PKCE
+// verifier against the challenge of the authorization, and the client credentials
+type Provider struct {
+	*httptest.Server
+	ClientID string
+	// ClientSecret, when set, must be sent by basic auth like a confidential client does
+	ClientSecret string
+
+	key    *rsa.PrivateKey
+	mutex  sync.Mutex
+	grants map[string]grant
+}
+
+// grant is an authorization code waiting to be redeemed
+type grant struct {
+	challenge   string
+	redirectURI string
+	claims      jwt.MapClaims
+}
+
+// NewProvider starts the provider, it is closed at the end of the test
+func NewProvider(t *testing.T, clientID string) *Provider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Provider{ClientID: clientID, key: key, grants: map[string]grant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/keys", p.keys)
+	mux.HandleFunc("/token", p.token)
+
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+// Issuer is the issuer the provider is configured with
+func (p *Provider) Issuer() string {
+	return p.URL
+}
+
+// Authorize plays the user signing in at the page of the authorization URL. It returns the code and
+// the state the provider redirects back with. The ID token gets the issuer, the audience, the nonce
+// and an expiry of an hour, the claims given are added and override them
+func (p *Provider) Authorize(t *testing.T, authURL string, claims map[string]interface{}) (string, string) {
+	t.Helper()
+
+	location, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := location.Query()
+	if location.Host != p.Listener.Addr().String() || location.Path != "/authorize" {
+		t.Fatalf("the user was sent to %s, not to the provider", authURL)
+	}
+
+	if query.Get("response_type") != "code" || query.Get("client_id") != p.ClientID {
+		t.Fatalf("the authorization asks %q for %q", query.Get("response_type"), query.Get("client_id"))
+	}
+
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("the authorization has no S256 challenge: %s", authURL)
+	}
+
+	now := time.Now()
+	token := jwt.MapClaims{
+		"iss":   p.Issuer(),
+		"aud":   p.ClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": query.Get("nonce"),
+	}
+
+	for name, value := range claims {
+		token[name] = value
+	}
+
+	code := randomString(t)
+
+	p.mutex.Lock()
+	p.grants[code] = grant{
+		challenge:   query.Get("code_challenge"),
+		redirectURI: query.Get("redirect_uri"),
+		claims:      token,
+	}
+	p.mutex.Unlock()
+
+	return code, query.Get("state")
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 p.Issuer(),
+		"authorization_endpoint": p.URL + "/authorize",
+		"token_endpoint":         p.URL + "/token",
+		"jwks_uri":               p.URL + "/keys",
+	})
+}
+
+func (p *Provider) keys(w http.ResponseWriter, _ *http.Request) {
+	public := p.key.PublicKey
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}},
+	})
+}
+
+// token redeems a code once, like a provider does
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeError(w, "invalid_request")
+		return
+	}
+
+	if !p.authenticated(r) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	p.mutex.Lock()
+	g, ok := p.grants[r.PostForm.Get("code")]
+	delete(p.grants, r.PostForm.Get("code"))
+	p.mutex.Unlock()
+
+	if !ok || r.PostForm.Get("redirect_uri") != g.redirectURI {
+		writeError(w, "invalid_grant")
+		return
+	}
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(verifier[:]) != g.challenge {
+		writeError(w, "invalid_grant")
+		return
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, g.claims)
+	token.Header["kid"] = keyID
+
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"token_type": "Bearer", "id_token": idToken})
+}
+
+// authenticated checks the basic auth of a confidential client, or the client_id of a public one
+func (p *Provider) authenticated(r *http.Request) bool {
+	if p.ClientSecret == "" {
+		return r.PostForm.Get("client_id") == p.ClientID
+	}
+
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	id, _ = url.QueryUnescape(id)
+	secret, _ = url.QueryUnescape(secret)
+	return id == p.ClientID && secret == p.ClientSecret
+}
+
+func randomString(t *testing.T) string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+func writeError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,192 @@
+// Package pdf writes simple text documents in the PDF format, with the standard Helvetica fonts,
+// so no font has to be embedded.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A4 page size in points
+const (
+	PageWidth  = 595.28
+	PageHeight = 841.89
+)
+
+type page struct {
+	content bytes.Buffer
+}
+
+// Document is a PDF being built. Coordinates are in points, from the top left corner of the page
+type Document struct {
+	pages []*page
+	bold  bool
+	size  float64
+}
+
+func New() *Document {
+	return &Document{size: 10}
+}
+
+// AddPage starts a new page, the next drawings go to it
+func (d *Document) AddPage() {
+	d.pages = append(d.pages, &page{})
+}
+
+// SetFont selects Helvetica or Helvetica-Bold of the size for the next texts
+func (d *Document) SetFont(bold bool, size float64) {
+	d.bold, d.size = bold, size
+}
+
+func (d *Document) current() *page {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+
+	return d.pages[len(d.pages)-1]
+}
+
+// Text draws the text with its baseline at y
+func (d *Document) Text(x, y float64, text string) {
+	font := "F1"
+	if d.bold {
+		font = "F2"
+	}
+
+	fmt.Fprintf(&d.current().content, "BT /%s %.2f Tf %.2f %.2f Td (%s) Tj ET\n",
+		font, d.size, x, PageHeight-y, escape(encode(text)))
+}
+
+// TextRight draws the text ending at x
+func (d *Document) TextRight(x, y float64, text string) {
+	d.Text(x-d.TextWidth(text), y, text)
+}
+
+// Line draws a thin line between the two points
+func (d *Document) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&d.current().content, "0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, PageHeight-y1, x2, PageHeight-y2)
+}
+
+// TextWidth measures the text in the current font size. Bold text is measured with the regular
+// widths, they only differ for letters, digits are the same
+func (d *Document) TextWidth(text string) float64 {
+	var width float64
+	for _, c := range encode(text) {
+		width += float64(charWidth(c))
+	}
+
+	return width * d.size / 1000
+}
+
+// Truncate shortens the text to fit the width, ending it with dots when cut
+func (d *Document) Truncate(text string, width float64) string {
+	if d.TextWidth(text) <= width {
+		return text
+	}
+
+	runes := []rune(text)
+	for len(runes) > 0 && d.TextWidth(string(runes)+"...") > width {
+		runes = runes[:len(runes)-1]
+	}
+
+	return string(runes) + "..."
+}
+
+// Write outputs the document
+func (d *Document) Write(w io.Writer) error {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+
+	var buf bytes.Buffer
+	var offsets []int
+
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+
+	// Objects 1 to 4 are the catalog, the page tree and the two fonts, then each page is followed by its content
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, p := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>", PageWidth, PageHeight, 6+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.content.Len(), p.content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// encode converts the text to WinAnsiEncoding, the characters out of Latin-1 are replaced by ?
+func encode(text string) []byte {
+	encoded := make([]byte, 0, len(text))
+	for _, r := range text {
+		switch {
+		case r == '\n' || r == '\r' || r == '\t':
+			encoded = append(encoded, ' ')
+		case r < 0x20:
+			continue
+		case r < 0x7f || (r >= 0xa0 && r <= 0xff):
+			encoded = append(encoded, byte(r))
+		case r == '€':
+			encoded = append(encoded, 0x80)
+		default:
+			encoded = append(encoded, '?')
+		}
+	}
+
+	return encoded
+}
+
+func escape(text []byte) string {
+	var b strings.Builder
+	for _, c := range text {
+		if c == '(' || c == ')' || c == '\\' {
+			b.WriteByte('\\')
+		}
+
+		b.WriteByte(c)
+	}
+
+	return b.String()
+}
+
+// helveticaWidths are the widths of the printable ASCII characters, from space to tilde, in thousandths of the size
+var helveticaWidths = [...]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+func charWidth(c byte) int {
+	if c >= 0x20 && c <= 0x7e {
+		return helveticaWidths[c-0x20]
+	}
+
+	return 556
+}
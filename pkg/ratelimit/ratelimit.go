@@ -0,0 +1,85 @@
+// Package ratelimit limits how often a key, like a client IP or an email, may do something.
+// The counts are kept in memory, so each instance limits on its own
+package ratelimit
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/emPeeGee/raffinance/pkg/errorutil"
+	"github.com/gin-gonic/gin"
+)
+
+// Limiter allows limit events per key in any sliding window of the given length
+type Limiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	hits   map[string][]time.Time
+	pruned time.Time
+}
+
+func New(limit int, window time.Duration) *Limiter {
+	return &Limiter{limit: limit, window: window, hits: map[string][]time.Time{}}
+}
+
+// Allow records an event of the key if it is under the limit. When it is not, it returns how long
+// until the oldest event of the window expires
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+
+	hits := recent(l.hits[key], now.Add(-l.window))
+	if len(hits) >= l.limit {
+		l.hits[key] = hits
+		return false, hits[0].Add(l.window).Sub(now)
+	}
+
+	l.hits[key] = append(hits, now)
+	return true, 0
+}
+
+// prune drops the keys without recent events, once per window so a flood of keys doesn't grow the map
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < l.window {
+		return
+	}
+
+	l.pruned = now
+	for key, hits := range l.hits {
+		if len(recent(hits, now.Add(-l.window))) == 0 {
+			delete(l.hits, key)
+		}
+	}
+}
+
+// recent returns the hits after since, they are in chronological order
+func recent(hits []time.Time, since time.Time) []time.Time {
+	for i, hit := range hits {
+		if hit.After(since) {
+			return hits[i:]
+		}
+	}
+
+	return hits[:0]
+}
+
+// Handler returns a middleware which limits the requests of each client IP to the route. The IP is
+// taken from X-Forwarded-For only behind the trusted proxies of the router
+func Handler(limiter *Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ok, retryAfter := limiter.Allow(c.ClientIP() + " " + c.FullPath())
+		if !ok {
+			c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			errorutil.Error(c, http.StatusTooManyRequests, "too many requests", "try again later")
+			return
+		}
+
+		c.Next()
+	}
+}